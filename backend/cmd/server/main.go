@@ -10,26 +10,76 @@ import (
 	"syscall"
 	"time"
 
+	"gohttpserver/internal/accesslog"
+	"gohttpserver/internal/ldap"
+	"gohttpserver/internal/oidc"
+	"gohttpserver/internal/search"
 	"gohttpserver/internal/server"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	rootDir      string
-	port         int
-	httpsPort    int
-	https        bool
-	certFile     string
-	keyFile      string
-	auth         string
-	allowPaths   string
-	denyPaths    string
-	enableWebDAV bool
-	enableUpload bool
-	enableDelete bool
-	webDir       string
-	baseURL      string
+	rootDir       string
+	port          int
+	httpsPort     int
+	https         bool
+	httpsRedirect bool
+	hstsMaxAge    time.Duration
+	certFile      string
+	keyFile       string
+	selfSigned    bool
+	selfSignedDir string
+	tlsHosts      string
+	auth          string
+	sessionTTL    time.Duration
+	tokenFile     string
+	oidcIssuer    string
+	oidcClientID  string
+	oidcSecret    string
+	oidcRedirect  string
+	oidcScopes    string
+	oidcUserClaim string
+	oidcGroups    string
+	ldapURL       string
+	ldapStartTLS  bool
+	ldapCAFile    string
+	ldapInsecure  bool
+	ldapBindDN    string
+	ldapBindPass  string
+	ldapBaseDN    string
+	ldapUserFilt  string
+	ldapUserDN    string
+	ldapGroupBase string
+	ldapGroupFilt string
+	ldapGroupAttr string
+	ldapCacheTTL  time.Duration
+	groupScopes   string
+	clientCA      string
+	clientAuth    string
+	clientCRLs    string
+	clientUser    string
+	allowPaths    string
+	denyPaths     string
+	aclFile       string
+	aclTrace      bool
+	enableWebDAV  bool
+	enableUpload  bool
+	enableDelete  bool
+	enableExtract bool
+	searchIndex   bool
+	indexFile     string
+	indexRescan   time.Duration
+	searchIgnore  string
+	searchHidden  bool
+	symlinks      string
+	webDir        string
+	baseURL       string
+	logFormat     string
+	accessLog     string
+	accessLogSize int
+	accessLogKeep int
+	trustProxies  string
 )
 
 var rootCmd = &cobra.Command{
@@ -41,7 +91,6 @@ var rootCmd = &cobra.Command{
 - File search
 - Resumable downloads (Range requests)
 - Path-level access control
-- WebDAV support
 - curl-friendly API
 - React frontend`,
 	RunE: runServer,
@@ -49,19 +98,65 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.Flags().StringVarP(&rootDir, "root", "r", ".", "Root directory to serve (default: current directory)")
-	rootCmd.Flags().IntVarP(&port, "port", "p", 8080, "HTTP port to listen on (default: 8080)")
+	rootCmd.Flags().IntVarP(&port, "port", "p", 8080, "HTTP port to listen on (default: 8080; 0 with --https serves HTTPS only)")
 	rootCmd.Flags().IntVar(&httpsPort, "https-port", 8443, "HTTPS port to listen on (default: 8443)")
-	rootCmd.Flags().BoolVar(&https, "https", false, "Enable HTTPS")
+	rootCmd.Flags().BoolVar(&https, "https", false, "Enable HTTPS, served alongside HTTP")
+	rootCmd.Flags().BoolVar(&httpsRedirect, "https-redirect", false, "Redirect HTTP requests to HTTPS")
+	rootCmd.Flags().DurationVar(&hstsMaxAge, "hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age sent over HTTPS with --https-redirect, 0 to disable")
 	rootCmd.Flags().StringVar(&certFile, "cert", "", "TLS certificate file (required for HTTPS)")
 	rootCmd.Flags().StringVar(&keyFile, "key", "", "TLS private key file (required for HTTPS)")
+	rootCmd.Flags().BoolVar(&selfSigned, "self-signed", false, "Enable HTTPS with a certificate signed by a generated local CA, kept for later runs")
+	rootCmd.Flags().StringVar(&selfSignedDir, "self-signed-dir", "", "Directory for the generated CA and certificate (default: <user config dir>/gohttpserver/tls)")
+	rootCmd.Flags().StringVar(&tlsHosts, "self-signed-hosts", "", "Comma-separated hostnames and IPs of the generated certificate (default: localhost, 127.0.0.1, ::1 and the hostname)")
 	rootCmd.Flags().StringVar(&auth, "auth", "", "HTTP Basic Auth (format: username:password, or set AUTH env var)")
-	rootCmd.Flags().StringVar(&allowPaths, "allow-paths", "", "Comma-separated list of allowed paths (supports wildcards)")
-	rootCmd.Flags().StringVar(&denyPaths, "deny-paths", "", "Comma-separated list of denied paths (supports wildcards)")
-	rootCmd.Flags().BoolVar(&enableWebDAV, "webdav", true, "Enable WebDAV support (default: true)")
+	rootCmd.Flags().DurationVar(&sessionTTL, "session-ttl", server.DefaultSessionTTL, "Lifetime of browser login sessions")
+	rootCmd.Flags().StringVar(&tokenFile, "token-file", "", "File to store personal API tokens in, enables /api/tokens (default: disabled)")
+	rootCmd.Flags().StringVar(&oidcIssuer, "oidc-issuer", "", "OpenID Connect issuer URL, enables login through the identity provider")
+	rootCmd.Flags().StringVar(&oidcClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	rootCmd.Flags().StringVar(&oidcSecret, "oidc-client-secret", "", "OpenID Connect client secret (or set OIDC_CLIENT_SECRET env var; optional with PKCE)")
+	rootCmd.Flags().StringVar(&oidcRedirect, "oidc-redirect-url", "", "OpenID Connect callback URL (default: <request origin>/api/oidc/callback)")
+	rootCmd.Flags().StringVar(&oidcScopes, "oidc-scopes", strings.Join(oidc.DefaultScopes, ","), "Comma-separated OpenID Connect scopes to request")
+	rootCmd.Flags().StringVar(&oidcUserClaim, "oidc-username-claim", oidc.DefaultUsernameClaim, "ID token claim holding the username")
+	rootCmd.Flags().StringVar(&oidcGroups, "oidc-groups-claim", oidc.DefaultGroupsClaim, "ID token claim holding the user's groups (dots for nested claims)")
+	rootCmd.Flags().StringVar(&ldapURL, "ldap-url", "", "LDAP server URL (ldap://host:389 or ldaps://host:636), enables LDAP password login")
+	rootCmd.Flags().BoolVar(&ldapStartTLS, "ldap-starttls", false, "Upgrade ldap:// connections with StartTLS")
+	rootCmd.Flags().StringVar(&ldapCAFile, "ldap-ca-file", "", "PEM CA certificates to verify the LDAP server with (default: system pool)")
+	rootCmd.Flags().BoolVar(&ldapInsecure, "ldap-insecure-skip-verify", false, "Do not verify the LDAP server's certificate")
+	rootCmd.Flags().StringVar(&ldapBindDN, "ldap-bind-dn", "", "DN of the service account that looks users up (default: anonymous)")
+	rootCmd.Flags().StringVar(&ldapBindPass, "ldap-bind-password", "", "Password of the service account (or set LDAP_BIND_PASSWORD env var)")
+	rootCmd.Flags().StringVar(&ldapBaseDN, "ldap-base-dn", "", "Base DN to search users and groups in")
+	rootCmd.Flags().StringVar(&ldapUserFilt, "ldap-user-filter", ldap.DefaultUserFilter, "Filter finding a user's entry, {username} is replaced")
+	rootCmd.Flags().StringVar(&ldapUserDN, "ldap-user-dn", "", "Bind directly as this DN instead of searching, e.g. uid={username},ou=people,dc=example,dc=com")
+	rootCmd.Flags().StringVar(&ldapGroupBase, "ldap-group-base-dn", "", "Base DN to search groups in (default: --ldap-base-dn)")
+	rootCmd.Flags().StringVar(&ldapGroupFilt, "ldap-group-filter", ldap.DefaultGroupFilter, "Filter finding a user's groups, {dn} and {username} are replaced; empty to only use memberOf")
+	rootCmd.Flags().StringVar(&ldapGroupAttr, "ldap-group-attr", ldap.DefaultGroupAttr, "Attribute holding a group's name")
+	rootCmd.Flags().DurationVar(&ldapCacheTTL, "ldap-cache-ttl", ldap.DefaultCacheTTL, "How long successful LDAP logins are cached, 0 to disable")
+	rootCmd.Flags().StringVar(&groupScopes, "group-scopes", "", "Comma-separated group=scope+scope mappings for LDAP, OIDC and client certificate users, e.g. admins=read+upload+delete,*=read (default: read)")
+	rootCmd.Flags().StringVar(&clientCA, "client-ca", "", "PEM CA certificates to verify client certificates with, enables client certificate authentication (requires --https)")
+	rootCmd.Flags().StringVar(&clientAuth, "client-auth", server.ClientCertRequire, "Client certificate mode: require (every connection) or request (optional)")
+	rootCmd.Flags().StringVar(&clientCRLs, "client-crl", "", "Comma-separated CRL files revoking client certificates (PEM or DER, reloaded when changed)")
+	rootCmd.Flags().StringVar(&clientUser, "client-cert-user", server.CertUserCN, "Client certificate field used as the user: cn, email, dns, uri or dn")
+	rootCmd.Flags().StringVar(&allowPaths, "allow-paths", "", "Comma-separated list of allowed paths (gitignore-style patterns)")
+	rootCmd.Flags().StringVar(&denyPaths, "deny-paths", "", "Comma-separated list of denied paths (gitignore-style patterns)")
+	rootCmd.Flags().StringVar(&aclFile, "acl-file", "", "File of gitignore-style path rules, \"!pattern\" allows (default: none)")
+	rootCmd.Flags().BoolVar(&aclTrace, "acl-trace", false, "Enable /api/admin/acl to explain ACL decisions (default: false)")
+	rootCmd.Flags().BoolVar(&enableWebDAV, "webdav", false, "WebDAV support (not implemented yet: no WebDAV route is served, so this has no effect)")
 	rootCmd.Flags().BoolVar(&enableUpload, "upload", false, "Enable file upload functionality (default: false)")
 	rootCmd.Flags().BoolVar(&enableDelete, "delete", false, "Enable file delete functionality (default: false)")
-	rootCmd.Flags().StringVar(&webDir, "web-dir", "", "Directory for web frontend files (default: empty, no frontend)")
+	rootCmd.Flags().BoolVar(&enableExtract, "extract", false, "Enable server-side archive extraction, requires --upload (default: false)")
+	rootCmd.Flags().BoolVar(&searchIndex, "search-index", false, "Maintain an in-memory filename index for fast search (default: false)")
+	rootCmd.Flags().StringVar(&indexFile, "search-index-file", "", "File to persist the search index across restarts (default: in-memory only)")
+	rootCmd.Flags().DurationVar(&indexRescan, "search-rescan", 10*time.Minute, "Interval between full rescans of the search index, 0 to disable")
+	rootCmd.Flags().StringVar(&searchIgnore, "search-ignore", strings.Join(search.DefaultIgnore, ","), "Comma-separated directory patterns excluded from search")
+	rootCmd.Flags().BoolVar(&searchHidden, "search-hidden", false, "Search inside hidden (dot) directories (default: false)")
+	rootCmd.Flags().StringVar(&symlinks, "symlinks", "root", "Symlink policy: deny (never follow), root (follow within root) or all (follow everywhere)")
+	rootCmd.Flags().StringVar(&webDir, "web-dir", "", "Directory for web frontend files, overrides the embedded frontend (default: empty)")
 	rootCmd.Flags().StringVar(&baseURL, "base-url", "", "Base URL for sharing links (e.g., http://10.0.203.100:8080 or https://example.com:8080). If not set, uses current origin")
+	rootCmd.Flags().StringVar(&logFormat, "log-format", accesslog.FormatText, "Request log format on stdout: text, json or off")
+	rootCmd.Flags().StringVar(&accessLog, "access-log", "", "Also write requests to this file in Apache combined log format (default: disabled)")
+	rootCmd.Flags().IntVar(&accessLogSize, "access-log-max-size", 100, "Rotate the access log file past this many megabytes, 0 to never rotate")
+	rootCmd.Flags().IntVar(&accessLogKeep, "access-log-backups", 5, "Number of rotated access log files kept")
+	rootCmd.Flags().StringVar(&trustProxies, "trusted-proxies", "", "Comma-separated proxy IPs or CIDR ranges whose X-Forwarded-For, X-Real-IP and X-Request-ID headers are trusted")
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
@@ -93,21 +188,84 @@ func runServer(cmd *cobra.Command, args []string) error {
 		baseURLValue = os.Getenv("BASE_URL")
 	}
 
+	// Get the OIDC client secret from environment variable if not provided via flag
+	oidcSecretValue := oidcSecret
+	if oidcSecretValue == "" {
+		oidcSecretValue = os.Getenv("OIDC_CLIENT_SECRET")
+	}
+
+	// Get the LDAP bind password from environment variable if not provided via flag
+	ldapBindPassValue := ldapBindPass
+	if ldapBindPassValue == "" {
+		ldapBindPassValue = os.Getenv("LDAP_BIND_PASSWORD")
+	}
+
 	config := &server.Config{
-		RootDir:      rootDirValue,
-		Port:         portValue,
-		HTTPSPort:    httpsPort,
-		HTTPS:        https,
-		CertFile:     certFile,
-		KeyFile:      keyFile,
-		Auth:         authValue,
-		AllowPaths:   parsePaths(allowPaths),
-		DenyPaths:    parsePaths(denyPaths),
-		EnableWebDAV: enableWebDAV,
-		EnableUpload: enableUpload,
-		EnableDelete: enableDelete,
-		WebDir:       webDir,
-		BaseURL:      baseURLValue,
+		RootDir:       rootDirValue,
+		Port:          portValue,
+		HTTPSPort:     httpsPort,
+		HTTPS:         https || selfSigned,
+		HTTPSRedirect: httpsRedirect,
+		HSTSMaxAge:    hstsMaxAge,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		SelfSigned:    selfSigned,
+		SelfSignedDir: selfSignedDir,
+		TLSHosts:      parsePaths(tlsHosts),
+		Auth:          authValue,
+		SessionTTL:    sessionTTL,
+		TokenFile:     tokenFile,
+		AllowPaths:    parsePaths(allowPaths),
+		DenyPaths:     parsePaths(denyPaths),
+		ACLFile:       aclFile,
+		ACLTrace:      aclTrace,
+		EnableWebDAV:  enableWebDAV,
+		EnableUpload:  enableUpload,
+		EnableDelete:  enableDelete,
+		EnableExtract: enableExtract,
+		SearchIndex:   searchIndex,
+		IndexFile:     indexFile,
+		IndexRescan:   indexRescan,
+		SearchIgnore:  parsePaths(searchIgnore),
+		SearchHidden:  searchHidden,
+		Symlinks:      symlinks,
+		WebDir:        webDir,
+		BaseURL:       baseURLValue,
+		OIDC: oidc.Config{
+			Issuer:        oidcIssuer,
+			ClientID:      oidcClientID,
+			ClientSecret:  oidcSecretValue,
+			RedirectURL:   oidcRedirect,
+			Scopes:        parsePaths(oidcScopes),
+			UsernameClaim: oidcUserClaim,
+			GroupsClaim:   oidcGroups,
+		},
+		LDAP: ldap.Config{
+			URL:                ldapURL,
+			StartTLS:           ldapStartTLS,
+			CAFile:             ldapCAFile,
+			InsecureSkipVerify: ldapInsecure,
+			BindDN:             ldapBindDN,
+			BindPassword:       ldapBindPassValue,
+			BaseDN:             ldapBaseDN,
+			UserFilter:         ldapUserFilt,
+			UserDN:             ldapUserDN,
+			GroupBaseDN:        ldapGroupBase,
+			GroupFilter:        ldapGroupFilt,
+			GroupAttr:          ldapGroupAttr,
+			CacheTTL:           ldapCacheTTL,
+		},
+		GroupScopes: parsePaths(groupScopes),
+		ClientCA:    clientCA,
+		ClientAuth:  clientAuth,
+		ClientCRLs:  parsePaths(clientCRLs),
+		ClientUser:  clientUser,
+
+		LogFormat:        logFormat,
+		AccessLog:        accessLog,
+		AccessLogMaxSize: int64(accessLogSize) << 20,
+		AccessLogBackups: accessLogKeep,
+		TrustedProxies:   parsePaths(trustProxies),
 	}
 
 	httpServer, err := server.NewHTTPServer(config)
@@ -119,12 +277,31 @@ func runServer(cmd *cobra.Command, args []string) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// SIGHUP reloads the certificate, e.g. after it was renewed, and reopens
+	// the access log for external log rotation
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			if err := httpServer.ReloadCertificate(); err != nil {
+				fmt.Printf("Warning: Failed to reload the certificate, keeping the previous one: %v\n", err)
+			}
+			if err := httpServer.ReopenLogs(); err != nil {
+				fmt.Printf("Warning: Failed to reopen the access log: %v\n", err)
+			}
+		}
+	}()
+
+	stopped := make(chan struct{})
 	go func() {
 		<-sigChan
 		fmt.Println("\nShutting down server...")
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		httpServer.Shutdown(ctx)
+		if err := httpServer.Shutdown(ctx); err != nil {
+			fmt.Printf("Warning: Shutdown incomplete: %v\n", err)
+		}
+		close(stopped)
 	}()
 
 	// Start server
@@ -132,6 +309,8 @@ func runServer(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("server error: %w", err)
 	}
 
+	// Start returns as soon as shutdown begins; wait for active requests
+	<-stopped
 	return nil
 }
 
@@ -0,0 +1,133 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only log file that is rotated when it grows past
+// a size. Rotated files are kept as path.1 (the newest) up to path.N.
+type RotatingFile struct {
+	path    string
+	maxSize int64 // Rotate before a write would grow the file past this, 0 to never rotate
+	backups int   // Rotated files kept
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenFile opens path for appending, creating it if needed
+func OpenFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating the file first if p would not fit
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Printf("Warning: Failed to rotate %s: %v\n", f.path, err)
+		}
+		if f.file == nil {
+			return 0, os.ErrClosed
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen reopens the file, for when it was moved away by an external tool
+// such as logrotate. The new file is opened before the old one is closed,
+// so if that fails logging carries on to the old file and the error is
+// returned.
+func (f *RotatingFile) Reopen() error {
+	file, size, err := openAppend(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.file
+	f.file, f.size = file, size
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file. f.mu must be held or f not shared yet.
+func (f *RotatingFile) open() error {
+	file, size, err := openAppend(f.path)
+	if err != nil {
+		return err
+	}
+	f.file, f.size = file, size
+	return nil
+}
+
+// openAppend opens path for appending, creating it if needed, and returns
+// its size
+func openAppend(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// rotate shifts the backups by one, moves the file to path.1 and starts a
+// new one. f.mu must be held.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.backups > 0 {
+		os.Remove(backupName(f.path, f.backups))
+		for i := f.backups - 1; i >= 1; i-- {
+			os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+		}
+		if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+			f.open()
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,67 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("one\n"))
+
+	// logrotate moves the file away; the new file cannot be created yet
+	moved := path + ".1"
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(path, 0755)
+	if err := f.Reopen(); err == nil {
+		t.Fatal("Reopen succeeded with a directory in the way")
+	}
+	if _, err := f.Write([]byte("two\n")); err != nil {
+		t.Fatalf("write after a failed reopen: %v", err)
+	}
+
+	os.Remove(path)
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("three\n"))
+
+	for name, want := range map[string]string{moved: "one\ntwo\n", path: "three\n"} {
+		if data, err := os.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), data, err, want)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the newest backups are kept
+	for name, want := range map[string]string{path: "dddddddd\n", path + ".1": "cccccccc\n", path + ".2": "bbbbbbbb\n"} {
+		if data, err := os.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), data, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("more backups than configured were kept")
+	}
+}
@@ -0,0 +1,179 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the structured log
+const (
+	FormatText = "text" // slog key=value lines
+	FormatJSON = "json" // One JSON object per line
+	FormatOff  = "off"  // No structured log
+)
+
+// Entry is a served request
+type Entry struct {
+	Time      time.Time // When the request started
+	Method    string
+	URI       string // Request URI as sent, with the query
+	Path      string
+	Proto     string
+	Status    int
+	Bytes     int64 // Response body bytes written
+	Duration  time.Duration
+	ClientIP  string
+	User      string // Authenticated user, empty if none
+	RequestID string
+	Referer   string
+	UserAgent string
+}
+
+// Options configures a Logger
+type Options struct {
+	Format  string    // FormatText, FormatJSON or FormatOff
+	Output  io.Writer // Where the structured log goes
+	File    string    // Apache combined log file, disabled if empty
+	MaxSize int64     // Rotate File past this many bytes, 0 to never rotate
+	Backups int       // Rotated files kept
+}
+
+// Logger writes request entries as a structured log and, optionally, an
+// Apache combined log file
+type Logger struct {
+	slog *slog.Logger  // nil if the structured log is off
+	file *RotatingFile // nil without a combined log
+}
+
+// New creates a logger. It returns nil if both logs are disabled.
+func New(opts Options) (*Logger, error) {
+	l := &Logger{}
+	switch opts.Format {
+	case FormatText, "":
+		l.slog = slog.New(slog.NewTextHandler(opts.Output, nil))
+	case FormatJSON:
+		l.slog = slog.New(slog.NewJSONHandler(opts.Output, nil))
+	case FormatOff:
+	default:
+		return nil, fmt.Errorf("invalid log format %q (expected text, json or off)", opts.Format)
+	}
+	if opts.File != "" {
+		f, err := OpenFile(opts.File, opts.MaxSize, opts.Backups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		l.file = f
+	}
+	if l.slog == nil && l.file == nil {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// Log writes e to the enabled logs
+func (l *Logger) Log(e *Entry) {
+	if l.slog != nil {
+		level := slog.LevelInfo
+		if e.Status >= 500 {
+			level = slog.LevelError
+		}
+		l.slog.LogAttrs(context.Background(), level, "request",
+			slog.String("method", e.Method),
+			slog.String("path", e.Path),
+			slog.Int("status", e.Status),
+			slog.Int64("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("client_ip", e.ClientIP),
+			slog.String("user", e.User),
+			slog.String("request_id", e.RequestID),
+		)
+	}
+	if l.file != nil {
+		if _, err := l.file.Write(Combined(e)); err != nil {
+			fmt.Printf("Warning: Failed to write access log: %v\n", err)
+		}
+	}
+}
+
+// Reopen reopens the combined log file, see RotatingFile.Reopen
+func (l *Logger) Reopen() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Reopen()
+}
+
+// Close closes the combined log file
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Combined formats e as an Apache combined log line:
+//
+//	host - user [time] "request" status bytes "referer" "user-agent"
+func Combined(e *Entry) []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, field(e.ClientIP)...)
+	b = append(b, " - "...)
+	b = append(b, field(e.User)...)
+	b = append(b, " ["...)
+	b = e.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, `] "`...)
+	b = append(b, escape(e.Method+" "+e.URI+" "+e.Proto)...)
+	b = append(b, `" `...)
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.Bytes > 0 {
+		b = strconv.AppendInt(b, e.Bytes, 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, ` "`...)
+	b = append(b, quotedField(e.Referer)...)
+	b = append(b, `" "`...)
+	b = append(b, quotedField(e.UserAgent)...)
+	b = append(b, "\"\n"...)
+	return b
+}
+
+// field returns an unquoted field, "-" if empty
+func field(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(escape(s), " ", `\x20`)
+}
+
+// quotedField returns a quoted field's content, "-" if empty
+func quotedField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return escape(s)
+}
+
+// escape escapes quotes, backslashes and non-printable bytes the way Apache
+// does, so a request cannot forge log lines
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
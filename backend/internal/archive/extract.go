@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Extraction statuses reported in ExtractResult
+const (
+	StatusExtracted = "extracted"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+// ErrSkipEntry can be returned by ExtractOptions.Resolve to skip an entry
+// without reporting it as a failure
+var ErrSkipEntry = errors.New("entry skipped")
+
+// ExtractOptions controls how an archive is extracted
+type ExtractOptions struct {
+	Root   string
+	Limits Limits
+	// Resolve maps an entry to the path, relative to Root, it should be written to.
+	// Returning ErrSkipEntry skips the entry; any other error marks it failed.
+	Resolve func(entry Entry) (string, error)
+	// Progress, if set, is called after every entry has been processed
+	Progress func(ExtractResult)
+}
+
+// ExtractResult is the outcome of extracting a single entry
+type ExtractResult struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	IsDir  bool   `json:"is_dir"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExtractSummary aggregates the results of an extraction
+type ExtractSummary struct {
+	Entries   []ExtractResult `json:"entries"`
+	Extracted int             `json:"extracted"`
+	Skipped   int             `json:"skipped"`
+	Failed    int             `json:"failed"`
+	Bytes     int64           `json:"bytes"`
+}
+
+// Extract unpacks the archive at archivePath. Per-entry problems are recorded in
+// the summary; the returned error is only set when the archive itself cannot be
+// read or a limit is exceeded, in which case the summary covers the entries
+// processed so far.
+func Extract(archivePath string, opts ExtractOptions) (*ExtractSummary, error) {
+	summary := &ExtractSummary{Entries: []ExtractResult{}}
+
+	err := WalkArchive(archivePath, opts.Limits, func(entry Entry, r io.Reader) error {
+		result := ExtractResult{Name: entry.Name, IsDir: entry.IsDir}
+
+		target, err := opts.Resolve(entry)
+		switch {
+		case errors.Is(err, ErrSkipEntry):
+			result.Status = StatusSkipped
+		case err != nil:
+			result.Status = StatusFailed
+			result.Error = err.Error()
+		default:
+			result.Path = target
+			n, err := extractEntry(entry, r, filepath.Join(opts.Root, target))
+			result.Size = n
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+			} else {
+				result.Status = StatusExtracted
+			}
+			if errors.Is(err, ErrEntryTooLarge) {
+				summary.record(result, opts.Progress)
+				return err
+			}
+		}
+
+		summary.record(result, opts.Progress)
+		return nil
+	})
+
+	return summary, err
+}
+
+func (s *ExtractSummary) record(result ExtractResult, progress func(ExtractResult)) {
+	s.Entries = append(s.Entries, result)
+	switch result.Status {
+	case StatusExtracted:
+		s.Extracted++
+		s.Bytes += result.Size
+	case StatusSkipped:
+		s.Skipped++
+	default:
+		s.Failed++
+	}
+	if progress != nil {
+		progress(result)
+	}
+}
+
+// extractEntry writes a single entry to target and returns the bytes written
+func extractEntry(entry Entry, r io.Reader, target string) (int64, error) {
+	if entry.IsDir {
+		return 0, os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+
+	dst, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Do not leave truncated files behind
+		os.Remove(target)
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// resolveAll extracts every entry to its own name
+func resolveAll(entry Entry) (string, error) {
+	return filepath.FromSlash(entry.Name), nil
+}
+
+func TestExtract(t *testing.T) {
+	src, root := t.TempDir(), t.TempDir()
+	p := writeZip(t, src, "site.zip",
+		testFile{name: "docs/", dir: true},
+		testFile{name: "docs/a.txt", body: "alpha"},
+		testFile{name: "skip.txt", body: "skipped"},
+		testFile{name: "fail.txt", body: "failed"},
+		testFile{name: "link", symlink: "/etc/passwd"},
+	)
+
+	var progress []string
+	summary, err := Extract(p, ExtractOptions{
+		Root:   root,
+		Limits: DefaultLimits,
+		Resolve: func(entry Entry) (string, error) {
+			switch entry.Name {
+			case "skip.txt":
+				return "", ErrSkipEntry
+			case "fail.txt":
+				return "", errors.New("denied")
+			}
+			return resolveAll(entry)
+		},
+		Progress: func(r ExtractResult) { progress = append(progress, r.Name+":"+r.Status) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Extracted != 2 || summary.Skipped != 1 || summary.Failed != 1 || summary.Bytes != 5 {
+		t.Errorf("summary %+v", summary)
+	}
+	want := []string{"docs:extracted", "docs/a.txt:extracted", "skip.txt:skipped", "fail.txt:failed"}
+	if !slices.Equal(progress, want) {
+		t.Errorf("progress %v, want %v", progress, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "docs", "a.txt")); err != nil || string(data) != "alpha" {
+		t.Errorf("docs/a.txt = %q, %v", data, err)
+	}
+	for _, name := range []string{"skip.txt", "fail.txt", "link"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written", name)
+		}
+	}
+}
+
+func TestExtractZipSlip(t *testing.T) {
+	src := t.TempDir()
+	root := filepath.Join(t.TempDir(), "root")
+	os.Mkdir(root, 0755)
+	for _, p := range []string{
+		writeZip(t, src, "slip.zip", testFile{name: "../evil.txt", body: "x"}),
+		writeTar(t, src, "slip.tar", testFile{name: "a/../../evil.txt", body: "x"}),
+		writeTar(t, src, "abs.tar.gz", testFile{name: "/tmp/evil.txt", body: "x"}),
+	} {
+		_, err := Extract(p, ExtractOptions{Root: root, Limits: DefaultLimits, Resolve: resolveAll})
+		if !errors.Is(err, ErrUnsafeEntry) {
+			t.Errorf("%s: err = %v, want %v", filepath.Base(p), err, ErrUnsafeEntry)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "evil.txt")); !os.IsNotExist(err) {
+		t.Error("an entry was written outside the root")
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	src, root := t.TempDir(), t.TempDir()
+	p := writeTar(t, src, "big.tar",
+		testFile{name: "small.txt", body: "ok"},
+		testFile{name: "big.txt", body: strings.Repeat("x", 100)},
+	)
+
+	summary, err := Extract(p, ExtractOptions{Root: root, Limits: Limits{MaxEntrySize: 10}, Resolve: resolveAll})
+	if !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("err = %v, want %v", err, ErrEntryTooLarge)
+	}
+	// Entries before the failure are kept and reported
+	if summary == nil || summary.Extracted != 1 {
+		t.Errorf("summary %+v", summary)
+	}
+	if _, err := os.Stat(filepath.Join(root, "big.txt")); !os.IsNotExist(err) {
+		t.Error("the oversized entry was written")
+	}
+
+	if _, err := Extract(p, ExtractOptions{Root: t.TempDir(), Limits: Limits{MaxTotalSize: 50}, Resolve: resolveAll}); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("total size: err = %v, want %v", err, ErrArchiveTooLarge)
+	}
+	if _, err := Extract(p, ExtractOptions{Root: t.TempDir(), Limits: Limits{MaxEntries: 1}, Resolve: resolveAll}); !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("entries: err = %v, want %v", err, ErrTooManyEntries)
+	}
+}
@@ -0,0 +1,447 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Supported archive formats
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for files that are not a known archive type
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	// ErrUnsafeEntry is returned for entry names that would escape the archive root
+	ErrUnsafeEntry = errors.New("unsafe archive entry name")
+	// ErrEntryNotFound is returned when the requested entry does not exist
+	ErrEntryNotFound = errors.New("archive entry not found")
+	// ErrTooManyEntries is returned when an archive exceeds Limits.MaxEntries
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	// ErrEntryTooLarge is returned when an entry exceeds Limits.MaxEntrySize or Limits.MaxRatio
+	ErrEntryTooLarge = errors.New("archive entry too large")
+	// ErrArchiveTooLarge is returned when the total uncompressed size exceeds Limits.MaxTotalSize
+	ErrArchiveTooLarge = errors.New("archive uncompressed size too large")
+)
+
+// Limits bounds the work done when reading an archive, to guard against zip bombs.
+// A zero value for any field disables that particular check.
+type Limits struct {
+	MaxEntries   int   // Maximum number of entries
+	MaxEntrySize int64 // Maximum uncompressed size of a single entry
+	MaxTotalSize int64 // Maximum uncompressed size of all entries
+	MaxRatio     int64 // Maximum compression ratio for a single zip entry
+}
+
+// DefaultLimits are the limits used by the HTTP handlers
+var DefaultLimits = Limits{
+	MaxEntries:   10000,
+	MaxEntrySize: 4 << 30,
+	MaxTotalSize: 16 << 30,
+	MaxRatio:     200,
+}
+
+// Entry describes a single member of an archive
+type Entry struct {
+	Name           string `json:"name"`
+	IsDir          bool   `json:"is_dir"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size,omitempty"`
+	ModTime        string `json:"mod_time"`
+}
+
+// DetectFormat returns the archive format for the given file name, or "" if unknown
+func DetectFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(lower, ".tar"):
+		return FormatTar
+	}
+	return ""
+}
+
+// CleanEntryName normalizes an archive entry name and rejects names that are
+// absolute or would traverse outside the extraction root
+func CleanEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\x00") {
+		return "", ErrUnsafeEntry
+	}
+	// Reject Windows drive letters such as "C:foo"
+	if len(name) >= 2 && name[1] == ':' {
+		return "", ErrUnsafeEntry
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", ErrUnsafeEntry
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", ErrUnsafeEntry
+	}
+	return clean, nil
+}
+
+// isRootEntry reports whether an entry name refers to the archive root itself,
+// such as the "./" directory entry written by tar
+func isRootEntry(name string) bool {
+	return path.Clean(strings.ReplaceAll(name, "\\", "/")) == "."
+}
+
+// ListArchive returns the entries of the archive at archivePath
+func ListArchive(archivePath string, limits Limits) ([]Entry, error) {
+	var entries []Entry
+	err := WalkArchive(archivePath, limits, func(entry Entry, _ io.Reader) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// WalkArchive calls fn for every directory and regular file in the archive.
+// The reader passed to fn is only valid for the duration of the call and is
+// nil for directories. Entries with unsafe names abort the walk with ErrUnsafeEntry.
+func WalkArchive(archivePath string, limits Limits, fn func(Entry, io.Reader) error) error {
+	switch DetectFormat(archivePath) {
+	case FormatZip:
+		return walkZip(archivePath, limits, fn)
+	case FormatTar:
+		return walkTar(archivePath, false, limits, fn)
+	case FormatTarGz:
+		return walkTar(archivePath, true, limits, fn)
+	}
+	return ErrUnsupportedFormat
+}
+
+// OpenEntry opens a single file entry inside the archive for reading.
+// The caller must close the returned reader.
+func OpenEntry(archivePath, entryName string, limits Limits) (io.ReadCloser, *Entry, error) {
+	want, err := CleanEntryName(entryName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch DetectFormat(archivePath) {
+	case FormatZip:
+		return openZipEntry(archivePath, want, limits)
+	case FormatTar:
+		return openTarEntry(archivePath, want, false, limits)
+	case FormatTarGz:
+		return openTarEntry(archivePath, want, true, limits)
+	}
+	return nil, nil, ErrUnsupportedFormat
+}
+
+func walkZip(archivePath string, limits Limits, fn func(Entry, io.Reader) error) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+
+	var total int64
+	for _, f := range zr.File {
+		entry, ok, err := zipEntry(f, limits)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		total += entry.Size
+		if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+			return ErrArchiveTooLarge
+		}
+
+		if entry.IsDir {
+			if err := fn(entry, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", entry.Name, err)
+		}
+		err = fn(entry, newLimitedReader(rc, limits.MaxEntrySize))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openZipEntry(archivePath, want string, limits Limits) (io.ReadCloser, *Entry, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range zr.File {
+		name, err := CleanEntryName(f.Name)
+		if err != nil || name != want || f.FileInfo().IsDir() {
+			continue
+		}
+		entry, ok, err := zipEntry(f, limits)
+		if err != nil || !ok {
+			zr.Close()
+			if err == nil {
+				err = ErrEntryNotFound
+			}
+			return nil, nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, nil, err
+		}
+		return &multiCloser{
+			Reader:  newLimitedReader(rc, limits.MaxEntrySize),
+			closers: []io.Closer{rc, zr},
+		}, &entry, nil
+	}
+
+	zr.Close()
+	return nil, nil, ErrEntryNotFound
+}
+
+// zipEntry builds an Entry for a zip member and checks it against limits.
+// Symlinks and other special files are skipped (ok is false).
+func zipEntry(f *zip.File, limits Limits) (entry Entry, ok bool, err error) {
+	if isRootEntry(f.Name) {
+		return Entry{}, false, nil
+	}
+	name, err := CleanEntryName(f.Name)
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	info := f.FileInfo()
+	entry = Entry{
+		Name:           name,
+		IsDir:          info.IsDir(),
+		Size:           int64(f.UncompressedSize64),
+		CompressedSize: int64(f.CompressedSize64),
+		ModTime:        f.Modified.Format("2006-01-02 15:04:05"),
+	}
+	if entry.IsDir {
+		entry.Size = 0
+		return entry, true, nil
+	}
+	if !info.Mode().IsRegular() {
+		return Entry{}, false, nil
+	}
+
+	if limits.MaxEntrySize > 0 && entry.Size > limits.MaxEntrySize {
+		return Entry{}, false, ErrEntryTooLarge
+	}
+	// Only apply the ratio check to entries big enough to matter
+	if limits.MaxRatio > 0 && entry.Size > 1<<20 {
+		if entry.CompressedSize == 0 || entry.Size/entry.CompressedSize > limits.MaxRatio {
+			return Entry{}, false, ErrEntryTooLarge
+		}
+	}
+	return entry, true, nil
+}
+
+func walkTar(archivePath string, gzipped bool, limits Limits, fn func(Entry, io.Reader) error) error {
+	tr, closer, err := openTar(archivePath, gzipped)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	count := 0
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, ok, err := tarEntry(hdr, limits)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		count++
+		if limits.MaxEntries > 0 && count > limits.MaxEntries {
+			return ErrTooManyEntries
+		}
+		total += entry.Size
+		if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+			return ErrArchiveTooLarge
+		}
+
+		var r io.Reader
+		if !entry.IsDir {
+			r = newLimitedReader(tr, limits.MaxEntrySize)
+		}
+		if err := fn(entry, r); err != nil {
+			return err
+		}
+	}
+}
+
+func openTarEntry(archivePath, want string, gzipped bool, limits Limits) (io.ReadCloser, *Entry, error) {
+	tr, closer, err := openTar(archivePath, gzipped)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+
+		count++
+		if limits.MaxEntries > 0 && count > limits.MaxEntries {
+			closer.Close()
+			return nil, nil, ErrTooManyEntries
+		}
+
+		name, err := CleanEntryName(hdr.Name)
+		if err != nil || name != want || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, _, err := tarEntry(hdr, limits)
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+		return &multiCloser{
+			Reader:  newLimitedReader(tr, limits.MaxEntrySize),
+			closers: []io.Closer{closer},
+		}, &entry, nil
+	}
+
+	closer.Close()
+	return nil, nil, ErrEntryNotFound
+}
+
+// tarEntry builds an Entry for a tar header. Links, devices and other special
+// files are skipped (ok is false) so they can never be served or extracted.
+func tarEntry(hdr *tar.Header, limits Limits) (entry Entry, ok bool, err error) {
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir || isRootEntry(hdr.Name) {
+		return Entry{}, false, nil
+	}
+
+	name, err := CleanEntryName(hdr.Name)
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	entry = Entry{
+		Name:    name,
+		IsDir:   hdr.Typeflag == tar.TypeDir,
+		Size:    hdr.Size,
+		ModTime: hdr.ModTime.Format("2006-01-02 15:04:05"),
+	}
+	if entry.IsDir {
+		entry.Size = 0
+	}
+	if limits.MaxEntrySize > 0 && entry.Size > limits.MaxEntrySize {
+		return Entry{}, false, ErrEntryTooLarge
+	}
+	return entry, true, nil
+}
+
+// openTar opens a (possibly gzip-compressed) tar file
+func openTar(archivePath string, gzipped bool) (*tar.Reader, io.Closer, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !gzipped {
+		return tar.NewReader(file), file, nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(gz), &multiCloser{closers: []io.Closer{gz, file}}, nil
+}
+
+// limitedReader fails with ErrEntryTooLarge once more than max bytes are read,
+// since the sizes declared in archive headers cannot be trusted
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newLimitedReader(r io.Reader, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, remaining: max}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		// Probe for one more byte to distinguish EOF from overflow
+		var buf [1]byte
+		n, err := lr.r.Read(buf[:])
+		if n > 0 {
+			return 0, ErrEntryTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+// multiCloser closes several closers in order
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (mc *multiCloser) Close() error {
+	var firstErr error
+	for _, c := range mc.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,312 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testFile is a member of a crafted archive
+type testFile struct {
+	name    string
+	body    string
+	dir     bool
+	symlink string // Link target, makes the entry a symlink
+}
+
+// writeZip writes files to a zip in dir and returns its path
+func writeZip(t *testing.T, dir, name string, files ...testFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()}
+		switch {
+		case f.dir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case f.symlink != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			f.body = f.symlink
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, f.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeArchive(t, dir, name, buf.Bytes())
+}
+
+// writeTar writes files to a tar, gzipped if name ends in .tar.gz
+func writeTar(t *testing.T, dir, name string, files ...testFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if DetectFormat(name) == FormatTarGz {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg, ModTime: time.Now()}
+		switch {
+		case f.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case f.symlink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, f.symlink, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			io.WriteString(tw, f.body)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return writeArchive(t, dir, name, buf.Bytes())
+}
+
+func writeArchive(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func entryNames(entries []Entry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestCleanEntryName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string // Empty for ErrUnsafeEntry
+	}{
+		{"a.txt", "a.txt"},
+		{"dir/b.txt", "dir/b.txt"},
+		{"dir/", "dir"},
+		{"./dir//c.txt", "dir/c.txt"},
+		{`win\path\d.txt`, "win/path/d.txt"},
+		{"dir/./e.txt", "dir/e.txt"},
+		{"../evil", ""},
+		{"dir/../../evil", ""},
+		{"dir/../ok", ""}, // Any ".." is refused, even one that stays inside
+		{`..\evil`, ""},
+		{"/etc/passwd", ""},
+		{`\etc\passwd`, ""},
+		{"C:evil", ""},
+		{"C:/evil", ""},
+		{"a\x00b", ""},
+		{"", ""},
+		{".", ""},
+		{"./", ""},
+	} {
+		got, err := CleanEntryName(tc.name)
+		if tc.want == "" {
+			if !errors.Is(err, ErrUnsafeEntry) {
+				t.Errorf("CleanEntryName(%q) = %q, %v, want %v", tc.name, got, err, ErrUnsafeEntry)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("CleanEntryName(%q) = %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestListArchive(t *testing.T) {
+	dir := t.TempDir()
+	files := []testFile{
+		{name: "./", dir: true},
+		{name: "docs/", dir: true},
+		{name: "docs/a.txt", body: "alpha"},
+		{name: "b.txt", body: "bravo!"},
+		{name: "link", symlink: "/etc/passwd"},
+	}
+	for _, p := range []string{
+		writeZip(t, dir, "a.zip", files...),
+		writeTar(t, dir, "a.tar", files...),
+		writeTar(t, dir, "a.tar.gz", files...),
+	} {
+		entries, err := ListArchive(p, DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(p), err)
+		}
+		// The root entry and the symlink are left out
+		if got, want := entryNames(entries), []string{"docs", "docs/a.txt", "b.txt"}; !slices.Equal(got, want) {
+			t.Errorf("%s: entries %v, want %v", filepath.Base(p), got, want)
+		}
+		if !entries[0].IsDir || entries[2].Size != 6 {
+			t.Errorf("%s: entries %+v", filepath.Base(p), entries)
+		}
+	}
+
+	if _, err := ListArchive(writeArchive(t, dir, "a.rar", nil), DefaultLimits); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("rar: err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestListArchiveUnsafeEntry(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{
+		writeZip(t, dir, "slip.zip", testFile{name: "ok.txt"}, testFile{name: "../../evil.txt", body: "x"}),
+		writeTar(t, dir, "slip.tar", testFile{name: "ok.txt"}, testFile{name: "../../evil.txt", body: "x"}),
+		writeTar(t, dir, "abs.tar.gz", testFile{name: "/etc/cron.d/evil", body: "x"}),
+	} {
+		if _, err := ListArchive(p, DefaultLimits); !errors.Is(err, ErrUnsafeEntry) {
+			t.Errorf("%s: err = %v, want %v", filepath.Base(p), err, ErrUnsafeEntry)
+		}
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	dir := t.TempDir()
+	three := []testFile{{name: "a", body: "aaaa"}, {name: "b", body: "bbbb"}, {name: "c", body: "cccc"}}
+	for _, tc := range []struct {
+		name   string
+		limits Limits
+		want   error
+	}{
+		{"within limits", Limits{MaxEntries: 3, MaxEntrySize: 4, MaxTotalSize: 12}, nil},
+		{"too many entries", Limits{MaxEntries: 2}, ErrTooManyEntries},
+		{"entry too large", Limits{MaxEntrySize: 3}, ErrEntryTooLarge},
+		{"total too large", Limits{MaxTotalSize: 11}, ErrArchiveTooLarge},
+	} {
+		for _, p := range []string{
+			writeZip(t, dir, "limits.zip", three...),
+			writeTar(t, dir, "limits.tar.gz", three...),
+		} {
+			_, err := ListArchive(p, tc.limits)
+			if tc.want == nil && err != nil || !errors.Is(err, tc.want) {
+				t.Errorf("%s, %s: err = %v, want %v", tc.name, filepath.Base(p), err, tc.want)
+			}
+		}
+	}
+}
+
+func TestZipRatioLimit(t *testing.T) {
+	dir := t.TempDir()
+	// 4 MiB of zeros compress by far more than the allowed ratio
+	p := writeZip(t, dir, "bomb.zip", testFile{name: "zeros", body: string(make([]byte, 4<<20))})
+	if _, err := ListArchive(p, Limits{MaxRatio: 200}); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrEntryTooLarge)
+	}
+	if _, _, err := OpenEntry(p, "zeros", Limits{MaxRatio: 200}); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("OpenEntry: err = %v, want %v", err, ErrEntryTooLarge)
+	}
+	if _, err := ListArchive(p, Limits{}); err != nil {
+		t.Errorf("without a ratio limit: %v", err)
+	}
+}
+
+// TestZipLyingSize checks that an entry whose header understates its size
+// cannot be read past the declared size
+func TestZipLyingSize(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "liar.txt",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(body),
+		CompressedSize64:   uint64(len(body)),
+		UncompressedSize64: 10, // The header claims 10 bytes
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(body)
+	zw.Close()
+	p := writeArchive(t, t.TempDir(), "liar.zip", buf.Bytes())
+
+	rc, entry, err := OpenEntry(p, "liar.txt", Limits{MaxEntrySize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if entry.Size != 10 {
+		t.Errorf("declared size %d, want 10", entry.Size)
+	}
+	data, err := io.ReadAll(rc)
+	if err == nil || len(data) > 10 {
+		t.Errorf("read %d bytes, err = %v, want an error within 10 bytes", len(data), err)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	for _, tc := range []struct {
+		size, max int
+		want      error
+	}{
+		{10, 10, nil},
+		{10, 0, nil},
+		{11, 10, ErrEntryTooLarge},
+	} {
+		data, err := io.ReadAll(newLimitedReader(bytes.NewReader(make([]byte, tc.size)), int64(tc.max)))
+		if !errors.Is(err, tc.want) || tc.want == nil && len(data) != tc.size {
+			t.Errorf("size %d, max %d: read %d bytes, err = %v, want %v", tc.size, tc.max, len(data), err, tc.want)
+		}
+		if tc.max > 0 && len(data) > tc.max {
+			t.Errorf("size %d, max %d: read %d bytes", tc.size, tc.max, len(data))
+		}
+	}
+}
+
+func TestOpenEntry(t *testing.T) {
+	dir := t.TempDir()
+	files := []testFile{
+		{name: "docs/", dir: true},
+		{name: "docs/a.txt", body: "alpha"},
+		{name: "link", symlink: "docs/a.txt"},
+	}
+	for _, p := range []string{
+		writeZip(t, dir, "open.zip", files...),
+		writeTar(t, dir, "open.tar", files...),
+	} {
+		name := filepath.Base(p)
+		rc, entry, err := OpenEntry(p, "./docs//a.txt", DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != "alpha" || entry.Name != "docs/a.txt" {
+			t.Errorf("%s: read %q, %v, entry %+v", name, data, err, entry)
+		}
+
+		for _, missing := range []string{"docs", "link", "nope.txt"} {
+			if _, _, err := OpenEntry(p, missing, DefaultLimits); !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("%s: OpenEntry(%q) err = %v, want %v", name, missing, err, ErrEntryNotFound)
+			}
+		}
+		if _, _, err := OpenEntry(p, "../docs/a.txt", DefaultLimits); !errors.Is(err, ErrUnsafeEntry) {
+			t.Errorf("%s: traversal err = %v, want %v", name, err, ErrUnsafeEntry)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// SymlinkPolicy controls which symlinks below the root may be followed
+type SymlinkPolicy string
+
+// Symlink policies
+const (
+	SymlinkDeny   SymlinkPolicy = "deny" // Never follow symlinks
+	SymlinkRoot   SymlinkPolicy = "root" // Follow symlinks that resolve inside the root
+	SymlinkFollow SymlinkPolicy = "all"  // Follow all symlinks, even out of the root
+)
+
+// ErrSymlinkDenied is returned for paths that traverse a symlink the policy
+// does not allow. It wraps os.ErrPermission.
+var ErrSymlinkDenied = fmt.Errorf("symlink not allowed: %w", os.ErrPermission)
+
+// maxSymlinkHops bounds symlink resolution, like the kernel's ELOOP limit
+const maxSymlinkHops = 40
+
+var symlinkPolicy atomic.Value
+
+func init() {
+	symlinkPolicy.Store(SymlinkRoot)
+}
+
+// ParseSymlinkPolicy validates a policy name
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(name); p {
+	case SymlinkDeny, SymlinkRoot, SymlinkFollow:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid symlink policy %q (expected deny, root or all)", name)
+}
+
+// SetSymlinkPolicy sets the policy applied by SanitizePath and CheckSymlinks.
+// It is meant to be called once at startup; the default is SymlinkRoot.
+func SetSymlinkPolicy(p SymlinkPolicy) {
+	symlinkPolicy.Store(p)
+}
+
+// GetSymlinkPolicy returns the policy in effect
+func GetSymlinkPolicy() SymlinkPolicy {
+	return symlinkPolicy.Load().(SymlinkPolicy)
+}
+
+// CheckSymlinks verifies that relPath, a lexically clean path relative to
+// rootDir, only traverses symlinks allowed by the policy. Path components
+// that do not exist yet are fine, so it can be used before creating files;
+// a dangling symlink is checked against where it points.
+func CheckSymlinks(rootDir, relPath string) error {
+	switch GetSymlinkPolicy() {
+	case SymlinkFollow:
+		return nil
+	case SymlinkDeny:
+		return checkNoSymlinks(rootDir, relPath)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolvePath(filepath.Join(rootDir, relPath), 0)
+	if err != nil {
+		return err
+	}
+	if !within(realRoot, resolved) {
+		return ErrSymlinkDenied
+	}
+	return nil
+}
+
+// ResolvePath returns the path relPath, a lexically clean path relative to
+// rootDir, really refers to once symlinks are followed, also relative to
+// rootDir ("" for the root). Access rules must hold for both paths, or a
+// symlink could lead into a denied directory. A path resolving out of the
+// root, which only SymlinkFollow allows, is returned unchanged.
+func ResolvePath(rootDir, relPath string) (string, error) {
+	if GetSymlinkPolicy() == SymlinkDeny {
+		return relPath, checkNoSymlinks(rootDir, relPath)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolvePath(filepath.Join(rootDir, relPath), 0)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, resolved) {
+		if GetSymlinkPolicy() == SymlinkFollow {
+			return relPath, nil
+		}
+		return "", ErrSymlinkDenied
+	}
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return rel, nil
+}
+
+// AllowSymlink reports whether the symlink at relPath may be followed. It is
+// used when walking trees, where symlinks are met as entries.
+func AllowSymlink(rootDir, relPath string) bool {
+	return CheckSymlinks(rootDir, relPath) == nil
+}
+
+// checkNoSymlinks fails if any existing component of relPath is a symlink.
+// The root itself may be a symlink.
+func checkNoSymlinks(rootDir, relPath string) error {
+	cur := rootDir
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		if name == "" || name == "." {
+			continue
+		}
+		cur = filepath.Join(cur, name)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return ErrSymlinkDenied
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the real path of p, resolving symlinks in its existing
+// prefix and following dangling symlinks to where a file would be created
+func resolvePath(p string, hops int) (string, error) {
+	if hops > maxSymlinkHops {
+		return "", fmt.Errorf("too many levels of symbolic links: %s", p)
+	}
+
+	real, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return real, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	parent, name := filepath.Dir(p), filepath.Base(p)
+	if parent == p {
+		return p, nil
+	}
+	realParent, err := resolvePath(parent, hops)
+	if err != nil {
+		return "", err
+	}
+
+	next := filepath.Join(realParent, name)
+	info, err := os.Lstat(next)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return next, nil
+	}
+	// A dangling symlink: creating the file would create its target
+	target, err := os.Readlink(next)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(realParent, target)
+	}
+	return resolvePath(target, hops+1)
+}
+
+// within reports whether p is root or below it
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,50 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(root, "secret"), 0755)
+	os.WriteFile(filepath.Join(root, "secret", "s.txt"), nil, 0644)
+	os.Symlink("secret", filepath.Join(root, "linkdir"))
+	os.Symlink("secret/s.txt", filepath.Join(root, "link.txt"))
+	os.Symlink("secret/new.txt", filepath.Join(root, "dangling"))
+	os.Symlink(outside, filepath.Join(root, "out"))
+	defer SetSymlinkPolicy(GetSymlinkPolicy())
+
+	for _, tc := range []struct {
+		policy SymlinkPolicy
+		path   string
+		want   string // Empty for ErrSymlinkDenied
+	}{
+		{SymlinkRoot, "", ""},
+		{SymlinkRoot, "secret/s.txt", "secret/s.txt"},
+		{SymlinkRoot, "link.txt", "secret/s.txt"},
+		{SymlinkRoot, "linkdir/s.txt", "secret/s.txt"},
+		{SymlinkRoot, "linkdir/missing.txt", "secret/missing.txt"},
+		{SymlinkRoot, "dangling", "secret/new.txt"},
+		{SymlinkRoot, "out/x", ""},
+		{SymlinkFollow, "link.txt", "secret/s.txt"},
+		{SymlinkFollow, "out/x", "out/x"}, // Out of the root, no rules apply
+		{SymlinkDeny, "secret/s.txt", "secret/s.txt"},
+		{SymlinkDeny, "link.txt", ""},
+		{SymlinkDeny, "linkdir/s.txt", ""},
+	} {
+		SetSymlinkPolicy(tc.policy)
+		got, err := ResolvePath(root, filepath.FromSlash(tc.path))
+		if tc.want == "" && tc.path != "" {
+			if !errors.Is(err, ErrSymlinkDenied) {
+				t.Errorf("%s: ResolvePath(%q) = %q, %v, want %v", tc.policy, tc.path, got, err, ErrSymlinkDenied)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tc.want) {
+			t.Errorf("%s: ResolvePath(%q) = %q, %v, want %q", tc.policy, tc.path, got, err, tc.want)
+		}
+	}
+}
@@ -35,6 +35,15 @@ func CreateZip(rootDir, targetPath string, w io.Writer) error {
 			return err
 		}
 
+		// filepath.Walk does not descend into symlinked directories, but
+		// symlinked files would be opened through the link
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil || target.IsDir() || !AllowSymlink(rootDir, relPath) {
+				return nil
+			}
+		}
+
 		// Use forward slashes in zip (zip standard)
 		zipPath := filepath.ToSlash(relPath)
 
@@ -77,24 +86,27 @@ func addFileToZip(zw *zip.Writer, filePath, zipPath, rootDir string) error {
 	return err
 }
 
-// SanitizePath ensures the path is safe and within root directory
+// SanitizePath ensures the path is safe and within root directory, both
+// lexically and after resolving symlinks according to the symlink policy.
+// It returns the lexical path; access rules must also be checked against
+// the path it resolves to, see ResolvePath.
 func SanitizePath(rootDir, requestedPath string) (string, error) {
 	// Clean the path to prevent directory traversal
 	cleanPath := filepath.Clean(requestedPath)
-	
+
 	// Remove leading slashes
 	cleanPath = strings.TrimPrefix(cleanPath, "/")
 	cleanPath = strings.TrimPrefix(cleanPath, "\\")
 
 	// Build full path
 	fullPath := filepath.Join(rootDir, cleanPath)
-	
+
 	// Ensure the path is within root directory
 	absRoot, err := filepath.Abs(rootDir)
 	if err != nil {
 		return "", err
 	}
-	
+
 	absPath, err := filepath.Abs(fullPath)
 	if err != nil {
 		return "", err
@@ -105,5 +117,9 @@ func SanitizePath(rootDir, requestedPath string) (string, error) {
 		return "", os.ErrPermission
 	}
 
+	if err := CheckSymlinks(absRoot, cleanPath); err != nil {
+		return "", err
+	}
+
 	return cleanPath, nil
 }
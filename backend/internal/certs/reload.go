@@ -0,0 +1,127 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// checkInterval limits how often handshakes look for changed files
+	checkInterval = 2 * time.Second
+	// renewRetry is how long to wait after a failed renewal
+	renewRetry = time.Hour
+)
+
+// Reloader serves a certificate and key pair from files and picks up new
+// ones when the files change, without a restart. Established connections
+// keep the certificate they were made with.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu         sync.Mutex
+	cert       *tls.Certificate
+	certMod    time.Time
+	keyMod     time.Time
+	lastCheck  time.Time
+	renew      func() error // Reissues the files, nil if they are renewed elsewhere
+	renewing   bool
+	renewAfter time.Time // No renewal before this, after a failure
+}
+
+// NewReloader loads the pair, failing if it cannot be loaded
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	rl := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := rl.Reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Reload loads the pair again. On failure the previous certificate stays in
+// use.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.load()
+}
+
+// load reads the files. rl.mu must be held.
+func (rl *Reloader) load() error {
+	certMod, keyMod, err := rl.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(rl.certFile, rl.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	if l := leaf(&cert); l != nil && rl.cert != nil {
+		fmt.Printf("Loaded certificate %s (expires %s)\n", l.Subject, l.NotAfter.Format(time.RFC3339))
+	}
+	rl.cert, rl.certMod, rl.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// RenewWith makes the reloader call renew in the background once the
+// certificate is within renewBefore of expiry. renew writes new files,
+// which are then picked up like any other change.
+func (rl *Reloader) RenewWith(renew func() error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.renew = renew
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Every few seconds
+// it checks whether the files changed and reloads them.
+func (rl *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now := time.Now(); now.Sub(rl.lastCheck) >= checkInterval {
+		rl.lastCheck = now
+		certMod, keyMod, err := rl.modTimes()
+		if err == nil && (!certMod.Equal(rl.certMod) || !keyMod.Equal(rl.keyMod)) {
+			if err := rl.load(); err != nil {
+				// Most likely the files are being replaced one at a time;
+				// the next check retries
+				fmt.Printf("Warning: Keeping the current certificate: %v\n", err)
+			}
+		}
+		if l := leaf(rl.cert); rl.renew != nil && !rl.renewing && now.After(rl.renewAfter) && l != nil && l.NotAfter.Sub(now) < renewBefore {
+			rl.renewing = true
+			go rl.runRenew()
+		}
+	}
+	return rl.cert, nil
+}
+
+// runRenew renews the files without holding up the handshake that noticed
+// the certificate is about to expire; it is still valid meanwhile
+func (rl *Reloader) runRenew() {
+	err := rl.renew()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.renewing = false
+	if err != nil {
+		fmt.Printf("Warning: Failed to renew the certificate: %v\n", err)
+		rl.renewAfter = time.Now().Add(renewRetry)
+		return
+	}
+	// Load the new files on the next handshake
+	rl.lastCheck = time.Time{}
+}
+
+func (rl *Reloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(rl.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(rl.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
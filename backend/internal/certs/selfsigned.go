@@ -0,0 +1,299 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File names in the self-signed directory
+const (
+	CAFile        = "ca.pem"
+	caKeyFile     = "ca-key.pem"
+	ServerFile    = "server.pem"
+	serverKeyFile = "server-key.pem"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 397 * 24 * time.Hour // The longest validity browsers accept
+	// renewBefore is how long before expiry the server certificate is renewed
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// SelfSigned is a generated local CA and a server certificate it signed
+type SelfSigned struct {
+	CertFile      string // Server certificate, followed by the CA
+	KeyFile       string
+	CAFile        string // CA certificate for clients to trust
+	CAFingerprint string // SHA-256 of the CA certificate
+	CAGenerated   bool   // The CA was (re)generated, so clients need to trust it again
+	Generated     bool   // The server certificate was (re)generated
+}
+
+// DefaultDir returns the directory self-signed certificates are kept in
+// unless configured otherwise
+func DefaultDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "gohttpserver", "tls")
+	}
+	return filepath.Join(os.TempDir(), "gohttpserver-tls")
+}
+
+// DefaultHosts returns the names the server certificate is issued for
+// unless configured otherwise: localhost, the loopback addresses and the
+// machine's hostname
+func DefaultHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	return hosts
+}
+
+// EnsureSelfSigned loads the CA and server certificate from dir, creating
+// the CA on first use. The server certificate is reissued when it is
+// missing, about to expire, issued for other hosts or by another CA, so
+// clients only need to trust the CA once. The CA is name constrained to
+// hosts, so trusting it cannot let a stolen CA key impersonate other
+// sites; it is replaced when hosts outgrow its constraints.
+func EnsureSelfSigned(dir string, hosts []string) (*SelfSigned, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hostnames for the self-signed certificate")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	caCert, caKey, caGenerated, err := loadOrCreateCA(dir, hosts)
+	if err != nil {
+		return nil, err
+	}
+	ss := &SelfSigned{
+		CertFile:    filepath.Join(dir, ServerFile),
+		KeyFile:     filepath.Join(dir, serverKeyFile),
+		CAFile:      filepath.Join(dir, CAFile),
+		CAGenerated: caGenerated,
+	}
+	sum := sha256.Sum256(caCert.Raw)
+	ss.CAFingerprint = strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if current, err := tls.LoadX509KeyPair(ss.CertFile, ss.KeyFile); err == nil && serverCertValid(leaf(&current), caCert, hosts) {
+		return ss, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(hosts[0], serverValidity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Key first, so a certificate is never paired with an old key
+	if err := writeKey(ss.KeyFile, key); err != nil {
+		return nil, err
+	}
+	chain := append(pemCert(der), pemCert(caCert.Raw)...)
+	if err := writeFile(ss.CertFile, chain, 0644); err != nil {
+		return nil, err
+	}
+	ss.Generated = true
+	return ss, nil
+}
+
+// loadOrCreateCA returns the CA of dir, creating it if there is none or it
+// cannot sign for hosts. It reports whether the CA was created.
+func loadOrCreateCA(dir string, hosts []string) (*x509.Certificate, crypto.Signer, bool, error) {
+	certFile, keyFile := filepath.Join(dir, CAFile), filepath.Join(dir, caKeyFile)
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, nil, false, fmt.Errorf("unsupported CA key in %s", keyFile)
+		}
+		// Unconstrained CAs from earlier versions are replaced too
+		if ca := leaf(&pair); caValid(ca, hosts) {
+			return ca, signer, false, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, false, fmt.Errorf("failed to load the self-signed CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	name := "gohttpserver local CA"
+	if host, err := os.Hostname(); err == nil && host != "" {
+		name += " (" + host + ")"
+	}
+	tmpl, err := template(name, caValidity)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	tmpl.PermittedDNSDomainsCritical = true
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.PermittedIPRanges = append(tmpl.PermittedIPRanges, hostRange(ip))
+		} else {
+			tmpl.PermittedDNSDomains = append(tmpl.PermittedDNSDomains, strings.TrimPrefix(h, "*."))
+		}
+	}
+	// A name type without constraints would be unconstrained, so permit
+	// only names that cannot occur instead
+	if len(tmpl.PermittedDNSDomains) == 0 {
+		tmpl.PermittedDNSDomains = []string{"invalid"}
+	}
+	if len(tmpl.PermittedIPRanges) == 0 {
+		tmpl.PermittedIPRanges = []*net.IPNet{hostRange(net.IPv4zero)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := writeKey(keyFile, key); err != nil {
+		return nil, nil, false, err
+	}
+	if err := writeFile(certFile, pemCert(der), 0644); err != nil {
+		return nil, nil, false, err
+	}
+	return cert, key, true, nil
+}
+
+// caValid reports whether ca can keep signing server certificates for
+// hosts: it outlives a new server certificate and its name constraints
+// cover every host
+func caValid(ca *x509.Certificate, hosts []string) bool {
+	if ca == nil || time.Until(ca.NotAfter) < serverValidity || len(ca.PermittedDNSDomains) == 0 || len(ca.PermittedIPRanges) == 0 {
+		return false
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if !slices.ContainsFunc(ca.PermittedIPRanges, func(r *net.IPNet) bool { return r.Contains(ip) }) {
+				return false
+			}
+			continue
+		}
+		h = strings.ToLower(strings.TrimPrefix(h, "*."))
+		if !slices.ContainsFunc(ca.PermittedDNSDomains, func(d string) bool {
+			d = strings.ToLower(d)
+			return h == d || strings.HasSuffix(h, "."+d)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostRange returns the range holding just ip
+func hostRange(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// serverCertValid reports whether cert can keep being used
+func serverCertValid(cert, ca *x509.Certificate, hosts []string) bool {
+	if cert == nil || cert.CheckSignatureFrom(ca) != nil || time.Until(cert.NotAfter) < renewBefore {
+		return false
+	}
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	want := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			h = ip.String()
+		}
+		want = append(want, h)
+	}
+	slices.Sort(names)
+	slices.Sort(want)
+	return slices.Equal(names, slices.Compact(want))
+}
+
+// leaf returns the parsed first certificate of pair
+func leaf(pair *tls.Certificate) *x509.Certificate {
+	if pair.Leaf == nil && len(pair.Certificate) > 0 {
+		pair.Leaf, _ = x509.ParseCertificate(pair.Certificate[0])
+	}
+	return pair.Leaf
+}
+
+func template(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"gohttpserver"}},
+		NotBefore:    now.Add(-time.Hour), // Tolerate clients with slightly slow clocks
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func pemCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// writeFile replaces path atomically, so a server reloading it never reads
+// half a file
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
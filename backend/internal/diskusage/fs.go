@@ -0,0 +1,14 @@
+package diskusage
+
+import "errors"
+
+// ErrUnsupported is returned by StatFS on platforms without support
+var ErrUnsupported = errors.New("filesystem statistics not supported on this platform")
+
+// FSInfo describes the space on the filesystem holding a path
+type FSInfo struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`      // Free blocks, including those reserved for root
+	Available uint64 `json:"available"` // Free space available to unprivileged users
+	Used      uint64 `json:"used"`
+}
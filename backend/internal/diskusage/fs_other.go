@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package diskusage
+
+// StatFS returns the space on the filesystem holding path
+func StatFS(path string) (*FSInfo, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+
+package diskusage
+
+import "syscall"
+
+// StatFS returns the space on the filesystem holding path
+func StatFS(path string) (*FSInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Bsize)
+	info := &FSInfo{
+		Total:     uint64(st.Blocks) * bsize,
+		Free:      uint64(st.Bfree) * bsize,
+		Available: uint64(st.Bavail) * bsize,
+	}
+	info.Used = info.Total - info.Free
+	return info, nil
+}
@@ -0,0 +1,270 @@
+package diskusage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxDepth is the deepest breakdown Usage will return
+const MaxDepth = 10
+
+const (
+	// cacheTTL bounds how long file sizes can be stale. Writing to a file
+	// does not change its directory's modification time.
+	cacheTTL = 30 * time.Second
+	// maxCacheEntries bounds the memory of the cache on huge trees
+	maxCacheEntries = 100000
+)
+
+// Usage is the disk usage of a file or directory tree
+type Usage struct {
+	Name     string  `json:"name"`
+	Path     string  `json:"path"` // Relative to the root
+	IsDir    bool    `json:"is_dir"`
+	Size     int64   `json:"size"`  // Sum of the file sizes, recursively
+	Files    int64   `json:"files"` // Number of files, recursively
+	Dirs     int64   `json:"dirs"`  // Number of subdirectories, recursively
+	ModTime  string  `json:"mod_time"`
+	Children []Usage `json:"children,omitempty"` // Largest first
+}
+
+// Options configures a Scanner
+type Options struct {
+	// SkipDir, if set, excludes directories and everything below them.
+	// relPath is relative to the root.
+	SkipDir func(relPath string) bool
+	// Allow, if set, excludes files and directories for which it returns false
+	Allow func(relPath string) bool
+}
+
+// Scanner computes recursive directory sizes. The direct contents of every
+// directory are cached and reused for up to cacheTTL while the directory's
+// modification time is unchanged, so repeated requests only stat directories
+// instead of reading them. Growing or overwriting a file in place does not
+// touch its directory, so such changes show up after at most cacheTTL.
+type Scanner struct {
+	root string
+	opts Options
+
+	mu    sync.Mutex
+	cache map[string]*dirSummary
+}
+
+// dirSummary holds the direct contents of a directory
+type dirSummary struct {
+	modTime time.Time
+	read    time.Time // When the directory was read
+	size    int64     // Sum of the direct files
+	files   int64
+	subdirs []string
+}
+
+// totals are the recursive counts of a directory
+type totals struct {
+	size, files, dirs int64
+}
+
+// NewScanner creates a scanner for the tree below rootDir
+func NewScanner(rootDir string, opts Options) *Scanner {
+	return &Scanner{
+		root:  rootDir,
+		opts:  opts,
+		cache: make(map[string]*dirSummary),
+	}
+}
+
+// Usage returns the usage of rel, broken down into its children down to
+// depth levels (0 for the totals only). It stops with ctx.Err() when ctx is
+// cancelled.
+func (s *Scanner) Usage(ctx context.Context, rel string, depth int) (*Usage, error) {
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+	rel = filepath.Clean(rel)
+	info, err := os.Lstat(filepath.Join(s.root, rel))
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.usage(ctx, rel, info, depth)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "." {
+		// Do not reveal where the root lives on the server
+		u.Name, u.Path = "/", ""
+	}
+	return &u, nil
+}
+
+func (s *Scanner) usage(ctx context.Context, rel string, info os.FileInfo, depth int) (Usage, error) {
+	u := Usage{
+		Name:    info.Name(),
+		Path:    filepath.ToSlash(rel),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
+	}
+	if !info.IsDir() {
+		u.Size, u.Files = info.Size(), 1
+		return u, nil
+	}
+
+	t, err := s.totals(ctx, rel)
+	if err != nil {
+		return u, err
+	}
+	u.Size, u.Files, u.Dirs = t.size, t.files, t.dirs
+	if depth == 0 {
+		return u, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.root, rel))
+	if err != nil {
+		return u, nil // Unreadable directories count as empty
+	}
+	u.Children = []Usage{}
+	for _, entry := range entries {
+		childRel := filepath.Join(rel, entry.Name())
+		if !s.include(childRel, entry.IsDir()) || !(entry.IsDir() || entry.Type().IsRegular()) {
+			continue
+		}
+		childInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		child, err := s.usage(ctx, childRel, childInfo, depth-1)
+		if err != nil {
+			return u, err
+		}
+		u.Children = append(u.Children, child)
+	}
+	sort.Slice(u.Children, func(i, j int) bool {
+		if u.Children[i].Size != u.Children[j].Size {
+			return u.Children[i].Size > u.Children[j].Size
+		}
+		return u.Children[i].Name < u.Children[j].Name
+	})
+	return u, nil
+}
+
+// include reports whether an entry is counted
+func (s *Scanner) include(rel string, isDir bool) bool {
+	slashRel := filepath.ToSlash(rel)
+	if isDir && s.opts.SkipDir != nil && s.opts.SkipDir(slashRel) {
+		return false
+	}
+	return s.opts.Allow == nil || s.opts.Allow(slashRel)
+}
+
+// totals returns the recursive counts of the directory rel
+func (s *Scanner) totals(ctx context.Context, rel string) (totals, error) {
+	if err := ctx.Err(); err != nil {
+		return totals{}, err
+	}
+
+	sum := s.summary(rel)
+	if sum == nil {
+		return totals{}, nil
+	}
+	t := totals{size: sum.size, files: sum.files, dirs: int64(len(sum.subdirs))}
+	for _, name := range sum.subdirs {
+		sub, err := s.totals(ctx, filepath.Join(rel, name))
+		if err != nil {
+			return totals{}, err
+		}
+		t.size += sub.size
+		t.files += sub.files
+		t.dirs += sub.dirs
+	}
+	return t, nil
+}
+
+// summary returns the direct contents of rel, reading the directory only if
+// it changed since it was cached. It returns nil if rel cannot be read.
+func (s *Scanner) summary(rel string) *dirSummary {
+	fullPath := filepath.Join(s.root, rel)
+	info, err := os.Lstat(fullPath)
+	if err != nil || !info.IsDir() {
+		s.forget(rel)
+		return nil
+	}
+
+	s.mu.Lock()
+	cached := s.cache[rel]
+	s.mu.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && time.Since(cached.read) < cacheTTL {
+		return cached
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		s.forget(rel)
+		return nil
+	}
+	sum := &dirSummary{modTime: info.ModTime(), read: time.Now()}
+	for _, entry := range entries {
+		childRel := filepath.Join(rel, entry.Name())
+		if !s.include(childRel, entry.IsDir()) {
+			continue
+		}
+		switch {
+		case entry.IsDir():
+			sum.subdirs = append(sum.subdirs, entry.Name())
+		case entry.Type().IsRegular():
+			if childInfo, err := entry.Info(); err == nil {
+				sum.size += childInfo.Size()
+				sum.files++
+			}
+		}
+	}
+
+	s.mu.Lock()
+	if len(s.cache) >= maxCacheEntries {
+		// Evict about a tenth, at random; evicted directories are just read
+		// again when needed
+		n := maxCacheEntries / 10
+		for key := range s.cache {
+			delete(s.cache, key)
+			if n--; n == 0 {
+				break
+			}
+		}
+	}
+	s.cache[rel] = sum
+	s.mu.Unlock()
+	if cached != nil {
+		// Drop the cached subtrees of directories that went away
+		for _, name := range cached.subdirs {
+			if !contains(sum.subdirs, name) {
+				s.forget(filepath.Join(rel, name))
+			}
+		}
+	}
+	return sum
+}
+
+// forget drops rel and everything below it from the cache
+func (s *Scanner) forget(rel string) {
+	prefix := rel + string(filepath.Separator)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, rel)
+	for key := range s.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.cache, key)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package duplicates
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gohttpserver/internal/search"
+)
+
+// Phases reported in Progress
+const (
+	PhaseScanning = "scanning" // Walking the tree and grouping files by size
+	PhasePartial  = "partial"  // Hashing the first block of same-sized files
+	PhaseFull     = "full"     // Hashing whole files whose first blocks match
+	PhaseDone     = "done"
+)
+
+// partialHashSize is how much of each file is read by the partial hash.
+// Files no larger than this are fully hashed in the partial phase.
+const partialHashSize = 64 * 1024
+
+// Group is a set of files with identical content
+type Group struct {
+	Hash   string   `json:"hash"` // SHA-256 of the content
+	Size   int64    `json:"size"` // Size of each file
+	Paths  []string `json:"paths"`
+	Wasted int64    `json:"wasted"` // Bytes that removing all but one copy would free
+}
+
+// Progress describes how far a search for duplicates has got
+type Progress struct {
+	Phase        string `json:"phase"`
+	FilesScanned int    `json:"files_scanned"`
+	FilesToHash  int    `json:"files_to_hash"` // In the current phase
+	FilesHashed  int    `json:"files_hashed"`
+	BytesToHash  int64  `json:"bytes_to_hash"`
+	BytesHashed  int64  `json:"bytes_hashed"`
+}
+
+// Options configures Find
+type Options struct {
+	search.Options       // Scope and directory pruning; Allow filters files
+	MinSize        int64 // Files smaller than this are ignored, default 1
+	// OnProgress, if set, is called whenever the progress changes noticeably
+	OnProgress func(Progress)
+}
+
+// file is a candidate duplicate
+type file struct {
+	path string
+	size int64
+}
+
+// Find returns the groups of identical files below opts.Scope, largest
+// waste first. Files are grouped by size, then by a hash of their first
+// block and finally by a hash of their whole content, so only files that
+// may be duplicates are ever read in full. It stops early with ctx.Err()
+// when ctx is cancelled.
+func Find(ctx context.Context, rootDir string, opts Options) ([]Group, error) {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1
+	}
+	f := &finder{ctx: ctx, root: rootDir, opts: opts, buf: make([]byte, 1<<20)}
+
+	bySize, err := f.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	// Files no larger than the partial block are settled by the partial hash
+	var small, large [][]file
+	for _, files := range bySize {
+		if files[0].size <= partialHashSize {
+			small = append(small, files)
+		} else {
+			large = append(large, files)
+		}
+	}
+
+	groups, err := f.hashGroups(PhasePartial, append(small, large...), partialHashSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Group
+	var full [][]file
+	for _, g := range groups {
+		if g.files[0].size <= partialHashSize {
+			result = append(result, newGroup(g))
+		} else {
+			full = append(full, g.files)
+		}
+	}
+
+	groups, err = f.hashGroups(PhaseFull, full, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		result = append(result, newGroup(g))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Wasted != result[j].Wasted {
+			return result[i].Wasted > result[j].Wasted
+		}
+		return result[i].Paths[0] < result[j].Paths[0]
+	})
+
+	f.progress.Phase = PhaseDone
+	f.report()
+	return result, nil
+}
+
+type finder struct {
+	ctx      context.Context
+	root     string
+	opts     Options
+	buf      []byte
+	progress Progress
+}
+
+type hashGroup struct {
+	hash  string
+	files []file
+}
+
+func newGroup(g hashGroup) Group {
+	paths := make([]string, len(g.files))
+	for i, f := range g.files {
+		paths[i] = f.path
+	}
+	sort.Strings(paths)
+	size := g.files[0].size
+	return Group{
+		Hash:   g.hash,
+		Size:   size,
+		Paths:  paths,
+		Wasted: size * int64(len(paths)-1),
+	}
+}
+
+func (f *finder) report() {
+	if f.opts.OnProgress != nil {
+		f.opts.OnProgress(f.progress)
+	}
+}
+
+// scan walks the tree and returns the files that share their size with at
+// least one other file
+func (f *finder) scan() ([][]file, error) {
+	f.progress = Progress{Phase: PhaseScanning}
+	f.report()
+
+	bySize := make(map[int64][]file)
+	err := search.Walk(f.ctx, f.root, f.opts.Options, func(rel string, d fs.DirEntry) bool {
+		if !d.Type().IsRegular() {
+			return true
+		}
+		if f.opts.Allow != nil && !f.opts.Allow(rel) {
+			return true
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() < f.opts.MinSize {
+			return true
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], file{path: rel, size: info.Size()})
+
+		f.progress.FilesScanned++
+		if f.progress.FilesScanned%500 == 0 {
+			f.report()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]file
+	for _, files := range bySize {
+		if len(files) > 1 {
+			groups = append(groups, files)
+		}
+	}
+	return groups, nil
+}
+
+// hashGroups splits each group of candidate files by content hash, reading
+// at most limit bytes per file (all of it if limit < 0), and returns the
+// resulting groups that still hold more than one file
+func (f *finder) hashGroups(phase string, groups [][]file, limit int64) ([]hashGroup, error) {
+	f.progress.Phase = phase
+	f.progress.FilesToHash, f.progress.FilesHashed = 0, 0
+	f.progress.BytesToHash, f.progress.BytesHashed = 0, 0
+	for _, files := range groups {
+		for _, file := range files {
+			f.progress.FilesToHash++
+			f.progress.BytesToHash += readSize(file.size, limit)
+		}
+	}
+	f.report()
+
+	var result []hashGroup
+	for _, files := range groups {
+		byHash := make(map[string][]file)
+		var order []string
+		for _, file := range files {
+			hash, err := f.hashFile(file, limit)
+			if err != nil {
+				if f.ctx.Err() != nil {
+					return nil, f.ctx.Err()
+				}
+				continue // Unreadable or vanished, it cannot be a duplicate
+			}
+			if _, ok := byHash[hash]; !ok {
+				order = append(order, hash)
+			}
+			byHash[hash] = append(byHash[hash], file)
+			f.progress.FilesHashed++
+			f.report()
+		}
+		for _, hash := range order {
+			if len(byHash[hash]) > 1 {
+				result = append(result, hashGroup{hash: hash, files: byHash[hash]})
+			}
+		}
+	}
+	return result, nil
+}
+
+// hashFile returns the SHA-256 of the first limit bytes of a file
+func (f *finder) hashFile(file file, limit int64) (string, error) {
+	fh, err := os.Open(filepath.Join(f.root, file.path))
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if limit >= 0 {
+		r = io.LimitReader(fh, limit)
+	}
+
+	h := sha256.New()
+	for {
+		if err := f.ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := r.Read(f.buf)
+		if n > 0 {
+			h.Write(f.buf[:n])
+			f.progress.BytesHashed += int64(n)
+			if n == len(f.buf) {
+				f.report()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readSize(size, limit int64) int64 {
+	if limit >= 0 && size > limit {
+		return limit
+	}
+	return size
+}
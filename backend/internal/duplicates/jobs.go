@@ -0,0 +1,247 @@
+package duplicates
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+// JobRetention is how long finished jobs are kept for their results
+const JobRetention = time.Hour
+
+// MaxRunningJobs limits the searches hashing files at the same time
+const MaxRunningJobs = 2
+
+// ErrTooManyJobs is returned by Manager.Start when MaxRunningJobs are running
+var ErrTooManyJobs = errors.New("too many duplicate searches running")
+
+// Job is a duplicate search running in the background
+type Job struct {
+	ID      string
+	Owner   string // Account that started the job, "" without authentication
+	Scope   string
+	MinSize int64
+	Started time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	status   string
+	progress Progress
+	groups   []Group
+	err      error
+	finished time.Time
+}
+
+// JobStatus is a snapshot of a job for reporting
+type JobStatus struct {
+	ID          string   `json:"id"`
+	Path        string   `json:"path"`
+	Status      string   `json:"status"`
+	Progress    Progress `json:"progress"`
+	Started     string   `json:"started"`
+	Finished    string   `json:"finished,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	GroupCount  int      `json:"group_count"`
+	WastedBytes int64    `json:"wasted_bytes"`
+	Groups      []Group  `json:"groups,omitempty"` // Only once the job is done
+}
+
+// Status returns a snapshot of the job
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	st := JobStatus{
+		ID:       j.ID,
+		Path:     j.Scope,
+		Status:   j.status,
+		Progress: j.progress,
+		Started:  j.Started.Format(time.RFC3339),
+		Groups:   j.groups,
+	}
+	if !j.finished.IsZero() {
+		st.Finished = j.finished.Format(time.RFC3339)
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	st.GroupCount = len(j.groups)
+	for _, g := range j.groups {
+		st.WastedBytes += g.Wasted
+	}
+	return st
+}
+
+// Filter drops the paths allow rejects from the groups, and the groups left
+// with fewer than two paths, and recomputes the totals
+func (st JobStatus) Filter(allow func(path string) bool) JobStatus {
+	if st.Groups == nil {
+		return st
+	}
+	groups := make([]Group, 0, len(st.Groups))
+	st.WastedBytes = 0
+	for _, g := range st.Groups {
+		var paths []string
+		for _, p := range g.Paths {
+			if allow(p) {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) < 2 {
+			continue
+		}
+		g.Paths, g.Wasted = paths, g.Size*int64(len(paths)-1)
+		groups = append(groups, g)
+		st.WastedBytes += g.Wasted
+	}
+	st.Groups, st.GroupCount = groups, len(groups)
+	return st
+}
+
+// Cancel stops the job if it is still running
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) run(ctx context.Context, rootDir string, opts Options) {
+	opts.OnProgress = func(p Progress) {
+		j.mu.Lock()
+		j.progress = p
+		j.mu.Unlock()
+	}
+	groups, err := Find(ctx, rootDir, opts)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = time.Now()
+	switch {
+	case err == context.Canceled:
+		j.status = StatusCancelled
+	case err != nil:
+		j.status, j.err = StatusFailed, err
+	default:
+		j.status, j.groups = StatusDone, groups
+		if groups == nil {
+			j.groups = []Group{}
+		}
+	}
+}
+
+func (j *Job) expired(now time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finished.IsZero() && now.Sub(j.finished) > JobRetention
+}
+
+func (j *Job) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status == StatusRunning
+}
+
+// Manager runs duplicate searches and keeps their results for JobRetention
+type Manager struct {
+	rootDir string
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewManager creates a job manager for the tree below rootDir
+func NewManager(rootDir string) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		rootDir: rootDir,
+		ctx:     ctx,
+		cancel:  cancel,
+		jobs:    make(map[string]*Job),
+	}
+}
+
+// Start begins a search for duplicates below opts.Scope on behalf of owner.
+// If owner is already running one for the same scope and minimum size, that
+// job is returned instead. It fails with ErrTooManyJobs if MaxRunningJobs
+// other searches are running.
+func (m *Manager) Start(owner string, opts Options) (*Job, error) {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+
+	running := 0
+	for _, j := range m.jobs {
+		if !j.running() {
+			continue
+		}
+		if j.Owner == owner && j.Scope == opts.Scope && j.MinSize == opts.MinSize {
+			return j, nil
+		}
+		running++
+	}
+	if running >= MaxRunningJobs {
+		return nil, ErrTooManyJobs
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &Job{
+		ID:       newJobID(),
+		Owner:    owner,
+		Scope:    opts.Scope,
+		MinSize:  opts.MinSize,
+		Started:  time.Now(),
+		cancel:   cancel,
+		status:   StatusRunning,
+		progress: Progress{Phase: PhaseScanning},
+	}
+	m.jobs[j.ID] = j
+	go func() {
+		defer cancel()
+		j.run(ctx, m.rootDir, opts)
+	}()
+	return j, nil
+}
+
+// Get returns the job with the given ID, or nil if there is none
+func (m *Manager) Get(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	return m.jobs[id]
+}
+
+// Close cancels all running jobs
+func (m *Manager) Close() {
+	m.cancel()
+}
+
+// prune drops finished jobs past their retention. m.mu must be held.
+func (m *Manager) prune() {
+	now := time.Now()
+	for id, j := range m.jobs {
+		if j.expired(now) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,198 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes and the constructed bit of an identifier octet
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// maxPacketSize bounds the messages we accept from a server
+const maxPacketSize = 4 << 20
+
+var errMalformed = errors.New("malformed LDAP message")
+
+// packet is a BER element. Constructed elements have children, primitive
+// ones a value.
+type packet struct {
+	tag      byte // Identifier octet: class, constructed bit and tag number
+	value    []byte
+	children []*packet
+}
+
+func newSequence(tag byte, children ...*packet) *packet {
+	return &packet{tag: tag | constructed, children: children}
+}
+
+func newString(tag byte, s string) *packet {
+	return &packet{tag: tag, value: []byte(s)}
+}
+
+func newInt(tag byte, n int) *packet {
+	// Minimal two's complement encoding
+	var b []byte
+	for v := int64(n); ; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+		if (v < 0x80 && v >= -0x80) || len(b) == 8 {
+			break
+		}
+	}
+	return &packet{tag: tag, value: b}
+}
+
+func newBool(b bool) *packet {
+	if b {
+		return &packet{tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &packet{tag: tagBoolean, value: []byte{0x00}}
+}
+
+func (p *packet) isConstructed() bool {
+	return p.tag&constructed != 0
+}
+
+// encode returns the DER-style encoding of p
+func (p *packet) encode() []byte {
+	content := p.value
+	if p.isConstructed() {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.encode()...)
+		}
+	}
+	out := []byte{p.tag}
+	out = append(out, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// readPacket reads one element from r
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, fmt.Errorf("%w: multi-byte tags are not supported", errMalformed)
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("%w: unsupported length encoding", errMalformed)
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("%w: message of %d bytes is too large", errMalformed, length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decodePacket(tag, content)
+}
+
+// parsePackets decodes the elements of a constructed element's content
+func parsePackets(data []byte) ([]*packet, error) {
+	var list []*packet
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errMalformed
+		}
+		tag, length, hdr := data[0], int(data[1]), 2
+		if tag&0x1f == 0x1f {
+			return nil, errMalformed
+		}
+		if data[1]&0x80 != 0 {
+			n := int(data[1] & 0x7f)
+			if n == 0 || n > 4 || len(data) < 2+n {
+				return nil, errMalformed
+			}
+			length = 0
+			for _, b := range data[2 : 2+n] {
+				length = length<<8 | int(b)
+			}
+			hdr += n
+		}
+		if length < 0 || len(data)-hdr < length {
+			return nil, errMalformed
+		}
+		p, err := decodePacket(tag, data[hdr:hdr+length])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+		data = data[hdr+length:]
+	}
+	return list, nil
+}
+
+func decodePacket(tag byte, content []byte) (*packet, error) {
+	p := &packet{tag: tag}
+	if p.isConstructed() {
+		children, err := parsePackets(content)
+		if err != nil {
+			return nil, err
+		}
+		p.children = children
+	} else {
+		p.value = content
+	}
+	return p, nil
+}
+
+// int decodes an INTEGER or ENUMERATED value
+func (p *packet) int() (int, error) {
+	if p.isConstructed() || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errMalformed
+	}
+	v := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		v = v<<8 | int64(b)
+	}
+	return int(v), nil
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
@@ -0,0 +1,319 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operations (RFC 4511 section 4.2 onwards)
+const (
+	opBindRequest       = classApplication | constructed | 0
+	opBindResponse      = classApplication | constructed | 1
+	opUnbindRequest     = classApplication | 2
+	opSearchRequest     = classApplication | constructed | 3
+	opSearchEntry       = classApplication | constructed | 4
+	opSearchDone        = classApplication | constructed | 5
+	opSearchReference   = classApplication | constructed | 19
+	opExtendedRequest   = classApplication | constructed | 23
+	opExtendedResponse  = classApplication | constructed | 24
+	authSimple          = classContext | 0
+	extendedRequestName = classContext | 0
+)
+
+// startTLSOID is the name of the StartTLS extended operation
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Result codes we act on
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// Error is an LDAP result other than success
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// IsResult reports whether err is an LDAP result with code
+func IsResult(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // Keyed by the attribute names the server returned
+}
+
+// Get returns the values of an attribute, compared case-insensitively as
+// LDAP attribute names are
+func (e *Entry) Get(attr string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Conn is a connection to an LDAP server. Operations are synchronous, one at
+// a time.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	host   string
+	lastID int
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. tlsConfig is used for
+// ldaps:// and StartTLS, its ServerName defaults to the URL's host.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %q: %w", rawURL, err)
+	}
+	host, port := u.Hostname(), u.Port()
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), withServerName(tlsConfig, host))
+	default:
+		return nil, fmt.Errorf("invalid LDAP URL %q: scheme must be ldap or ldaps", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), host: host}, nil
+}
+
+// StartTLS upgrades the connection to TLS
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	req := newSequence(opExtendedRequest, newString(extendedRequestName, startTLSOID))
+	resp, err := c.request(req, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(resp); err != nil {
+		return fmt.Errorf("StartTLS: %w", err)
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("StartTLS: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates with a DN and password (simple bind). An empty
+// password would be an unauthenticated bind, which servers accept for any
+// DN, so it is refused unless the DN is empty too (anonymous bind).
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" && dn != "" {
+		return &Error{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+	req := newSequence(opBindRequest,
+		newInt(tagInteger, 3),
+		newString(tagOctetString, dn),
+		newString(authSimple, password),
+	)
+	resp, err := c.request(req, opBindResponse)
+	if err != nil {
+		return err
+	}
+	return resultError(resp)
+}
+
+// Search returns the entries below base matching filter, with the requested
+// attributes. sizeLimit 0 means no limit.
+func (c *Conn) Search(base string, scope int, filter string, attrs []string, sizeLimit int) ([]*Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrList := newSequence(tagSequence)
+	for _, a := range attrs {
+		attrList.children = append(attrList.children, newString(tagOctetString, a))
+	}
+	req := newSequence(opSearchRequest,
+		newString(tagOctetString, base),
+		newInt(tagEnumerated, scope),
+		newInt(tagEnumerated, 0), // Never dereference aliases
+		newInt(tagInteger, sizeLimit),
+		newInt(tagInteger, 0), // No time limit beyond our deadline
+		newBool(false),
+		f,
+		attrList,
+	)
+	id, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// Referrals to other servers are not followed
+		case opSearchDone:
+			if err := resultError(op); err != nil {
+				return entries, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("%w: unexpected response 0x%02x", errMalformed, op.tag)
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.send(&packet{tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+// request sends an operation and reads its single response
+func (c *Conn) request(op *packet, respTag byte) (*packet, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if resp.tag != respTag {
+		return nil, fmt.Errorf("%w: unexpected response 0x%02x", errMalformed, resp.tag)
+	}
+	return resp, nil
+}
+
+func (c *Conn) send(op *packet) (int, error) {
+	c.lastID++
+	msg := newSequence(tagSequence, newInt(tagInteger, c.lastID), op)
+	if _, err := c.conn.Write(msg.encode()); err != nil {
+		return 0, err
+	}
+	return c.lastID, nil
+}
+
+// receive reads the next response to message id
+func (c *Conn) receive(id int) (*packet, error) {
+	for {
+		msg, err := readPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			return nil, errMalformed
+		}
+		msgID, err := msg.children[0].int()
+		if err != nil {
+			return nil, err
+		}
+		op := msg.children[1]
+		if msgID == 0 && op.tag == opExtendedResponse {
+			// Notice of disconnection
+			if err := resultError(op); err != nil {
+				return nil, fmt.Errorf("server closed the connection: %w", err)
+			}
+			return nil, errors.New("server closed the connection")
+		}
+		if msgID == id {
+			return op, nil
+		}
+	}
+}
+
+// resultError returns the LDAPResult at the start of a response as an
+// error, or nil for success
+func resultError(op *packet) error {
+	if len(op.children) < 3 {
+		return errMalformed
+	}
+	code, err := op.children[0].int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{Code: code, Message: op.children[2].str()}
+}
+
+func parseEntry(op *packet) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, errMalformed
+	}
+	entry := &Entry{DN: op.children[0].str(), Attributes: make(map[string][]string)}
+	for _, attr := range op.children[1].children {
+		if len(attr.children) < 2 {
+			return nil, errMalformed
+		}
+		name := attr.children[0].str()
+		for _, v := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], v.str())
+		}
+	}
+	return entry, nil
+}
+
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
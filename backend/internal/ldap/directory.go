@@ -0,0 +1,318 @@
+package ldap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for Config
+const (
+	DefaultUserFilter  = "(uid={username})"
+	DefaultGroupFilter = "(|(member={dn})(uniqueMember={dn})(memberUid={username}))"
+	DefaultGroupAttr   = "cn"
+	DefaultCacheTTL    = 5 * time.Minute
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Errors returned by Directory
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAmbiguousUser      = errors.New("the user filter matched more than one entry")
+)
+
+// Config configures a Directory
+type Config struct {
+	URL                string // ldap://host[:port] or ldaps://host[:port]
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	CAFile             string // PEM CA certificates to verify the server with, default the system pool
+	InsecureSkipVerify bool
+
+	// BindDN and BindPassword are the service account that looks users
+	// up. Empty binds anonymously.
+	BindDN       string
+	BindPassword string
+	BaseDN       string // Where users (and by default groups) are searched
+	UserFilter   string // Finds a user's entry; {username} is replaced, default DefaultUserFilter
+	// UserDN binds directly as a DN such as "uid={username},ou=people,dc=example,dc=com"
+	// instead of searching for the user
+	UserDN string
+
+	GroupBaseDN string // Where groups are searched, default BaseDN
+	// GroupFilter finds the groups of a user; {dn} and {username} are
+	// replaced. Empty only uses the user's memberOf attribute.
+	GroupFilter string
+	GroupAttr   string // Attribute of group entries holding their name, default DefaultGroupAttr
+
+	CacheTTL time.Duration // How long successful logins are remembered, 0 to always ask the server
+	Timeout  time.Duration // Limit for each login's LDAP exchange, default DefaultTimeout
+}
+
+// User is a user authenticated by the directory
+type User struct {
+	Name   string
+	DN     string
+	Groups []string
+}
+
+// cacheEntry remembers a successful login. Only a keyed hash of the
+// password is kept.
+type cacheEntry struct {
+	password []byte
+	user     *User
+	expires  time.Time
+}
+
+// Directory authenticates users with an LDAP simple bind and looks up their
+// groups. Each login that is not cached uses a new connection.
+type Directory struct {
+	cfg       Config
+	tlsConfig *tls.Config
+	cacheKey  []byte
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+// NewDirectory validates cfg and creates a directory
+func NewDirectory(cfg Config) (*Directory, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL %q (expected ldap://host or ldaps://host)", cfg.URL)
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS cannot be used with an ldaps:// URL")
+	}
+	if cfg.UserDN == "" && cfg.BaseDN == "" {
+		return nil, errors.New("LDAP requires a base DN to search users in, or a user DN template")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = DefaultUserFilter
+	}
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = DefaultGroupAttr
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	// Catch filter typos at startup rather than at the first login
+	for _, f := range []string{cfg.UserFilter, cfg.GroupFilter} {
+		if f == "" {
+			continue
+		}
+		if _, err := compileFilter(expand(f, "x", "x")); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &Directory{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		cacheKey:  key,
+		cache:     make(map[string]*cacheEntry),
+	}, nil
+}
+
+// Authenticate checks a username and password with a bind as the user and
+// returns the user with their groups. Failed logins are not cached, so a
+// wrong password always reaches the server.
+func (d *Directory) Authenticate(username, password string) (*User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	hash := d.hashPassword(username, password)
+	now := time.Now()
+
+	d.mu.Lock()
+	entry := d.cache[username]
+	d.mu.Unlock()
+	if entry != nil && now.Before(entry.expires) && hmac.Equal(entry.password, hash) {
+		return entry.user, nil
+	}
+
+	user, err := d.lookup(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.cfg.CacheTTL > 0 {
+		d.mu.Lock()
+		for name, e := range d.cache {
+			if now.After(e.expires) {
+				delete(d.cache, name)
+			}
+		}
+		d.cache[username] = &cacheEntry{password: hash, user: user, expires: now.Add(d.cfg.CacheTTL)}
+		d.mu.Unlock()
+	}
+	return user, nil
+}
+
+// lookup runs a login against the server
+func (d *Directory) lookup(username, password string) (*User, error) {
+	conn, err := Dial(d.cfg.URL, d.tlsConfig, d.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d.cfg.StartTLS {
+		if err := conn.StartTLS(d.tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	user := &User{Name: username}
+	var memberOf []string
+	if d.cfg.UserDN != "" {
+		user.DN = strings.ReplaceAll(d.cfg.UserDN, "{username}", EscapeDN(username))
+		if err := d.bindUser(conn, user.DN, password); err != nil {
+			return nil, err
+		}
+		// Read the user's own entry for memberOf; directories that do not
+		// let users read it just have no memberOf groups
+		entries, err := conn.Search(user.DN, ScopeBaseObject, "(objectClass=*)", []string{"memberOf"}, 1)
+		if err == nil && len(entries) == 1 {
+			memberOf = entries[0].Get("memberOf")
+		}
+		if d.cfg.BindDN != "" {
+			if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+				return nil, fmt.Errorf("service account bind: %w", err)
+			}
+		}
+	} else {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+		filter := expand(d.cfg.UserFilter, EscapeFilter(username), "")
+		entries, err := conn.Search(d.cfg.BaseDN, ScopeWholeSubtree, filter, []string{"memberOf"}, 2)
+		if err != nil && !IsResult(err, ResultSizeLimitExceeded) { // Reported as ambiguous below
+			return nil, fmt.Errorf("user search: %w", err)
+		}
+		switch len(entries) {
+		case 0:
+			return nil, ErrInvalidCredentials
+		case 1:
+		default:
+			return nil, ErrAmbiguousUser
+		}
+		user.DN = entries[0].DN
+		memberOf = entries[0].Get("memberOf")
+		if err := d.bindUser(conn, user.DN, password); err != nil {
+			return nil, err
+		}
+		// Search groups as the service account again
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	addGroup := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			user.Groups = append(user.Groups, name)
+		}
+	}
+	for _, dn := range memberOf {
+		addGroup(rdnValue(dn))
+	}
+	if d.cfg.GroupFilter != "" && d.cfg.GroupBaseDN != "" {
+		filter := expand(d.cfg.GroupFilter, EscapeFilter(username), EscapeFilter(user.DN))
+		groups, err := conn.Search(d.cfg.GroupBaseDN, ScopeWholeSubtree, filter, []string{d.cfg.GroupAttr}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("group search: %w", err)
+		}
+		for _, g := range groups {
+			names := g.Get(d.cfg.GroupAttr)
+			if len(names) == 0 {
+				names = []string{rdnValue(g.DN)}
+			}
+			for _, name := range names {
+				addGroup(name)
+			}
+		}
+	}
+	return user, nil
+}
+
+// bindUser binds as the user, mapping a rejected bind to
+// ErrInvalidCredentials
+func (d *Directory) bindUser(conn *Conn, dn, password string) error {
+	err := conn.Bind(dn, password)
+	if IsResult(err, ResultInvalidCredentials) || IsResult(err, ResultNoSuchObject) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+func (d *Directory) hashPassword(username, password string) []byte {
+	mac := hmac.New(sha256.New, d.cacheKey)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// expand replaces the {username} and {dn} placeholders of a filter with
+// escaped values
+func expand(filter, username, dn string) string {
+	return strings.NewReplacer("{username}", username, "{dn}", dn).Replace(filter)
+}
+
+// rdnValue returns the value of the first RDN of dn, e.g. "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com"
+func rdnValue(dn string) string {
+	var b strings.Builder
+	inValue := false
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case !inValue:
+			inValue = c == '='
+		case c == '\\' && i+2 < len(dn) && isHex(dn[i+1]) && isHex(dn[i+2]):
+			v, _ := hex.DecodeString(dn[i+1 : i+3])
+			b.Write(v)
+			i += 2
+		case c == '\\' && i+1 < len(dn):
+			i++
+			b.WriteByte(dn[i])
+		case c == ',' || c == '+':
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,621 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBaseDN      = "dc=example,dc=com"
+	testServiceDN   = "cn=files,ou=services,dc=example,dc=com"
+	testServicePass = "service-secret"
+)
+
+// Result codes only the test server sends
+const (
+	resultProtocolError           = 2
+	resultConfidentialityRequired = 13
+)
+
+// testEntry is an entry of the test directory
+type testEntry struct {
+	dn       string
+	password string // Empty if the entry cannot bind
+	attrs    map[string][]string
+}
+
+// testServer is a small LDAP server on 127.0.0.1 answering simple binds,
+// searches and StartTLS from an in-memory directory
+type testServer struct {
+	t         *testing.T
+	ln        net.Listener
+	tlsConfig *tls.Config
+	caFile    string // PEM certificate of the server, for Config.CAFile
+
+	mu         sync.Mutex
+	entries    []*testEntry
+	requireTLS bool // Refuse binds and searches before StartTLS
+	dials      int
+}
+
+func newTestServer(t *testing.T, entries ...*testEntry) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{t: t, ln: ln, entries: entries}
+	s.tlsConfig, s.caFile = testCertificate(t)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.dials++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+// connections returns how many connections the server accepted
+func (s *testServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *testServer) setRequireTLS(require bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireTLS = require
+}
+
+func (s *testServer) setPassword(dn, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.dn == dn {
+			e.password = password
+		}
+	}
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	secure := false
+	s.mu.Lock()
+	requireTLS := s.requireTLS
+	s.mu.Unlock()
+	for {
+		msg, err := readPacket(reader)
+		if err != nil || msg.tag != tagSequence || len(msg.children) < 2 {
+			return
+		}
+		id, _ := msg.children[0].int()
+		op := msg.children[1]
+		reply := func(op *packet) {
+			conn.Write(newSequence(tagSequence, newInt(tagInteger, id), op).encode())
+		}
+
+		switch op.tag {
+		case opUnbindRequest:
+			return
+		case opExtendedRequest:
+			if len(op.children) == 0 || op.children[0].str() != startTLSOID || secure {
+				reply(testResult(opExtendedResponse, resultProtocolError))
+				continue
+			}
+			reply(testResult(opExtendedResponse, ResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case opBindRequest:
+			if len(op.children) < 3 {
+				reply(testResult(opBindResponse, resultProtocolError))
+			} else if requireTLS && !secure {
+				reply(testResult(opBindResponse, resultConfidentialityRequired))
+			} else {
+				reply(testResult(opBindResponse, s.bind(op.children[1].str(), op.children[2].str())))
+			}
+		case opSearchRequest:
+			if requireTLS && !secure {
+				reply(testResult(opSearchDone, resultConfidentialityRequired))
+				continue
+			}
+			if len(op.children) < 8 {
+				reply(testResult(opSearchDone, resultProtocolError))
+				continue
+			}
+			base := op.children[0].str()
+			scope, _ := op.children[1].int()
+			sizeLimit, _ := op.children[3].int()
+			var attrs []string
+			for _, a := range op.children[7].children {
+				attrs = append(attrs, a.str())
+			}
+			code := ResultSuccess
+			sent := 0
+			for _, e := range s.search(base, scope, op.children[6]) {
+				if sizeLimit > 0 && sent == sizeLimit {
+					code = ResultSizeLimitExceeded
+					break
+				}
+				reply(e.packet(attrs))
+				sent++
+			}
+			reply(testResult(opSearchDone, code))
+		default:
+			return
+		}
+	}
+}
+
+func testResult(tag byte, code int) *packet {
+	return newSequence(tag,
+		newInt(tagEnumerated, code),
+		newString(tagOctetString, ""),
+		newString(tagOctetString, ""),
+	)
+}
+
+func (s *testServer) bind(dn, password string) int {
+	if dn == "" && password == "" {
+		return ResultSuccess
+	}
+	if dn == testServiceDN && password == testServicePass {
+		return ResultSuccess
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) {
+			if e.password != "" && e.password == password {
+				return ResultSuccess
+			}
+			return ResultInvalidCredentials
+		}
+	}
+	return ResultInvalidCredentials
+}
+
+func (s *testServer) search(base string, scope int, filter *packet) []*testEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []*testEntry
+	for _, e := range s.entries {
+		inScope := strings.EqualFold(e.dn, base)
+		if scope == ScopeWholeSubtree {
+			inScope = inScope || strings.HasSuffix(strings.ToLower(e.dn), ","+strings.ToLower(base))
+		}
+		if inScope && e.matches(filter) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (e *testEntry) get(attr string) []string {
+	if strings.EqualFold(attr, "objectClass") {
+		return []string{"top"}
+	}
+	for name, values := range e.attrs {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+// matches evaluates a filter, comparing values case-insensitively
+func (e *testEntry) matches(f *packet) bool {
+	switch f.tag {
+	case filterAnd:
+		for _, c := range f.children {
+			if !e.matches(c) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if e.matches(c) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(f.children) == 1 && !e.matches(f.children[0])
+	case filterPresent:
+		return len(e.get(f.str())) > 0
+	case filterEquality:
+		return slices.ContainsFunc(e.get(f.children[0].str()), func(v string) bool {
+			return strings.EqualFold(v, f.children[1].str())
+		})
+	case filterSubstring:
+		return slices.ContainsFunc(e.get(f.children[0].str()), func(v string) bool {
+			v = strings.ToLower(v)
+			for _, part := range f.children[1].children {
+				sub := strings.ToLower(part.str())
+				switch part.tag {
+				case substringInitial:
+					if !strings.HasPrefix(v, sub) {
+						return false
+					}
+					v = v[len(sub):]
+				case substringAny:
+					i := strings.Index(v, sub)
+					if i < 0 {
+						return false
+					}
+					v = v[i+len(sub):]
+				case substringFinal:
+					if !strings.HasSuffix(v, sub) {
+						return false
+					}
+				}
+			}
+			return true
+		})
+	}
+	return false
+}
+
+func (e *testEntry) packet(attrs []string) *packet {
+	list := newSequence(tagSequence)
+	for _, name := range attrs {
+		values := e.get(name)
+		if len(values) == 0 {
+			continue
+		}
+		set := newSequence(tagSet)
+		for _, v := range values {
+			set.children = append(set.children, newString(tagOctetString, v))
+		}
+		list.children = append(list.children, newSequence(tagSequence, newString(tagOctetString, name), set))
+	}
+	return newSequence(opSearchEntry, newString(tagOctetString, e.dn), list)
+}
+
+// testCertificate creates a self-signed certificate for 127.0.0.1 and
+// writes it to a file the client can trust
+func testCertificate(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap test server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, caFile
+}
+
+// testDirectory returns the usual people and groups
+func testDirectory() []*testEntry {
+	return []*testEntry{
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alice-secret",
+			attrs: map[string][]string{
+				"uid":      {"alice"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn:       "uid=bob,ou=people,dc=example,dc=com",
+			password: "bob-secret",
+			attrs:    map[string][]string{"uid": {"bob"}},
+		},
+		{
+			// Shares a uid with the entry above in another branch
+			dn:       "uid=bob,ou=contractors,dc=example,dc=com",
+			password: "other-bob-secret",
+			attrs:    map[string][]string{"uid": {"bob"}},
+		},
+		{
+			dn:       `uid=we*ird(\),ou=people,dc=example,dc=com`,
+			password: "weird-secret",
+			attrs:    map[string][]string{"uid": {`we*ird(\)`}},
+		},
+		{
+			dn: "cn=admins,ou=groups,dc=example,dc=com",
+			attrs: map[string][]string{
+				"cn":     {"admins"},
+				"member": {"uid=alice,ou=people,dc=example,dc=com"},
+			},
+		},
+		{
+			dn: "cn=developers,ou=groups,dc=example,dc=com",
+			attrs: map[string][]string{
+				"cn":        {"developers"},
+				"memberUid": {"alice"},
+			},
+		},
+	}
+}
+
+func newTestDirectory(t *testing.T, cfg Config) *Directory {
+	t.Helper()
+	d, err := NewDirectory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestAuthenticateGroups(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	for _, tc := range []struct {
+		name        string
+		groupFilter string
+		want        []string
+	}{
+		{"memberOf only", "", []string{"staff"}},
+		{"memberOf and group filter", DefaultGroupFilter, []string{"staff", "admins", "developers"}},
+		{"member attribute", "(member={dn})", []string{"staff", "admins"}},
+		{"memberUid attribute", "(memberUid={username})", []string{"staff", "developers"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDirectory(t, Config{
+				URL:          s.url(),
+				BindDN:       testServiceDN,
+				BindPassword: testServicePass,
+				BaseDN:       testBaseDN,
+				GroupFilter:  tc.groupFilter,
+			})
+			user, err := d.Authenticate("alice", "alice-secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.DN != "uid=alice,ou=people,dc=example,dc=com" {
+				t.Errorf("DN = %q", user.DN)
+			}
+			if !slices.Equal(user.Groups, tc.want) {
+				t.Errorf("groups = %v, want %v", user.Groups, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUserDN(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	d := newTestDirectory(t, Config{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+		UserDN:       "uid={username},ou=people,dc=example,dc=com",
+		GroupFilter:  "(member={dn})",
+	})
+	user, err := d.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"staff", "admins"}; !slices.Equal(user.Groups, want) {
+		t.Errorf("groups = %v, want %v", user.Groups, want)
+	}
+	if _, err := d.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	// The username cannot add RDNs to the DN
+	if _, err := d.Authenticate("alice,ou=people", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("DN injection: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	d := newTestDirectory(t, Config{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+	})
+	for _, tc := range []struct {
+		name, username, password string
+		want                     error
+	}{
+		{"bad password", "alice", "wrong", ErrInvalidCredentials},
+		{"password of another user", "alice", "bob-secret", ErrInvalidCredentials},
+		{"unknown user", "carol", "carol-secret", ErrInvalidCredentials},
+		{"empty password", "alice", "", ErrInvalidCredentials},
+		{"ambiguous user", "bob", "bob-secret", ErrAmbiguousUser},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := d.Authenticate(tc.username, tc.password); !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// An empty password never reaches the server, where it would be an
+	// unauthenticated bind that succeeds
+	before := s.connections()
+	d.Authenticate("alice", "")
+	if n := s.connections() - before; n != 0 {
+		t.Errorf("empty password opened %d connections", n)
+	}
+}
+
+func TestAuthenticateEscapesFilter(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	d := newTestDirectory(t, Config{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+	})
+	// Unescaped, these would match every user or break out of the filter
+	for _, username := range []string{"*", "al*", "*)(uid=*", `alice)(|(uid=*`, `\2a`, "alice\x00"} {
+		if _, err := d.Authenticate(username, "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("username %q: err = %v, want %v", username, err, ErrInvalidCredentials)
+		}
+	}
+	// Special characters in a real uid are matched literally
+	user, err := d.Authenticate(`we*ird(\)`, "weird-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != `uid=we*ird(\),ou=people,dc=example,dc=com` {
+		t.Errorf("DN = %q", user.DN)
+	}
+
+	for _, tc := range []struct{ in, want string }{
+		{"alice", "alice"},
+		{`*()\`, `\2a\28\29\5c`},
+		{"a\x00b", `a\00b`},
+	} {
+		if got := EscapeFilter(tc.in); got != tc.want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	s.setRequireTLS(true)
+	cfg := Config{
+		URL:          s.url(),
+		StartTLS:     true,
+		CAFile:       s.caFile,
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+		GroupFilter:  DefaultGroupFilter,
+	}
+	user, err := newTestDirectory(t, cfg).Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"staff", "admins", "developers"}; !slices.Equal(user.Groups, want) {
+		t.Errorf("groups = %v, want %v", user.Groups, want)
+	}
+
+	// Without StartTLS the server refuses to bind
+	cfg.StartTLS = false
+	if _, err := newTestDirectory(t, cfg).Authenticate("alice", "alice-secret"); !IsResult(err, resultConfidentialityRequired) {
+		t.Errorf("plain connection: err = %v, want result %d", err, resultConfidentialityRequired)
+	}
+
+	// A server certificate that is not trusted fails the upgrade
+	cfg.StartTLS, cfg.CAFile = true, ""
+	if _, err := newTestDirectory(t, cfg).Authenticate("alice", "alice-secret"); err == nil || !strings.Contains(err.Error(), "StartTLS") {
+		t.Errorf("untrusted certificate: err = %v, want a StartTLS error", err)
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	s := newTestServer(t, testDirectory()...)
+	d := newTestDirectory(t, Config{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+		CacheTTL:     time.Hour,
+	})
+	const dn = "uid=alice,ou=people,dc=example,dc=com"
+
+	if _, err := d.Authenticate("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.connections(); n != 1 {
+		t.Fatalf("%d connections after the first login, want 1", n)
+	}
+
+	// A repeated login is answered from the cache
+	user, err := d.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != dn {
+		t.Errorf("cached DN = %q", user.DN)
+	}
+	if n := s.connections(); n != 1 {
+		t.Fatalf("%d connections after a cached login, want 1", n)
+	}
+
+	// A different password is not, and failures are never cached
+	for i := 0; i < 2; i++ {
+		if _, err := d.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	if n := s.connections(); n != 3 {
+		t.Fatalf("%d connections after failed logins, want 3", n)
+	}
+
+	// Once the entry expires the server is asked again and sees the new
+	// password
+	s.setPassword(dn, "new-secret")
+	d.mu.Lock()
+	d.cache["alice"].expires = time.Now().Add(-time.Second)
+	d.mu.Unlock()
+	if _, err := d.Authenticate("alice", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expired entry: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := d.Authenticate("alice", "new-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.connections(); n != 5 {
+		t.Fatalf("%d connections after the cache expired, want 5", n)
+	}
+
+	// Without a TTL every login asks the server
+	d = newTestDirectory(t, Config{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+	})
+	before := s.connections()
+	for i := 0; i < 2; i++ {
+		if _, err := d.Authenticate("alice", "new-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.connections() - before; n != 2 {
+		t.Errorf("%d connections without a cache, want 2", n)
+	}
+}
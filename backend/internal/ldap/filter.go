@@ -0,0 +1,209 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choices (RFC 4511 section 4.5.1)
+const (
+	filterAnd       = classContext | constructed | 0
+	filterOr        = classContext | constructed | 1
+	filterNot       = classContext | constructed | 2
+	filterEquality  = classContext | constructed | 3
+	filterSubstring = classContext | constructed | 4
+	filterGreater   = classContext | constructed | 5
+	filterLess      = classContext | constructed | 6
+	filterPresent   = classContext | 7
+	filterApprox    = classContext | constructed | 8
+)
+
+// Substring filter parts
+const (
+	substringInitial = classContext | 0
+	substringAny     = classContext | 1
+	substringFinal   = classContext | 2
+)
+
+// EscapeFilter escapes a value for use in a search filter (RFC 4515)
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeDN escapes a value for use as an attribute value in a DN (RFC 4514)
+func EscapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter parses a string filter such as "(&(uid=bob)(objectClass=*))"
+func compileFilter(filter string) (*packet, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	p, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", filter, rest)
+	}
+	return p, nil
+}
+
+// parseFilter parses the parenthesized filter at the start of s and returns
+// the rest of s
+func parseFilter(s string) (*packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		p := &packet{tag: tag}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			p.children = append(p.children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", s)
+		}
+		return p, s[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", rest)
+		}
+		return &packet{tag: filterNot, children: []*packet{child}}, rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+	p, err := parseItem(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return p, s[end+1:], nil
+}
+
+// parseItem parses a simple filter item such as "uid=bob" or "cn=a*b"
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("expected attribute=value in %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreater, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLess, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("missing attribute in %q", item)
+	}
+
+	if tag == filterEquality && value == "*" {
+		return newString(filterPresent, attr), nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := newSequence(tagSequence)
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeFilter(part)
+			if err != nil {
+				return nil, err
+			}
+			partTag := byte(substringAny)
+			switch i {
+			case 0:
+				partTag = substringInitial
+			case len(parts) - 1:
+				partTag = substringFinal
+			}
+			subs.children = append(subs.children, newString(partTag, v))
+		}
+		return &packet{tag: filterSubstring, children: []*packet{newString(tagOctetString, attr), subs}}, nil
+	}
+
+	v, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return &packet{tag: tag, children: []*packet{newString(tagOctetString, attr), newString(tagOctetString, v)}}, nil
+}
+
+// unescapeFilter decodes the \XX escapes of a filter value
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(c[0])
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,462 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for Config
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultGroupsClaim   = "groups"
+)
+
+// DefaultScopes are requested unless configured otherwise
+var DefaultScopes = []string{"openid", "profile", "email"}
+
+const (
+	// loginTimeout is how long a user has to complete a login at the provider
+	loginTimeout = 10 * time.Minute
+	// clockSkew is tolerated between the provider's clock and ours
+	clockSkew = 2 * time.Minute
+	// keyRefreshInterval limits refetching the JWKS for unknown key IDs
+	keyRefreshInterval = time.Minute
+	// maxPendingLogins bounds the memory of logins that are started but
+	// never completed; past it the oldest are dropped
+	maxPendingLogins = 10000
+)
+
+// Errors returned by Provider
+var (
+	ErrUnknownState = errors.New("unknown or expired login state")
+	ErrNoUsername   = errors.New("the ID token has no username claim")
+	ErrNoSubject    = errors.New("the ID token has no subject")
+)
+
+// Config configures a Provider
+type Config struct {
+	Issuer       string   // The provider's issuer URL, also used for discovery
+	ClientID     string   // Client ID registered at the provider
+	ClientSecret string   // Optional; public clients rely on PKCE alone
+	RedirectURL  string   // Callback URL registered at the provider; derived from the request if empty
+	Scopes       []string // Requested scopes, default DefaultScopes
+	// UsernameClaim and GroupsClaim name the ID token claims holding the
+	// username and the groups. Nested claims are written with dots, e.g.
+	// "realm_access.roles".
+	UsernameClaim string
+	GroupsClaim   string
+}
+
+// User is a user authenticated by the provider
+type User struct {
+	Name    string
+	Subject string
+	Groups  []string
+}
+
+// metadata is the part of the discovery document we use
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// pendingLogin is a login started by AuthCodeURL
+type pendingLogin struct {
+	verifier    string // PKCE code verifier
+	nonce       string
+	redirectURL string
+	returnTo    string
+	created     time.Time
+}
+
+// Provider runs the authorization code flow with PKCE against an OpenID
+// Connect provider. Discovery happens on first use, so the server starts
+// even while the provider is unreachable.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	pending     map[string]*pendingLogin
+}
+
+// NewProvider creates a provider
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("OIDC requires an issuer and a client ID")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = DefaultUsernameClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	return &Provider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		pending: make(map[string]*pendingLogin),
+	}, nil
+}
+
+// RedirectURL returns the configured callback URL, or "" if it is derived
+// from the request
+func (p *Provider) RedirectURL() string {
+	return p.cfg.RedirectURL
+}
+
+// AuthCodeURL starts a login and returns the provider URL to send the user
+// to, along with the state that identifies the login. returnTo is handed
+// back by Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, returnTo string) (string, string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := &pendingLogin{
+		verifier:    randomString(32),
+		nonce:       randomString(16),
+		redirectURL: redirectURL,
+		returnTo:    returnTo,
+		created:     time.Now(),
+	}
+	state := randomString(16)
+
+	p.mu.Lock()
+	p.prune(login.created)
+	if len(p.pending) >= maxPendingLogins {
+		p.dropOldest()
+	}
+	p.pending[state] = login
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// Exchange completes the login identified by state: it redeems code for
+// tokens, verifies the ID token and maps its claims to a User. It also
+// returns the returnTo given to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, state, code string) (*User, string, error) {
+	p.mu.Lock()
+	login := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if login == nil || time.Since(login.created) > loginTimeout {
+		return nil, "", ErrUnknownState
+	}
+
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.redirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, "", fmt.Errorf("invalid token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, "", fmt.Errorf("token request failed (HTTP %d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, "", errors.New("token response has no ID token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, meta, tokens.IDToken, login.nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := p.mapClaims(claims)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, login.returnTo, nil
+}
+
+// verifyIDToken checks the signature and the standard claims of an ID token
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, token, nonce string) (map[string]interface{}, error) {
+	jwt, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(ctx, meta, jwt.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwt.verify(key); err != nil {
+		return nil, err
+	}
+
+	claims := jwt.claims
+	if iss, _ := claims["iss"].(string); iss != meta.Issuer {
+		return nil, fmt.Errorf("ID token issuer %q does not match %q", iss, meta.Issuer)
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, errors.New("ID token is not meant for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, errors.New("ID token was issued to another party")
+	}
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok || now.After(exp.Add(clockSkew)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if iat, ok := numericDate(claims["iat"]); ok && iat.After(now.Add(clockSkew)) {
+		return nil, errors.New("ID token was issued in the future")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && nbf.After(now.Add(clockSkew)) {
+		return nil, errors.New("ID token is not valid yet")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// mapClaims extracts the username and groups from verified claims. The
+// subject identifies the account, so a token without one is refused rather
+// than mapped to an account shared by every such token.
+func (p *Provider) mapClaims(claims map[string]interface{}) (*User, error) {
+	user := &User{}
+	user.Subject, _ = claims["sub"].(string)
+	if user.Subject == "" {
+		return nil, ErrNoSubject
+	}
+	user.Name, _ = claimValue(claims, p.cfg.UsernameClaim).(string)
+	if user.Name == "" {
+		return nil, fmt.Errorf("%w %q", ErrNoUsername, p.cfg.UsernameClaim)
+	}
+	switch groups := claimValue(claims, p.cfg.GroupsClaim).(type) {
+	case string:
+		user.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				user.Groups = append(user.Groups, s)
+			}
+		}
+	}
+	return user, nil
+}
+
+// discover fetches the discovery document once it is first needed
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+
+	meta = &metadata{}
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+// key returns the signing key kid, refetching the JWKS if it is unknown so
+// rotated keys are picked up
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysFetched) > keyRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, ErrUnknownKey
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for i := range set.Keys {
+		if k, err := set.Keys[i].publicKey(); err == nil {
+			keys[set.Keys[i].Kid] = k
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys, p.keysFetched = keys, time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// lookupKey finds a cached key. Tokens without a key ID are accepted if the
+// provider publishes a single key. p.mu must be held.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// prune drops logins that were never completed. p.mu must be held.
+func (p *Provider) prune(now time.Time) {
+	for state, login := range p.pending {
+		if now.Sub(login.created) > loginTimeout {
+			delete(p.pending, state)
+		}
+	}
+}
+
+// dropOldest drops the oldest pending login. p.mu must be held.
+func (p *Provider) dropOldest() {
+	var oldest string
+	for state, login := range p.pending {
+		if oldest == "" || login.created.Before(p.pending[oldest].created) {
+			oldest = state
+		}
+	}
+	delete(p.pending, oldest)
+}
+
+// claimValue looks up a claim, following dots into nested objects
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	if v, ok := claims[name]; ok {
+		return v
+	}
+	var cur interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = obj[part]
+	}
+	return cur
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate decodes a JWT NumericDate (seconds since the epoch)
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,418 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "file-server"
+
+// testIssuer is an identity provider serving discovery, a JWKS and a token
+// endpoint that hands out whatever ID token the test prepared
+type testIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]crypto.Signer // Published in the JWKS
+	jwksFetches int
+	challenge   string // PKCE challenge of the last login
+	idToken     string // Returned by the token endpoint
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	ti := &testIssuer{t: t, keys: make(map[string]crypto.Signer)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                ti.srv.URL,
+			AuthorizationEndpoint: ti.srv.URL + "/authorize",
+			TokenEndpoint:         ti.srv.URL + "/token",
+			JWKSURI:               ti.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		ti.mu.Lock()
+		defer ti.mu.Unlock()
+		ti.jwksFetches++
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, key := range ti.keys {
+			set.Keys = append(set.Keys, publicJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		ti.mu.Lock()
+		defer ti.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != ti.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": ti.idToken})
+	})
+	ti.srv = httptest.NewServer(mux)
+	t.Cleanup(ti.srv.Close)
+	return ti
+}
+
+// publish replaces the published keys
+func (ti *testIssuer) publish(keys map[string]crypto.Signer) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.keys = keys
+}
+
+func (ti *testIssuer) fetches() int {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.jwksFetches
+}
+
+func (ti *testIssuer) provider() *Provider {
+	p, err := NewProvider(Config{Issuer: ti.srv.URL, ClientID: testClientID})
+	if err != nil {
+		ti.t.Fatal(err)
+	}
+	return p
+}
+
+// login starts a login at p and returns its state and nonce
+func (ti *testIssuer) login(p *Provider) (string, string) {
+	ti.t.Helper()
+	authURL, state, err := p.AuthCodeURL(context.Background(), "https://files.example/api/oidc/callback", "/docs")
+	if err != nil {
+		ti.t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		ti.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("state") != state {
+		ti.t.Fatalf("authorization URL state %q, want %q", q.Get("state"), state)
+	}
+	ti.mu.Lock()
+	ti.challenge = q.Get("code_challenge")
+	ti.mu.Unlock()
+	return state, q.Get("nonce")
+}
+
+// issue makes the token endpoint return a token with claims signed by key
+func (ti *testIssuer) issue(kid, alg string, key crypto.Signer, claims map[string]interface{}) {
+	ti.t.Helper()
+	token, err := signJWT(kid, alg, key, claims)
+	if err != nil {
+		ti.t.Fatal(err)
+	}
+	ti.mu.Lock()
+	ti.idToken = token
+	ti.mu.Unlock()
+}
+
+// claims returns valid ID token claims for a login with nonce
+func (ti *testIssuer) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                ti.srv.URL,
+		"sub":                "248289761001",
+		"aud":                testClientID,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "admins"},
+	}
+}
+
+func signJWT(kid, alg string, key crypto.Signer, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := crypto.SHA256
+	switch alg[2:] {
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		if err == nil {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			sig = make([]byte, 2*size)
+			r.FillBytes(sig[:size])
+			s.FillBytes(sig[size:])
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func publicJWK(kid string, key crypto.PublicKey) jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: enc(k.N.Bytes()), E: enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jwk{Kty: "EC", Kid: kid, Use: "sig", Crv: k.Curve.Params().Name, X: enc(k.X.FillBytes(make([]byte, size))), Y: enc(k.Y.FillBytes(make([]byte, size)))}
+	}
+	return jwk{Kid: kid}
+}
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	p256 *ecdsa.PrivateKey
+	p384 *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, p256: p256, p384: p384}
+}
+
+func TestExchange(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, tc := range []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", keys.rsa},
+		{"RS512", keys.rsa},
+		{"ES256", keys.p256},
+		{"ES384", keys.p384},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			ti.publish(map[string]crypto.Signer{"k1": tc.key})
+			p := ti.provider()
+
+			state, nonce := ti.login(p)
+			ti.issue("k1", tc.alg, tc.key, ti.claims(nonce))
+			user, returnTo, err := p.Exchange(context.Background(), state, "code")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Name != "alice" || user.Subject != "248289761001" || strings.Join(user.Groups, ",") != "staff,admins" {
+				t.Errorf("user = %+v", user)
+			}
+			if returnTo != "/docs" {
+				t.Errorf("returnTo = %q, want /docs", returnTo)
+			}
+		})
+	}
+}
+
+func TestExchangeAlgorithmMismatch(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, tc := range []struct {
+		name      string
+		alg       string
+		published crypto.Signer
+	}{
+		{"RS256 with EC key", "RS256", keys.p256},
+		{"ES256 with RSA key", "ES256", keys.rsa},
+		{"ES256 with P-384 key", "ES256", keys.p384},
+		{"ES384 with P-256 key", "ES384", keys.p256},
+		{"none", "none", keys.rsa},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			ti.publish(map[string]crypto.Signer{"k1": tc.published})
+			p := ti.provider()
+
+			state, nonce := ti.login(p)
+			ti.issue("k1", tc.alg, tc.published, ti.claims(nonce))
+			_, _, err := p.Exchange(context.Background(), state, "code")
+			if err == nil || tc.alg != "none" && !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("err = %v, want %v", err, ErrBadSignature)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, tc := range []struct {
+		name   string
+		change func(claims map[string]interface{})
+		want   string
+	}{
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-clockSkew - time.Minute).Unix() }, "expired"},
+		{"no expiry", func(c map[string]interface{}) { delete(c, "exp") }, "expired"},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = []string{"other-client"} }, "not meant for this client"},
+		{"wrong authorized party", func(c map[string]interface{}) { c["azp"] = "other-client" }, "another party"},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, "issuer"},
+		{"wrong nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, "nonce"},
+		{"no nonce", func(c map[string]interface{}) { delete(c, "nonce") }, "nonce"},
+		{"not valid yet", func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() }, "not valid yet"},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, ErrNoSubject.Error()},
+		{"empty subject", func(c map[string]interface{}) { c["sub"] = "" }, ErrNoSubject.Error()},
+		{"numeric subject", func(c map[string]interface{}) { c["sub"] = 42 }, ErrNoSubject.Error()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			ti.publish(map[string]crypto.Signer{"k1": keys.rsa})
+			p := ti.provider()
+
+			state, nonce := ti.login(p)
+			claims := ti.claims(nonce)
+			tc.change(claims)
+			ti.issue("k1", "RS256", keys.rsa, claims)
+			_, _, err := p.Exchange(context.Background(), state, "code")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestExchangeRefetchesKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	ti := newTestIssuer(t)
+	ti.publish(map[string]crypto.Signer{"old": keys.rsa})
+	p := ti.provider()
+
+	state, nonce := ti.login(p)
+	ti.issue("old", "RS256", keys.rsa, ti.claims(nonce))
+	if _, _, err := p.Exchange(context.Background(), state, "code"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ti.fetches(); n != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", n)
+	}
+
+	// The provider rotates its key. Right after a fetch the unknown key ID
+	// does not trigger another one.
+	ti.publish(map[string]crypto.Signer{"new": keys.p256})
+	state, nonce = ti.login(p)
+	ti.issue("new", "ES256", keys.p256, ti.claims(nonce))
+	if _, _, err := p.Exchange(context.Background(), state, "code"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownKey)
+	}
+	if n := ti.fetches(); n != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Once the keys are older than keyRefreshInterval they are refetched
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-keyRefreshInterval - time.Second)
+	p.mu.Unlock()
+	state, nonce = ti.login(p)
+	ti.issue("new", "ES256", keys.p256, ti.claims(nonce))
+	if _, _, err := p.Exchange(context.Background(), state, "code"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ti.fetches(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+
+	// A key ID the provider does not publish is still rejected
+	p.mu.Lock()
+	p.keysFetched = time.Time{}
+	p.mu.Unlock()
+	state, nonce = ti.login(p)
+	ti.issue("forged", "RS256", keys.rsa, ti.claims(nonce))
+	if _, _, err := p.Exchange(context.Background(), state, "code"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestExchangeReplayedState(t *testing.T) {
+	keys := newTestKeys(t)
+	ti := newTestIssuer(t)
+	ti.publish(map[string]crypto.Signer{"k1": keys.rsa})
+	p := ti.provider()
+
+	state, nonce := ti.login(p)
+	ti.issue("k1", "RS256", keys.rsa, ti.claims(nonce))
+	if _, _, err := p.Exchange(context.Background(), state, "code"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Exchange(context.Background(), state, "code"); !errors.Is(err, ErrUnknownState) {
+		t.Fatalf("replayed state: err = %v, want %v", err, ErrUnknownState)
+	}
+	if _, _, err := p.Exchange(context.Background(), "never-issued", "code"); !errors.Is(err, ErrUnknownState) {
+		t.Fatalf("unknown state: err = %v, want %v", err, ErrUnknownState)
+	}
+
+	// Logins not completed within loginTimeout expire
+	state, _ = ti.login(p)
+	p.mu.Lock()
+	p.pending[state].created = time.Now().Add(-loginTimeout - time.Second)
+	p.mu.Unlock()
+	if _, _, err := p.Exchange(context.Background(), state, "code"); !errors.Is(err, ErrUnknownState) {
+		t.Fatalf("expired state: err = %v, want %v", err, ErrUnknownState)
+	}
+}
+
+func TestPendingLoginsBounded(t *testing.T) {
+	ti := newTestIssuer(t)
+	p := ti.provider()
+
+	first, _ := ti.login(p)
+	for i := 0; i < maxPendingLogins; i++ {
+		if _, _, err := p.AuthCodeURL(context.Background(), "https://files.example/api/oidc/callback", "/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.mu.Lock()
+	n := len(p.pending)
+	_, kept := p.pending[first]
+	p.mu.Unlock()
+	if n != maxPendingLogins {
+		t.Errorf("%d pending logins, want %d", n, maxPendingLogins)
+	}
+	if kept {
+		t.Error("the oldest pending login was not dropped")
+	}
+}
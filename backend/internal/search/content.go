@@ -0,0 +1,252 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Default limits for content search
+const (
+	DefaultMaxContentFileSize = 10 << 20
+	DefaultMaxLineMatches     = 20
+	maxSnippetLength          = 200
+)
+
+// ContentOptions configures a content search
+type ContentOptions struct {
+	Options       // Scope, pruning and ACL; MaxResults counts matching files
+	Pattern       string
+	Regex         bool // Treat Pattern as a regular expression
+	CaseSensitive bool
+	Filter        *Query // Optional filter on file names and metadata
+	MaxFileSize   int64  // Larger files are skipped
+	MaxMatches    int    // Maximum matching lines reported per file
+	// OnResult, if set, is called for each matching file as soon as it is found
+	OnResult func(ContentResult)
+}
+
+// ContentMatch is a single matching line
+type ContentMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// ContentResult is a file whose content matched
+type ContentResult struct {
+	Result
+	Matches []ContentMatch `json:"matches"`
+}
+
+// SearchContent greps text files below rootDir for opts.Pattern using a
+// bounded worker pool. Allow is consulted before any file is read. It stops
+// early when ctx is cancelled or MaxResults files have matched; the returned
+// results are sorted by path.
+func SearchContent(ctx context.Context, rootDir string, opts ContentOptions) ([]ContentResult, error) {
+	if opts.Pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+	matcher, err := newLineMatcher(opts.Pattern, opts.Regex, opts.CaseSensitive)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = 100
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxContentFileSize
+	}
+	if opts.MaxMatches <= 0 {
+		opts.MaxMatches = DefaultMaxLineMatches
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		rel  string
+		info fs.FileInfo
+	}
+	jobs := make(chan job)
+
+	var mu sync.Mutex
+	var results []ContentResult
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				matches := grepFile(ctx, filepath.Join(rootDir, j.rel), matcher, opts.MaxMatches)
+				if len(matches) == 0 {
+					continue
+				}
+				mu.Lock()
+				if len(results) < opts.MaxResults {
+					result := ContentResult{Result: newResult(j.rel, j.info), Matches: matches}
+					results = append(results, result)
+					if opts.OnResult != nil {
+						opts.OnResult(result)
+					}
+				}
+				if len(results) >= opts.MaxResults {
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	w := newWalker(ctx, rootDir, opts.Options)
+	walkErr := w.walk(func(rel string, d fs.DirEntry) bool {
+		if d.IsDir() || !d.Type().IsRegular() {
+			return true
+		}
+		if opts.Allow != nil && !opts.Allow(rel) {
+			return true
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() == 0 || info.Size() > opts.MaxFileSize {
+			return true
+		}
+		if opts.Filter != nil && !opts.Filter.Match(rel, false, info.Size(), info.ModTime()) {
+			return true
+		}
+		if !looksLikeText(d.Name()) {
+			return true
+		}
+
+		select {
+		case jobs <- job{rel: rel, info: info}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	if walkErr == context.Canceled && len(results) >= opts.MaxResults {
+		// Cancelled by ourselves after reaching the limit
+		walkErr = nil
+	}
+	return results, walkErr
+}
+
+// lineMatcher reports whether a line matches
+type lineMatcher func(line []byte) bool
+
+func newLineMatcher(pattern string, isRegex, caseSensitive bool) (lineMatcher, error) {
+	if isRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if !caseSensitive {
+			re = regexp.MustCompile("(?i)" + pattern)
+		}
+		return re.Match, nil
+	}
+
+	if caseSensitive {
+		needle := []byte(pattern)
+		return func(line []byte) bool { return bytes.Contains(line, needle) }, nil
+	}
+	needle := bytes.ToLower([]byte(pattern))
+	return func(line []byte) bool { return bytes.Contains(bytes.ToLower(line), needle) }, nil
+}
+
+// looksLikeText rejects files whose extension maps to a known non-text MIME
+// type. Unknown types are sniffed when the file is read.
+func looksLikeText(name string) bool {
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if mediaType == "" || strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-javascript", "application/x-sh", "application/x-yaml",
+		"application/yaml", "application/toml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// grepFile returns up to maxMatches matching lines of a text file. Binary
+// files, detected by sniffing the first block, yield no matches.
+func grepFile(ctx context.Context, fullPath string, match lineMatcher, maxMatches int) []ContentMatch {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil
+	}
+	if bytes.IndexByte(head, 0) >= 0 || !strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return nil
+	}
+
+	var matches []ContentMatch
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if line%1024 == 0 && ctx.Err() != nil {
+			return nil
+		}
+		if match(scanner.Bytes()) {
+			matches = append(matches, ContentMatch{Line: line, Text: snippet(scanner.Bytes())})
+			if len(matches) >= maxMatches {
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// snippet trims a matching line to a displayable length
+func snippet(line []byte) string {
+	line = bytes.TrimSpace(line)
+	if len(line) > maxSnippetLength {
+		// Do not cut a multi-byte character in half
+		cut := maxSnippetLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		return string(line[:cut]) + "…"
+	}
+	return string(line)
+}
+
+func newResult(rel string, info fs.FileInfo) Result {
+	return Result{
+		Path:    rel,
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
+	}
+}
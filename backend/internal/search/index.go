@@ -0,0 +1,558 @@
+package search
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IndexOptions configures an Index
+type IndexOptions struct {
+	// File, if set, is where the index is persisted between restarts. A saved
+	// index is loaded at startup so queries can be answered before the first scan.
+	File string
+	// RescanInterval is how often the whole tree is rescanned. The rescan
+	// catches changes missed by the filesystem watcher (or replaces it where
+	// watching is unsupported). Zero disables periodic rescans.
+	RescanInterval time.Duration
+	// SkipDir, if set, excludes directories (and everything below them) from
+	// the index. relPath is relative to the root.
+	SkipDir func(relPath string) bool
+	// AllowSymlink, if set, excludes symlinks that may not be followed
+	AllowSymlink func(relPath string) bool
+}
+
+// Index is an in-memory trigram index of the file names under a root directory.
+// It is built by a full walk, kept fresh by a filesystem watcher where
+// available, and periodically rebuilt.
+type Index struct {
+	root string
+	opts IndexOptions
+
+	mu    sync.RWMutex
+	data  *indexData
+	ready bool
+
+	rescan  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	watcher dirWatcher
+
+	stateMu sync.Mutex // Guards started and closed, Start and Close may race on shutdown
+	started bool
+	closed  bool
+}
+
+// indexEntry is a single indexed path
+type indexEntry struct {
+	Path    string // Slash-separated path relative to the root
+	IsDir   bool
+	Size    int64
+	ModTime int64 // Unix seconds
+	name    string
+	deleted bool
+}
+
+// indexData holds the entries and their trigram postings. Entry IDs are only
+// ever appended, so every posting list is sorted in walk order.
+type indexData struct {
+	entries  []indexEntry
+	byPath   map[string]uint32
+	grams    map[uint32][]uint32
+	children map[string][]uint32 // Parent directory path to the IDs of its entries
+	live     int
+}
+
+// Tombstoned entries are compacted away once they make up more than
+// 1/compactRatio of the entries, and there are at least compactMin of them
+const (
+	compactRatio = 2
+	compactMin   = 1024
+)
+
+// NewIndex creates an index for rootDir. Call Start to build it.
+func NewIndex(rootDir string, opts IndexOptions) *Index {
+	return &Index{
+		root:   rootDir,
+		opts:   opts,
+		data:   newIndexData(),
+		rescan: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start loads a persisted index if configured and begins building and
+// maintaining the index in the background
+func (ix *Index) Start() {
+	ix.stateMu.Lock()
+	defer ix.stateMu.Unlock()
+	if ix.started || ix.closed {
+		return
+	}
+	ix.started = true
+
+	if ix.opts.File != "" {
+		if data, err := loadIndexData(ix.opts.File); err == nil {
+			ix.mu.Lock()
+			ix.data = data
+			ix.ready = true
+			ix.mu.Unlock()
+		} else if !os.IsNotExist(err) {
+			fmt.Printf("Warning: failed to load search index %s: %v\n", ix.opts.File, err)
+		}
+	}
+
+	go ix.run()
+}
+
+// Ready reports whether the index can answer queries
+func (ix *Index) Ready() bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.ready
+}
+
+// Len returns the number of indexed paths
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.data.live
+}
+
+// Close stops background maintenance and persists the index if configured.
+// Calls after the first, and Start after Close, do nothing.
+func (ix *Index) Close() error {
+	ix.stateMu.Lock()
+	if ix.closed {
+		ix.stateMu.Unlock()
+		return nil
+	}
+	ix.closed = true
+	started := ix.started
+	ix.stateMu.Unlock()
+	if !started {
+		return nil
+	}
+
+	close(ix.stop)
+	<-ix.done
+	if ix.opts.File == "" || !ix.Ready() {
+		return nil
+	}
+	return ix.save()
+}
+
+// Search returns up to maxResults indexed paths below scope (relative to the
+// root, "" for everything) matching q, in walk order
+func (ix *Index) Search(q *Query, scope string, maxResults int) []Result {
+	if maxResults <= 0 {
+		maxResults = 100
+	}
+	prefix := ""
+	if scope = filepath.ToSlash(filepath.Clean(scope)); scope != "." && scope != "/" {
+		prefix = strings.Trim(scope, "/") + "/"
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var results []Result
+	ix.data.candidates(q.nameHint(), func(e *indexEntry) bool {
+		if strings.HasPrefix(e.Path, prefix) && q.match(e.candidate()) {
+			results = append(results, e.result())
+		}
+		return len(results) < maxResults
+	})
+	return results
+}
+
+func (ix *Index) run() {
+	defer close(ix.done)
+
+	events := make(chan fsEvent, 1024)
+	w, err := newDirWatcher(ix.root, events)
+	if err != nil {
+		fmt.Printf("Search index: filesystem watching unavailable, relying on periodic rescans: %v\n", err)
+	} else {
+		ix.watcher = w
+		defer w.Close()
+	}
+
+	ix.build()
+
+	var tick <-chan time.Time
+	if ix.opts.RescanInterval > 0 {
+		ticker := time.NewTicker(ix.opts.RescanInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ix.stop:
+			return
+		case <-tick:
+			ix.build()
+		case <-ix.rescan:
+			ix.build()
+		case ev := <-events:
+			ix.apply(ev)
+		}
+	}
+}
+
+// build walks the whole tree and atomically replaces the index
+func (ix *Index) build() {
+	start := time.Now()
+	data := newIndexData()
+	filepath.WalkDir(ix.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries, continue indexing
+		}
+		select {
+		case <-ix.stop:
+			return filepath.SkipAll
+		default:
+		}
+
+		rel, err := filepath.Rel(ix.root, p)
+		if err != nil || rel == "." {
+			if d.IsDir() && ix.watcher != nil {
+				ix.watcher.Add(".")
+			}
+			return nil
+		}
+		if d.IsDir() && ix.skip(rel) {
+			return filepath.SkipDir
+		}
+		if ix.deniedSymlink(rel, d.Type()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		data.add(filepath.ToSlash(rel), info)
+		if d.IsDir() && ix.watcher != nil {
+			if err := ix.watcher.Add(rel); err != nil {
+				// Most likely the inotify watch limit; rescans still cover this subtree
+				fmt.Printf("Search index: cannot watch %s: %v\n", rel, err)
+			}
+		}
+		return nil
+	})
+
+	ix.mu.Lock()
+	ix.data = data
+	ix.ready = true
+	ix.mu.Unlock()
+
+	fmt.Printf("Search index: %d paths indexed in %s\n", data.live, time.Since(start).Round(time.Millisecond))
+	if ix.opts.File != "" {
+		if err := ix.save(); err != nil {
+			fmt.Printf("Warning: failed to save search index %s: %v\n", ix.opts.File, err)
+		}
+	}
+}
+
+// apply updates the index for a single watcher event
+func (ix *Index) apply(ev fsEvent) {
+	if ev.Overflow {
+		select {
+		case ix.rescan <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	rel := path.Join(ev.Dir, ev.Name)
+	full := filepath.Join(ix.root, filepath.FromSlash(rel))
+
+	if ev.Op == opRemove {
+		ix.mu.Lock()
+		ix.data.removeTree(rel)
+		ix.mu.Unlock()
+		return
+	}
+
+	info, err := os.Lstat(full)
+	if err != nil {
+		ix.mu.Lock()
+		ix.data.removeTree(rel)
+		ix.mu.Unlock()
+		return
+	}
+
+	if ix.deniedSymlink(rel, info.Mode()) {
+		return
+	}
+
+	if !info.IsDir() {
+		ix.mu.Lock()
+		ix.data.add(rel, info)
+		ix.mu.Unlock()
+		return
+	}
+
+	// A new or moved-in directory: index and watch the whole subtree
+	filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		r, err := filepath.Rel(ix.root, p)
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && ix.skip(r) {
+			return filepath.SkipDir
+		}
+		if ix.deniedSymlink(r, d.Type()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		ix.mu.Lock()
+		ix.data.add(filepath.ToSlash(r), info)
+		ix.mu.Unlock()
+		if d.IsDir() && ix.watcher != nil {
+			ix.watcher.Add(r)
+		}
+		return nil
+	})
+}
+
+// skip reports whether the directory rel is excluded from the index
+func (ix *Index) skip(rel string) bool {
+	return ix.opts.SkipDir != nil && ix.opts.SkipDir(filepath.ToSlash(rel))
+}
+
+// deniedSymlink reports whether rel is a symlink that may not be followed
+func (ix *Index) deniedSymlink(rel string, mode fs.FileMode) bool {
+	return mode&fs.ModeSymlink != 0 && ix.opts.AllowSymlink != nil && !ix.opts.AllowSymlink(filepath.ToSlash(rel))
+}
+
+func (ix *Index) save() error {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	tmp := ix.opts.File + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]indexEntry, 0, ix.data.live)
+	for _, e := range ix.data.entries {
+		if !e.deleted {
+			entries = append(entries, e)
+		}
+	}
+	if err := gob.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, ix.opts.File)
+}
+
+func loadIndexData(file string) (*indexData, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	data := newIndexData()
+	for _, e := range entries {
+		data.insert(e)
+	}
+	return data, nil
+}
+
+func newIndexData() *indexData {
+	return &indexData{
+		byPath:   make(map[string]uint32),
+		grams:    make(map[uint32][]uint32),
+		children: make(map[string][]uint32),
+	}
+}
+
+// add inserts or replaces the entry for rel
+func (d *indexData) add(rel string, info fs.FileInfo) {
+	e := indexEntry{
+		Path:    rel,
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+	}
+	if id, ok := d.byPath[rel]; ok {
+		// Name is unchanged, so the postings can be kept
+		old := &d.entries[id]
+		old.IsDir, old.Size, old.ModTime = e.IsDir, e.Size, e.ModTime
+		return
+	}
+	d.insert(e)
+}
+
+func (d *indexData) insert(e indexEntry) {
+	e.name = strings.ToLower(path.Base(e.Path))
+	id := uint32(len(d.entries))
+	d.entries = append(d.entries, e)
+	d.byPath[e.Path] = id
+	dir := path.Dir(e.Path)
+	d.children[dir] = append(d.children[dir], id)
+	d.live++
+	for _, g := range trigrams(e.name) {
+		d.grams[g] = append(d.grams[g], id)
+	}
+}
+
+// removeTree tombstones rel and everything below it, then compacts the
+// index if tombstones have piled up
+func (d *indexData) removeTree(rel string) {
+	id, ok := d.byPath[rel]
+	if !ok {
+		return
+	}
+	d.remove(id)
+	if dead := len(d.entries) - d.live; dead >= compactMin && dead*compactRatio > len(d.entries) {
+		d.compact()
+	}
+}
+
+// remove tombstones the entry id and, for a directory, its children
+func (d *indexData) remove(id uint32) {
+	e := &d.entries[id]
+	if e.deleted {
+		return
+	}
+	e.deleted = true
+	delete(d.byPath, e.Path)
+	d.live--
+	if !e.IsDir {
+		return
+	}
+	children := d.children[e.Path]
+	delete(d.children, e.Path)
+	for _, child := range children {
+		d.remove(child)
+	}
+}
+
+// compact rebuilds the entries and postings without tombstones, keeping the
+// walk order
+func (d *indexData) compact() {
+	entries := d.entries
+	*d = *newIndexData()
+	for _, e := range entries {
+		if !e.deleted {
+			d.insert(e)
+		}
+	}
+}
+
+// candidates calls fn, in walk order, for every live entry whose name may
+// contain hint, until fn returns false
+func (d *indexData) candidates(hint string, fn func(*indexEntry) bool) {
+	grams := trigrams(hint)
+	if len(grams) == 0 {
+		// Query too short for the trigram index, scan everything
+		for i := range d.entries {
+			if !d.entries[i].deleted && !fn(&d.entries[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	// Walk the shortest posting list; fn verifies the actual match
+	var best []uint32
+	for i, g := range grams {
+		list := d.grams[g]
+		if len(list) == 0 {
+			return
+		}
+		if i == 0 || len(list) < len(best) {
+			best = list
+		}
+	}
+	for _, id := range best {
+		if !d.entries[id].deleted && !fn(&d.entries[id]) {
+			return
+		}
+	}
+}
+
+func (e *indexEntry) candidate() *candidate {
+	return &candidate{
+		path:    strings.ToLower(e.Path),
+		name:    e.name,
+		isDir:   e.IsDir,
+		size:    e.Size,
+		modTime: time.Unix(e.ModTime, 0),
+	}
+}
+
+func (e *indexEntry) result() Result {
+	return Result{
+		Path:    filepath.FromSlash(e.Path),
+		IsDir:   e.IsDir,
+		Size:    e.Size,
+		ModTime: time.Unix(e.ModTime, 0).Format("2006-01-02 15:04:05"),
+	}
+}
+
+// trigrams returns the distinct byte trigrams of s
+func trigrams(s string) []uint32 {
+	if len(s) < 3 {
+		return nil
+	}
+	seen := make(map[uint32]struct{}, len(s))
+	grams := make([]uint32, 0, len(s)-2)
+	for i := 0; i+3 <= len(s); i++ {
+		g := uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+		if _, ok := seen[g]; !ok {
+			seen[g] = struct{}{}
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// Watcher event operations
+const (
+	opCreate = iota // Created, moved in or modified
+	opRemove        // Deleted or moved out
+)
+
+// fsEvent is a change reported by a dirWatcher
+type fsEvent struct {
+	Dir      string // Slash-separated directory relative to the root
+	Name     string
+	Op       int
+	Overflow bool // Events were dropped; a full rescan is needed
+}
+
+// dirWatcher watches individual directories for changes
+type dirWatcher interface {
+	Add(rel string) error
+	Close() error
+}
@@ -0,0 +1,152 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeInfo is the fs.FileInfo of an indexed test path
+type fakeInfo struct {
+	os.FileInfo
+	dir bool
+}
+
+func (fi fakeInfo) IsDir() bool        { return fi.dir }
+func (fi fakeInfo) Size() int64        { return 0 }
+func (fi fakeInfo) ModTime() time.Time { return time.Time{} }
+
+func livePaths(d *indexData) []string {
+	var paths []string
+	d.candidates("", func(e *indexEntry) bool {
+		paths = append(paths, e.Path)
+		return true
+	})
+	return paths
+}
+
+func TestIndexRemoveTree(t *testing.T) {
+	d := newIndexData()
+	for _, p := range []string{"a", "a/b", "a/b/c.txt", "a/d.txt", "ab", "ab/e.txt", "f.txt"} {
+		d.add(p, fakeInfo{dir: filepath.Ext(p) == ""})
+	}
+
+	d.removeTree("a/d.txt")
+	d.removeTree("a")
+	if got, want := livePaths(d), []string{"ab", "ab/e.txt", "f.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths %v, want %v", got, want)
+	}
+	if d.live != 3 || len(d.byPath) != 3 {
+		t.Errorf("live %d, byPath %d, want 3", d.live, len(d.byPath))
+	}
+
+	// A path added again after removal is found again
+	d.add("a", fakeInfo{dir: true})
+	d.add("a/d.txt", fakeInfo{})
+	var found []string
+	d.candidates("d.txt", func(e *indexEntry) bool {
+		found = append(found, e.Path)
+		return true
+	})
+	if !slices.Equal(found, []string{"a/d.txt"}) {
+		t.Errorf("found %v", found)
+	}
+}
+
+func TestIndexCompact(t *testing.T) {
+	d := newIndexData()
+	d.add("keep", fakeInfo{dir: true})
+	d.add("keep/last.txt", fakeInfo{})
+	d.add("tmp", fakeInfo{dir: true})
+	for i := 0; i < compactMin; i++ {
+		d.add(fmt.Sprintf("tmp/file%d.txt", i), fakeInfo{})
+	}
+	d.add("z.txt", fakeInfo{})
+
+	d.removeTree("tmp")
+	if len(d.entries) != 3 || d.live != 3 {
+		t.Fatalf("%d entries, %d live after removing most of the index, want 3", len(d.entries), d.live)
+	}
+	if got, want := livePaths(d), []string{"keep", "keep/last.txt", "z.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths %v, want %v", got, want)
+	}
+	for p, id := range d.byPath {
+		if d.entries[id].Path != p {
+			t.Errorf("byPath[%q] points to %q", p, d.entries[id].Path)
+		}
+	}
+
+	// Removing a directory after compaction still removes its children
+	d.removeTree("keep")
+	if got := livePaths(d); !slices.Equal(got, []string{"z.txt"}) {
+		t.Errorf("paths %v, want [z.txt]", got)
+	}
+}
+
+// TestIndexWatchRename checks that a directory renamed inside the root is
+// watched under its new path, and no longer under the old one
+func TestIndexWatchRename(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("filesystem watching is only supported on Linux")
+	}
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "old", "sub"), 0755)
+	os.MkdirAll(filepath.Join(root, "out"), 0755)
+
+	ix := NewIndex(root, IndexOptions{})
+	ix.Start()
+	defer ix.Close()
+
+	waitFor := func(name string, want []string) {
+		t.Helper()
+		q, err := ParseQuery(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			got = got[:0]
+			if ix.Ready() {
+				for _, r := range ix.Search(q, "", 0) {
+					got = append(got, filepath.ToSlash(r.Path))
+				}
+				if slices.Equal(got, want) {
+					return
+				}
+			}
+		}
+		t.Fatalf("search %q: %v, want %v", name, got, want)
+	}
+	waitFor("sub", []string{"old/sub"})
+
+	if err := os.Rename(filepath.Join(root, "old"), filepath.Join(root, "new")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("sub", []string{"new/sub"})
+
+	os.WriteFile(filepath.Join(root, "new", "sub", "created.txt"), nil, 0644)
+	waitFor("created", []string{"new/sub/created.txt"})
+
+	// Moved out of the root, the directory must stop reporting events
+	gone := filepath.Join(t.TempDir(), "gone")
+	if err := os.Rename(filepath.Join(root, "new"), gone); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("sub", nil)
+
+	// A stale watch would report removing gone/sub/twin.txt as new/sub/twin.txt
+	os.MkdirAll(filepath.Join(root, "new", "sub"), 0755)
+	os.WriteFile(filepath.Join(root, "new", "sub", "twin.txt"), nil, 0644)
+	waitFor("twin", []string{"new/sub/twin.txt"})
+	os.WriteFile(filepath.Join(gone, "sub", "twin.txt"), nil, 0644)
+	os.Remove(filepath.Join(gone, "sub", "twin.txt"))
+	// Events are handled in order, so once the marker is indexed the removal
+	// would have been too
+	os.WriteFile(filepath.Join(root, "out", "marker.txt"), nil, 0644)
+	waitFor("marker", []string{"out/marker.txt"})
+	waitFor("twin", []string{"new/sub/twin.txt"})
+}
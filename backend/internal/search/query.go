@@ -0,0 +1,386 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed search query. A query is a list of whitespace-separated
+// terms that must all match (implicit AND); a leading '-' outside quotes
+// negates a term, so "-draft" searches for a literal "-draft".
+//
+// Supported terms:
+//
+//	word          base name contains word (case-insensitive)
+//	"two words"   quoted substring, may contain spaces
+//	*.go, a?c     glob on the base name (also [a-z] classes)
+//	re:^foo\d+$   regular expression on the base name (case-insensitive)
+//	ext:pdf       extension, comma-separated list allowed (ext:jpg,png)
+//	size:>10MB    size comparison with >, >=, <, <=, = and B/KB/MB/GB/TB units
+//	modified:<2025-01-01  modification time, date or RFC 3339 timestamp
+//	path:reports/ relative path contains the given text
+//	type:dir      type:dir or type:file
+type Query struct {
+	Raw   string
+	terms []term
+}
+
+// ParseError describes why a query could not be parsed
+type ParseError struct {
+	Term string // The offending term
+	Pos  int    // Byte offset of the term in the query
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid search term %q at position %d: %s", e.Term, e.Pos, e.Msg)
+}
+
+// candidate is a file being tested against a query
+type candidate struct {
+	path    string // Slash-separated path relative to the root, lowercased
+	name    string // Base name, lowercased
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// term is a single node of the query AST
+type term interface {
+	match(c *candidate) bool
+}
+
+type (
+	notTerm   struct{ term term }
+	nameTerm  struct{ substr string }
+	globTerm  struct{ pattern string }
+	regexTerm struct{ re *regexp.Regexp }
+	extTerm   struct{ exts []string }
+	pathTerm  struct{ substr string }
+	typeTerm  struct{ dir bool }
+	sizeTerm  struct {
+		op   string
+		size int64
+	}
+	timeTerm struct {
+		op       string
+		from, to time.Time // Matching interval for "=", from is the bound otherwise
+	}
+)
+
+func (t notTerm) match(c *candidate) bool   { return !t.term.match(c) }
+func (t nameTerm) match(c *candidate) bool  { return strings.Contains(c.name, t.substr) }
+func (t regexTerm) match(c *candidate) bool { return t.re.MatchString(c.name) }
+func (t pathTerm) match(c *candidate) bool  { return strings.Contains(c.path, t.substr) }
+func (t typeTerm) match(c *candidate) bool  { return c.isDir == t.dir }
+
+func (t globTerm) match(c *candidate) bool {
+	ok, _ := path.Match(t.pattern, c.name)
+	return ok
+}
+
+func (t extTerm) match(c *candidate) bool {
+	if c.isDir {
+		return false
+	}
+	ext := strings.TrimPrefix(path.Ext(c.name), ".")
+	for _, e := range t.exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (t sizeTerm) match(c *candidate) bool {
+	if c.isDir {
+		return false
+	}
+	return compare(t.op, c.size, t.size)
+}
+
+func (t timeTerm) match(c *candidate) bool {
+	if t.op == "=" {
+		return !c.modTime.Before(t.from) && c.modTime.Before(t.to)
+	}
+	return compare(t.op, c.modTime.UnixNano(), t.from.UnixNano())
+}
+
+func compare(op string, a, b int64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return a == b
+}
+
+// ParseQuery parses a query string. Errors are of type *ParseError.
+func ParseQuery(raw string) (*Query, error) {
+	tokens, err := tokenize(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &ParseError{Term: raw, Msg: "empty query"}
+	}
+
+	q := &Query{Raw: raw}
+	for _, tok := range tokens {
+		t, err := parseTerm(tok)
+		if err != nil {
+			return nil, err
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+// Match reports whether a file matches the query. relPath is relative to the
+// search root.
+func (q *Query) Match(relPath string, isDir bool, size int64, modTime time.Time) bool {
+	relPath = strings.ToLower(strings.ReplaceAll(relPath, "\\", "/"))
+	return q.match(&candidate{
+		path:    relPath,
+		name:    path.Base(relPath),
+		isDir:   isDir,
+		size:    size,
+		modTime: modTime,
+	})
+}
+
+func (q *Query) match(c *candidate) bool {
+	for _, t := range q.terms {
+		if !t.match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// needsInfo reports whether matching requires file size or modification time
+func (q *Query) needsInfo() bool {
+	for _, t := range q.terms {
+		if n, ok := t.(notTerm); ok {
+			t = n.term
+		}
+		switch t.(type) {
+		case sizeTerm, timeTerm:
+			return true
+		}
+	}
+	return false
+}
+
+// nameHint returns the longest literal that every matching base name must
+// contain, for use with the trigram index, or "" if there is none
+func (q *Query) nameHint() string {
+	hint := ""
+	for _, t := range q.terms {
+		if n, ok := t.(nameTerm); ok && len(n.substr) > len(hint) {
+			hint = n.substr
+		}
+	}
+	return hint
+}
+
+type token struct {
+	text       string
+	pos        int
+	quoted     bool
+	quoteStart int // Offset in text of the first quoted character, len(text) if none
+}
+
+// tokenize splits a query on whitespace, honoring double quotes
+func tokenize(raw string) ([]token, error) {
+	var tokens []token
+	var b strings.Builder
+	start, inToken, inQuote, quoted, quoteStart := 0, false, false, false, 0
+
+	flush := func() {
+		if inToken {
+			if !quoted {
+				quoteStart = b.Len()
+			}
+			tokens = append(tokens, token{text: b.String(), pos: start, quoted: quoted, quoteStart: quoteStart})
+		}
+		b.Reset()
+		inToken, quoted = false, false
+	}
+
+	for i, r := range raw {
+		switch {
+		case r == '"':
+			if !inToken {
+				start, inToken = i, true
+			}
+			if !quoted {
+				quoteStart = b.Len()
+			}
+			inQuote = !inQuote
+			quoted = true
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			if !inToken {
+				start, inToken = i, true
+			}
+			b.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, &ParseError{Term: raw[start:], Pos: start, Msg: "unterminated quote"}
+	}
+	flush()
+	return tokens, nil
+}
+
+func parseTerm(tok token) (term, error) {
+	text := tok.text
+	fail := func(format string, args ...interface{}) (term, error) {
+		return nil, &ParseError{Term: tok.text, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	// Only a '-' before any quote negates; -"" is an empty quoted term
+	if strings.HasPrefix(text, "-") && tok.quoteStart > 0 && (len(text) > 1 || tok.quoted) {
+		inner, err := parseTerm(token{text: text[1:], pos: tok.pos + 1, quoted: tok.quoted, quoteStart: tok.quoteStart - 1})
+		if err != nil {
+			return nil, err
+		}
+		return notTerm{inner}, nil
+	}
+
+	if tok.quoted {
+		if text == "" {
+			return fail("empty quoted term")
+		}
+		return nameTerm{strings.ToLower(text)}, nil
+	}
+
+	key, value, hasKey := strings.Cut(text, ":")
+	if hasKey {
+		switch strings.ToLower(key) {
+		case "re":
+			re, err := regexp.Compile("(?i)" + value)
+			if err != nil {
+				return fail("invalid regular expression: %v", err)
+			}
+			return regexTerm{re}, nil
+		case "ext":
+			var exts []string
+			for _, e := range strings.Split(value, ",") {
+				if e = strings.TrimPrefix(strings.ToLower(e), "."); e != "" {
+					exts = append(exts, e)
+				}
+			}
+			if len(exts) == 0 {
+				return fail("missing extension")
+			}
+			return extTerm{exts}, nil
+		case "size":
+			op, num := splitOp(value)
+			size, err := parseSize(num)
+			if err != nil {
+				return fail("%v", err)
+			}
+			return sizeTerm{op: op, size: size}, nil
+		case "modified", "mtime":
+			op, date := splitOp(value)
+			from, to, err := parseTime(date)
+			if err != nil {
+				return fail("%v", err)
+			}
+			// "<=day" includes the whole day, ">day" excludes it
+			switch op {
+			case "<=":
+				op, from = "<", to
+			case ">":
+				op, from = ">=", to
+			}
+			return timeTerm{op: op, from: from, to: to}, nil
+		case "path":
+			if value == "" {
+				return fail("missing path")
+			}
+			return pathTerm{strings.ToLower(strings.ReplaceAll(value, "\\", "/"))}, nil
+		case "type":
+			switch strings.ToLower(value) {
+			case "dir", "d", "directory", "folder":
+				return typeTerm{dir: true}, nil
+			case "file", "f":
+				return typeTerm{dir: false}, nil
+			}
+			return fail("type must be 'dir' or 'file'")
+		default:
+			return fail("unknown filter %q (expected re, ext, size, modified, path or type)", key)
+		}
+	}
+
+	lower := strings.ToLower(text)
+	if strings.ContainsAny(text, "*?[") {
+		if _, err := path.Match(lower, ""); err != nil {
+			return fail("invalid glob pattern")
+		}
+		return globTerm{lower}, nil
+	}
+	return nameTerm{lower}, nil
+}
+
+// splitOp splits a comparison operator off the front of value, defaulting to "="
+func splitOp(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"t", 1 << 40}, {"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}, {"b", 1},
+}
+
+// parseSize parses sizes such as 512, 10KB, 1.5G (binary units)
+func parseSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	mult := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mult = strings.TrimSuffix(lower, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	// NaN fails every comparison, so check for a valid range instead of n < 0
+	if err != nil || !(n >= 0 && n*mult < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q (examples: 512, 10KB, 1.5GB)", s)
+	}
+	return int64(n * mult), nil
+}
+
+// parseTime parses a date (covering the whole local day) or an RFC 3339
+// timestamp (covering one second) and returns the interval [from, to)
+func parseTime(s string) (time.Time, time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, t.Add(time.Second), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q (expected YYYY-MM-DD or RFC 3339)", s)
+}
@@ -0,0 +1,156 @@
+package search
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"   ",
+		`"unterminated`,
+		`""`,
+		`-""`,
+		"re:(",
+		"ext:",
+		"ext:,.",
+		"size:",
+		"size:abc",
+		"size:-1",
+		"size:>-5KB",
+		"size:NaN",
+		"size:nanb",
+		"size:Inf",
+		"size:+infKB",
+		"size:1e30",
+		"size:9000000TB",
+		"modified:yesterday",
+		"modified:<2025-13-01",
+		"path:",
+		"type:link",
+		"owner:me",
+		"[a-",
+	} {
+		_, err := ParseQuery(raw)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("ParseQuery(%q) err = %v, want a *ParseError", raw, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512b", 512},
+		{"10KB", 10 << 10},
+		{"10k", 10 << 10},
+		{"1.5G", 3 << 29},
+		{" 2mb ", 2 << 20},
+		{"1TB", 1 << 40},
+	} {
+		if got, err := parseSize(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	type file struct {
+		path    string
+		isDir   bool
+		size    int64
+		modTime time.Time
+	}
+	report := file{"docs/Report-2025.PDF", false, 2 << 20, day}
+	draft := file{"docs/-draft notes.txt", false, 100, day.AddDate(0, 0, -1)}
+	photos := file{"photos", true, 4096, day.AddDate(0, 0, 1)}
+
+	for _, tc := range []struct {
+		query string
+		file  file
+		want  bool
+	}{
+		// Names, globs and regular expressions on the base name
+		{"report", report, true},
+		{"REPORT", report, true},
+		{"docs", report, false},
+		{"report notes", report, false},
+		{"*.pdf", report, true},
+		{"report-202?.pdf", report, true},
+		{"[a-r]*", report, true},
+		{"re:^report-\\d+\\.pdf$", report, true},
+		{"re:^\\d", report, false},
+		{"re:\"notes\\.txt$\"", draft, false}, // Quoted, so a plain substring
+
+		// Extensions
+		{"ext:pdf", report, true},
+		{"ext:.PDF", report, true},
+		{"ext:jpg,pdf", report, true},
+		{"ext:txt", report, false},
+		{"ext:pdf", photos, false},
+
+		// Sizes
+		{"size:>1MB", report, true},
+		{"size:>=2MB", report, true},
+		{"size:>2MB", report, false},
+		{"size:<1.5K", draft, true},
+		{"size:<=100", draft, true},
+		{"size:100", draft, true},
+		{"size:=100b", draft, true},
+		{"size:99", draft, false},
+
+		// Modification times; a date covers the whole local day
+		{"modified:2025-03-10", report, true},
+		{"modified:2025-03-10", draft, false},
+		{"modified:<2025-03-10", draft, true},
+		{"modified:<2025-03-10", report, false},
+		{"modified:<=2025-03-10", report, true},
+		{"modified:>2025-03-10", report, false},
+		{"modified:>2025-03-10", photos, true},
+		{"modified:>=2025-03-10", report, true},
+		{"mtime:" + day.Format(time.RFC3339), report, true},
+		{"mtime:>" + day.Format(time.RFC3339), report, false},
+
+		// Paths and types
+		{"path:docs/", report, true},
+		{"path:DOCS\\report", report, true},
+		{"path:photos", report, false},
+		{"type:dir", photos, true},
+		{"type:f", photos, false},
+		{"type:file report", report, true},
+
+		// Quoting and negation
+		{`"draft notes"`, draft, true},
+		{`"notes draft"`, draft, false},
+		{`"-draft"`, draft, true},
+		{`"-draft"`, report, false},
+		{`-draft`, draft, false},
+		{`-draft`, report, true},
+		{`-"draft notes"`, draft, false},
+		{`-"draft notes"`, report, true},
+		{`--draft`, draft, true}, // Double negation
+		{`-`, draft, true},       // A lone '-' is a literal
+		{`-ext:pdf`, report, false},
+		{`-type:dir`, report, true},
+		{`"size:>1MB"`, report, false}, // Quoted filters are literals
+		{`doc"s/rep"`, report, false},  // Quotes may appear mid-token
+		{`"report-2025" ext:pdf -size:<1KB`, report, true},
+	} {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.query, err)
+			continue
+		}
+		if got := q.Match(tc.file.path, tc.file.isDir, tc.file.size, tc.file.modTime); got != tc.want {
+			t.Errorf("%q matching %s = %v, want %v", tc.query, tc.file.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package search
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Sort keys accepted by SortResults
+const (
+	SortRelevance = "relevance"
+	SortName      = "name"
+	SortSize      = "size"
+	SortModTime   = "mtime"
+)
+
+// ErrInvalidCursor is returned for cursors that cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ParseSort validates a sort key, defaulting to relevance
+func ParseSort(key string) (string, error) {
+	switch key {
+	case "":
+		return SortRelevance, nil
+	case SortRelevance, SortName, SortSize, SortModTime:
+		return key, nil
+	}
+	return "", fmt.Errorf("invalid sort %q (expected relevance, name, size or mtime)", key)
+}
+
+// SortResults sorts results by key. Relevance is highest first and the other
+// keys ascending; desc reverses the order. Ties are broken by path so the
+// order is total and stable across requests.
+func SortResults(results []Result, q *Query, key string, desc bool) {
+	if key == SortRelevance {
+		for i := range results {
+			results[i].Score = q.relevance(results[i])
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return compareResults(&results[i], &results[j], key, desc) < 0
+	})
+}
+
+// Paginate returns the page of sorted results following cursor (or the first
+// page for an empty cursor), and the cursor for the next page, which is
+// empty on the last page
+func Paginate(results []Result, key string, desc bool, cursor string, limit int) ([]Result, string, error) {
+	start := 0
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// Resume after the last result seen, even if results changed since
+		start = sort.Search(len(results), func(i int) bool {
+			return compareResults(&results[i], after, key, desc) > 0
+		})
+	}
+
+	end := start + limit
+	if limit <= 0 || end > len(results) {
+		end = len(results)
+	}
+	page := results[start:end]
+
+	next := ""
+	if end < len(results) && len(page) > 0 {
+		next = encodeCursor(&page[len(page)-1])
+	}
+	return page, next, nil
+}
+
+// compareResults orders a and b by key, returning -1, 0 or 1
+func compareResults(a, b *Result, key string, desc bool) int {
+	c := 0
+	switch key {
+	case SortRelevance:
+		// Higher scores first
+		c = -cmp.Compare(a.Score, b.Score)
+	case SortName:
+		c = strings.Compare(strings.ToLower(path.Base(slash(a.Path))), strings.ToLower(path.Base(slash(b.Path))))
+	case SortSize:
+		c = cmp.Compare(a.Size, b.Size)
+	case SortModTime:
+		// ModTime is formatted as "2006-01-02 15:04:05", which sorts lexically
+		c = strings.Compare(a.ModTime, b.ModTime)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Path, b.Path)
+	}
+	if desc {
+		c = -c
+	}
+	return c
+}
+
+func slash(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
+}
+
+// cursorData is the position encoded in a cursor: the sort fields of the
+// last result on the previous page
+type cursorData struct {
+	Path    string  `json:"p"`
+	Size    int64   `json:"s,omitempty"`
+	ModTime string  `json:"m,omitempty"`
+	Score   float64 `json:"r,omitempty"`
+}
+
+func encodeCursor(r *Result) string {
+	data, _ := json.Marshal(cursorData{Path: r.Path, Size: r.Size, ModTime: r.ModTime, Score: r.Score})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*Result, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursorData
+	if err := json.Unmarshal(data, &c); err != nil || c.Path == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &Result{Path: c.Path, Size: c.Size, ModTime: c.ModTime, Score: c.Score}, nil
+}
+
+// relevance scores how well a result's name matches the literal terms of the
+// query. Exact and prefix matches rank highest; shallow, short paths break ties.
+func (q *Query) relevance(r Result) float64 {
+	p := strings.ToLower(slash(r.Path))
+	name := path.Base(p)
+
+	score := 0.0
+	for _, t := range q.terms {
+		switch t := t.(type) {
+		case nameTerm:
+			switch {
+			case name == t.substr:
+				score += 100
+			case strings.TrimSuffix(name, path.Ext(name)) == t.substr:
+				score += 80
+			case strings.HasPrefix(name, t.substr):
+				score += 50
+			case strings.Contains(name, t.substr):
+				score += 10
+			}
+		case globTerm, regexTerm, extTerm:
+			score += 5
+		}
+	}
+	score -= float64(strings.Count(p, "/"))
+	score -= float64(len(name)) / 100
+	return score
+}
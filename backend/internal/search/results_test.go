@@ -0,0 +1,132 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func testResults() []Result {
+	var results []Result
+	for i := 0; i < 23; i++ {
+		results = append(results, Result{
+			// Repeated names, sizes and times exercise the tie-break on path
+			Path:    fmt.Sprintf("dir%d/report%d.txt", i, i%5),
+			Size:    int64(i % 3 * 100),
+			ModTime: fmt.Sprintf("2025-01-%02d 10:00:00", 1+i%6),
+		})
+	}
+	return results
+}
+
+func resultPaths(results []Result) []string {
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+	return paths
+}
+
+func TestPaginate(t *testing.T) {
+	q, err := ParseQuery("report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{SortRelevance, SortName, SortSize, SortModTime} {
+		for _, desc := range []bool{false, true} {
+			all := testResults()
+			SortResults(all, q, key, desc)
+
+			// Every result appears exactly once, in sorted order
+			var got []Result
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(all) {
+					t.Fatalf("%s desc=%v: pagination does not end", key, desc)
+				}
+				page, next, err := Paginate(all, key, desc, cursor, 5)
+				if err != nil {
+					t.Fatalf("%s desc=%v: %v", key, desc, err)
+				}
+				got = append(got, page...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !slices.Equal(resultPaths(got), resultPaths(all)) {
+				t.Errorf("%s desc=%v: pages %v, want %v", key, desc, resultPaths(got), resultPaths(all))
+			}
+		}
+	}
+}
+
+// TestPaginateStable checks that a cursor resumes after the last result seen
+// when results are added or removed between pages
+func TestPaginateStable(t *testing.T) {
+	q, _ := ParseQuery("report")
+	for _, key := range []string{SortRelevance, SortName, SortSize, SortModTime} {
+		for _, desc := range []bool{false, true} {
+			all := testResults()
+			SortResults(all, q, key, desc)
+			first, cursor, err := Paginate(all, key, desc, "", 10)
+			if err != nil || cursor == "" {
+				t.Fatalf("%s desc=%v: cursor %q, %v", key, desc, cursor, err)
+			}
+			last := first[len(first)-1]
+
+			// Drop the last result seen and add new results on both sides
+			changed := slices.DeleteFunc(slices.Clone(all), func(r Result) bool { return r.Path == last.Path })
+			changed = append(changed,
+				Result{Path: "a/report0.txt", Size: 0, ModTime: "2024-12-31 00:00:00"},
+				Result{Path: "z/report9.txt", Size: 900, ModTime: "2025-02-01 00:00:00"},
+			)
+			SortResults(changed, q, key, desc)
+
+			rest, _, err := Paginate(changed, key, desc, cursor, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []Result
+			for _, r := range changed {
+				if compareResults(&r, &last, key, desc) > 0 {
+					want = append(want, r)
+				}
+			}
+			if !slices.Equal(resultPaths(rest), resultPaths(want)) {
+				t.Errorf("%s desc=%v: rest %v, want %v", key, desc, resultPaths(rest), resultPaths(want))
+			}
+			for _, r := range rest {
+				if slices.ContainsFunc(first, func(f Result) bool { return f.Path == r.Path }) {
+					t.Errorf("%s desc=%v: %s repeated on the next page", key, desc, r.Path)
+				}
+			}
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	r := Result{Path: `dir\sub/file.txt`, Size: 1234, ModTime: "2025-01-02 03:04:05", Score: 42.125}
+	got, err := decodeCursor(encodeCursor(&r))
+	if err != nil || *got != r {
+		t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v, %v", r, got, err)
+	}
+
+	for _, cursor := range []string{"!!!", "bm90IGpzb24", "e30", "eyJwIjoiIn0"} {
+		if _, _, err := Paginate(testResults(), SortName, false, cursor, 5); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: err = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	for key, want := range map[string]string{"": SortRelevance, "name": SortName, "size": SortSize, "mtime": SortModTime} {
+		if got, err := ParseSort(key); err != nil || got != want {
+			t.Errorf("ParseSort(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if _, err := ParseSort("date"); err == nil {
+		t.Error("ParseSort(\"date\") succeeded")
+	}
+}
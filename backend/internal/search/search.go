@@ -1,73 +1,231 @@
 package search
 
 import (
+	"context"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"sync"
 )
 
+// DefaultIgnore lists directory names that are pruned from searches by default
+var DefaultIgnore = []string{".git", ".svn", ".hg", "node_modules"}
+
+// DefaultWorkers is the default number of directories read concurrently
+const DefaultWorkers = 8
+
 // Result represents a search result
 type Result struct {
-	Path    string `json:"path"`
-	IsDir   bool   `json:"is_dir"`
-	Size    int64  `json:"size"`
-	ModTime string `json:"mod_time"`
+	Path    string  `json:"path"`
+	IsDir   bool    `json:"is_dir"`
+	Size    int64   `json:"size"`
+	ModTime string  `json:"mod_time"`
+	Score   float64 `json:"score,omitempty"` // Set when sorting by relevance
+}
+
+// Options controls which parts of the tree a search visits
+type Options struct {
+	Scope      string // Subtree relative to the root, "" for everything
+	MaxResults int    // Maximum number of results, default 100
+	Workers    int    // Directories (or, for content search, files) read concurrently
+	// Ignore holds glob patterns for directories that are never entered.
+	// Patterns without a slash match the base name, others the relative path.
+	Ignore []string
+	// SkipHidden prunes directories whose name starts with a dot
+	SkipHidden bool
+	// SkipDir, if set, prunes directories for which it returns true, so that
+	// denied subtrees are never read. relPath is relative to the root.
+	SkipDir func(relPath string) bool
+	// Allow, if set, filters individual results. relPath is relative to the root.
+	Allow func(relPath string) bool
+	// AllowSymlink, if set, filters symlinks that may not be followed
+	AllowSymlink func(relPath string) bool
 }
 
-// Search performs a file search in the given root directory
-func Search(rootDir, query string, maxResults int) ([]Result, error) {
-	if maxResults <= 0 {
-		maxResults = 100 // Default limit
+// Prune reports whether the directory relPath is excluded by the options
+func (o *Options) Prune(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	name := path.Base(relPath)
+	if o.SkipHidden && strings.HasPrefix(name, ".") && name != "." {
+		return true
+	}
+	for _, pattern := range o.Ignore {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = relPath
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return o.SkipDir != nil && o.SkipDir(relPath)
+}
+
+// Search walks the tree below rootDir and returns the results matching q.
+// Results are in depth-first, lexical order, so a given tree and query always
+// yield the same results. If ctx is cancelled the results found so far are
+// returned together with ctx.Err().
+func Search(ctx context.Context, rootDir string, q *Query, opts Options) ([]Result, error) {
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = 100 // Default limit
 	}
 
 	var results []Result
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+	err := SearchFunc(ctx, rootDir, q, opts, func(result Result) bool {
+		results = append(results, result)
+		return len(results) < opts.MaxResults
+	})
+	return results, err
+}
 
-	query = strings.ToLower(query)
+// SearchFunc is like Search but calls fn for each result as soon as it is
+// found, until fn returns false. opts.MaxResults is ignored.
+func SearchFunc(ctx context.Context, rootDir string, q *Query, opts Options, fn func(Result) bool) error {
+	w := newWalker(ctx, rootDir, opts)
+	return w.walk(func(rel string, d fs.DirEntry) bool {
+		if opts.Allow != nil && !opts.Allow(rel) {
+			return true
+		}
+		if d.Type()&fs.ModeSymlink != 0 && opts.AllowSymlink != nil && !opts.AllowSymlink(rel) {
+			return true
+		}
 
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip errors, continue searching
+		c := &candidate{
+			path:  strings.ToLower(filepath.ToSlash(rel)),
+			name:  strings.ToLower(d.Name()),
+			isDir: d.IsDir(),
+		}
+		var info fs.FileInfo
+		if q.needsInfo() {
+			var err error
+			if info, err = d.Info(); err != nil {
+				return true
+			}
+			c.size, c.modTime = info.Size(), info.ModTime()
+		}
+		if !q.match(c) {
+			return true
 		}
 
-		mu.Lock()
-		if len(results) >= maxResults {
-			mu.Unlock()
-			return filepath.SkipDir // Stop searching if limit reached
+		if info == nil {
+			var err error
+			if info, err = d.Info(); err != nil {
+				return true
+			}
 		}
-		mu.Unlock()
-
-		// Check if filename matches query
-		filename := strings.ToLower(info.Name())
-		if strings.Contains(filename, query) {
-			relPath, err := filepath.Rel(rootDir, path)
-			if err != nil {
-				relPath = path
+		return fn(newResult(rel, info))
+	})
+}
+
+// Walk calls fn for every entry below opts.Scope that is not pruned by opts,
+// in the same order as Search, until fn returns false. opts.Allow and
+// opts.MaxResults are not applied.
+func Walk(ctx context.Context, rootDir string, opts Options, fn func(relPath string, d fs.DirEntry) bool) error {
+	return newWalker(ctx, rootDir, opts).walk(fn)
+}
+
+// walker traverses a tree depth-first in lexical order. Subdirectories are
+// read ahead by a bounded pool of goroutines, so slow filesystems are read in
+// parallel while results stay deterministic.
+type walker struct {
+	ctx  context.Context
+	root string
+	opts Options
+	sem  chan struct{}
+}
+
+type listing struct {
+	entries []fs.DirEntry
+	err     error
+}
+
+func newWalker(ctx context.Context, rootDir string, opts Options) *walker {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return &walker{
+		ctx:  ctx,
+		root: rootDir,
+		opts: opts,
+		sem:  make(chan struct{}, workers),
+	}
+}
+
+// walk calls visit for every entry below the scope, excluding pruned
+// directories and their contents, until visit returns false
+func (w *walker) walk(visit func(rel string, d fs.DirEntry) bool) error {
+	scope := filepath.Clean(filepath.Join(".", w.opts.Scope))
+	_, err := w.visitDir(scope, nil, visit)
+	return err
+}
+
+// visitDir walks the directory rel, using the read-ahead listing if one was
+// started. It returns false once the walk should stop.
+func (w *walker) visitDir(rel string, pending <-chan listing, visit func(string, fs.DirEntry) bool) (bool, error) {
+	var l listing
+	if pending != nil {
+		l = <-pending
+	} else {
+		l.entries, l.err = os.ReadDir(filepath.Join(w.root, rel))
+	}
+	if l.err != nil {
+		return true, nil // Skip unreadable directories, continue searching
+	}
+
+	// Start reading the subdirectories that will be visited
+	children := make([]<-chan listing, len(l.entries))
+	pruned := make([]bool, len(l.entries))
+	for i, entry := range l.entries {
+		if entry.IsDir() {
+			childRel := filepath.Join(rel, entry.Name())
+			if pruned[i] = w.opts.Prune(childRel); !pruned[i] {
+				children[i] = w.readAhead(childRel)
 			}
+		}
+	}
+
+	for i, entry := range l.entries {
+		if err := w.ctx.Err(); err != nil {
+			return false, err
+		}
 
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				result := Result{
-					Path:    relPath,
-					IsDir:   info.IsDir(),
-					Size:    info.Size(),
-					ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
-				}
-
-				mu.Lock()
-				if len(results) < maxResults {
-					results = append(results, result)
-				}
-				mu.Unlock()
-			}()
+		if pruned[i] {
+			continue
+		}
+		childRel := filepath.Join(rel, entry.Name())
+		if !visit(childRel, entry) {
+			return false, nil
+		}
+		if entry.IsDir() {
+			more, err := w.visitDir(childRel, children[i], visit)
+			if !more {
+				return false, err
+			}
 		}
+	}
+	return true, nil
+}
 
+// readAhead starts reading a directory in the background if a worker is
+// free. It returns nil if the directory should be read synchronously.
+func (w *walker) readAhead(rel string) <-chan listing {
+	select {
+	case w.sem <- struct{}{}:
+	default:
 		return nil
-	})
+	}
 
-	wg.Wait()
-	return results, err
+	ch := make(chan listing, 1)
+	go func() {
+		defer func() { <-w.sem }()
+		if w.ctx.Err() != nil {
+			ch <- listing{err: w.ctx.Err()}
+			return
+		}
+		entries, err := os.ReadDir(filepath.Join(w.root, rel))
+		ch <- listing{entries: entries, err: err}
+	}()
+	return ch
 }
@@ -0,0 +1,142 @@
+//go:build linux
+
+package search
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_ONLYDIR
+
+// inotifyWatcher is a dirWatcher backed by Linux inotify
+type inotifyWatcher struct {
+	root string
+	file *os.File
+	fd   int
+	done chan struct{}
+
+	mu   sync.Mutex
+	dirs map[int32]string // Watch descriptor to relative directory
+}
+
+func newDirWatcher(root string, events chan<- fsEvent) (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	// A non-blocking fd is registered with the runtime poller, so Close
+	// unblocks the reader goroutine
+	w := &inotifyWatcher{
+		root: root,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		done: make(chan struct{}),
+		dirs: make(map[int32]string),
+	}
+	go w.readEvents(events)
+	return w, nil
+}
+
+// Add watches the directory rel (relative to the root)
+func (w *inotifyWatcher) Add(rel string) error {
+	full := filepath.Join(w.root, rel)
+	wd, err := syscall.InotifyAddWatch(w.fd, full, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	w.mu.Lock()
+	w.dirs[int32(wd)] = filepath.ToSlash(rel)
+	w.mu.Unlock()
+	return nil
+}
+
+// forget stops watching the directory rel and everything below it. Watches
+// follow the inode, so a directory moved away would otherwise keep reporting
+// events under its old path; a directory moved within the root is watched
+// again under its new path when the index walks it.
+func (w *inotifyWatcher) forget(rel string) {
+	prefix := rel + "/"
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, dir := range w.dirs {
+		if dir == rel || strings.HasPrefix(dir, prefix) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// Close stops watching and releases the inotify instance
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+// send delivers ev unless the watcher has been closed
+func (w *inotifyWatcher) send(events chan<- fsEvent, ev fsEvent) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *inotifyWatcher) readEvents(events chan<- fsEvent) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if !w.send(events, fsEvent{Overflow: true}) {
+					return
+				}
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, raw.Wd)
+			}
+			w.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+
+			op := opCreate
+			if raw.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+				op = opRemove
+			}
+			if raw.Mask&(syscall.IN_MOVED_FROM|syscall.IN_ISDIR) == syscall.IN_MOVED_FROM|syscall.IN_ISDIR {
+				w.forget(path.Join(dir, name))
+			}
+			if !w.send(events, fsEvent{Dir: dir, Name: name, Op: op}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package search
+
+import "errors"
+
+func newDirWatcher(root string, events chan<- fsEvent) (dirWatcher, error) {
+	return nil, errors.New("filesystem watching is only supported on Linux")
+}
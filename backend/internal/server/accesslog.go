@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"gohttpserver/internal/accesslog"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For, X-Real-IP
+// and X-Request-ID headers are believed
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses IP addresses and CIDR ranges
+func parseTrustedProxies(entries []string) (trustedProxies, error) {
+	var tp trustedProxies
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			tp = append(tp, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q (expected an IP address or CIDR range)", entry)
+		}
+		addr = addr.Unmap()
+		tp = append(tp, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return tp, nil
+}
+
+func (tp trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromProxy reports whether r came directly from a trusted proxy
+func (tp trustedProxies) fromProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	return err == nil && tp.contains(addr.Addr())
+}
+
+// clientIP returns the address of the client. Behind trusted proxies it is
+// the last X-Forwarded-For address not belonging to one of them, so clients
+// cannot spoof it by sending their own header.
+func (tp trustedProxies) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !tp.fromProxy(r) {
+		return host
+	}
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		addrs := strings.Split(strings.Join(hops, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+			if err != nil {
+				break
+			}
+			host = addr.Unmap().String()
+			if !tp.contains(addr) {
+				break
+			}
+		}
+		return host
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// requestID returns the X-Request-ID a trusted proxy assigned, or a new one
+func (tp trustedProxies) requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); tp.fromProxy(r) && validRequestID(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID keeps proxy-assigned IDs short and free of characters that
+// would need escaping in logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:+/=", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// accessRecord collects what the access log needs while the request is
+// served. Handlers deeper in the chain fill in the user.
+type accessRecord struct {
+	user string
+}
+
+type accessRecordKey struct{}
+
+// setAccessUser records the user of the request for the access log
+func setAccessUser(r *http.Request, user string) {
+	if rec, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+		rec.user = user
+	}
+}
+
+// statusRecorder records the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(p)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile fast path of the underlying writer for file
+// downloads
+func (sr *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := io.Copy(sr.ResponseWriter, src)
+	sr.bytes += n
+	return n, err
+}
+
+// Flush supports streamed responses
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	http.NewResponseController(sr.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// logRequest serves r and writes it to the access log
+func logRequest(logger *accesslog.Logger, proxies trustedProxies, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	id := proxies.requestID(r)
+	w.Header().Set("X-Request-ID", id)
+
+	rec := &accessRecord{}
+	sr := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, rec)))
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+
+	logger.Log(&accesslog.Entry{
+		Time:      start,
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Path:      r.URL.Path,
+		Proto:     r.Proto,
+		Status:    sr.status,
+		Bytes:     sr.bytes,
+		Duration:  time.Since(start),
+		ClientIP:  proxies.clientIP(r),
+		User:      rec.user,
+		RequestID: id,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	})
+}
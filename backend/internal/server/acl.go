@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Rule sources shown in traces
+const (
+	aclSourceAllowDefault = "--allow-paths (default deny)"
+	aclSourceAllow        = "--allow-paths"
+	aclSourceDeny         = "--deny-paths"
+)
+
+// PathACL manages path-level access control with gitignore-style rules.
+//
+// Rules are evaluated in order and the last matching rule decides; paths
+// no rule matches are allowed. A rule matching a directory also covers
+// everything below it. The rules come from, in order:
+//
+//   - --allow-paths: if set, everything is denied first and each entry is
+//     an allow rule
+//   - the rules file: one pattern per line, denied unless prefixed with "!"
+//   - --deny-paths: deny rules, so they always win
+//
+// Patterns follow .gitignore: "*" and "?" do not match "/", "**" matches any
+// number of directories, "[a-z]" is a character class and "\" escapes. A
+// pattern with a leading or inner "/" is anchored to the root, otherwise it
+// matches a name at any depth. Entries of --allow-paths and --deny-paths are
+// always anchored, as they were before rule files existed; "**/name" matches
+// at any depth there.
+type PathACL struct {
+	rules []*aclRule
+}
+
+// aclRule is a single compiled rule
+type aclRule struct {
+	pattern  string   // As written, for traces
+	source   string   // Where the rule came from, for traces
+	allow    bool     // Whether a match allows the path
+	segments []string // Split on "/". "**" matches any number of segments.
+}
+
+// ACLTrace explains how the rules decided on a path
+type ACLTrace struct {
+	Path    string         `json:"path"`
+	Allowed bool           `json:"allowed"`
+	Rule    *ACLRuleTrace  `json:"rule,omitempty"` // The deciding rule, nil if none matched
+	Rules   []ACLRuleTrace `json:"rules"`
+}
+
+// ACLRuleTrace is the outcome of a single rule in an ACLTrace
+type ACLRuleTrace struct {
+	Index     int    `json:"index"`
+	Source    string `json:"source"`
+	Pattern   string `json:"pattern"`
+	Effect    string `json:"effect"` // allow or deny
+	Matched   bool   `json:"matched"`
+	MatchedAt string `json:"matched_at,omitempty"` // The path, or the ancestor the rule matched
+}
+
+// NewPathACL creates a new PathACL instance. ruleFile, if set, is a file of
+// gitignore-style rules. Invalid patterns are reported as errors.
+func NewPathACL(allowPaths, denyPaths []string, ruleFile string) (*PathACL, error) {
+	acl := &PathACL{}
+
+	if len(allowPaths) > 0 {
+		acl.rules = append(acl.rules, &aclRule{pattern: "/", source: aclSourceAllowDefault})
+	}
+	for _, p := range allowPaths {
+		if err := acl.addRule(p, true, true, aclSourceAllow); err != nil {
+			return nil, err
+		}
+	}
+
+	if ruleFile != "" {
+		if err := acl.loadRules(ruleFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range denyPaths {
+		if err := acl.addRule(p, false, true, aclSourceDeny); err != nil {
+			return nil, err
+		}
+	}
+	return acl, nil
+}
+
+// loadRules appends the rules of a gitignore-style file. Blank lines and
+// lines starting with "#" are ignored.
+func (acl *PathACL) loadRules(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open ACL file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := acl.addRule(text, false, false, fmt.Sprintf("%s:%d", filepath.Base(name), line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// addRule compiles a pattern. A leading "!" inverts allow. With anchor, the
+// pattern is anchored to the root even without a "/".
+func (acl *PathACL) addRule(pattern string, allow, anchor bool, source string) error {
+	written := pattern
+	switch {
+	case strings.HasPrefix(pattern, "!"):
+		allow = !allow
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, `\!`), strings.HasPrefix(pattern, `\#`):
+		pattern = pattern[1:]
+	}
+
+	// Rules cover directories and their contents alike, so a trailing
+	// slash makes no difference
+	pattern = filepath.ToSlash(pattern)
+	for len(pattern) > 1 && strings.HasSuffix(pattern, "/") {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if pattern == "" {
+		return fmt.Errorf("%s: empty ACL pattern %q", source, written)
+	}
+
+	anchored := anchor || strings.Contains(pattern, "/")
+	var segments []string
+	if !anchored {
+		segments = append(segments, "**")
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+		switch {
+		case seg == "" || seg == ".":
+			continue
+		case seg == "**":
+			if len(segments) > 0 && segments[len(segments)-1] == "**" {
+				continue
+			}
+		default:
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("%s: invalid ACL pattern %q: %w", source, written, err)
+			}
+		}
+		segments = append(segments, seg)
+	}
+
+	acl.rules = append(acl.rules, &aclRule{
+		pattern:  written,
+		source:   source,
+		allow:    allow,
+		segments: segments,
+	})
+	return nil
+}
+
+// IsAllowed checks if a path is allowed based on ACL rules
+func (acl *PathACL) IsAllowed(path string) bool {
+	allowed, _ := acl.evaluate(splitACLPath(path))
+	return allowed
+}
+
+// IsDenied reports whether path is denied along with everything below it,
+// that is, no later rule could allow anything inside it. Such a directory
+// can be skipped entirely when walking the tree.
+func (acl *PathACL) IsDenied(path string) bool {
+	segs := splitACLPath(path)
+	allowed, decisive := acl.evaluate(segs)
+	if allowed {
+		return false
+	}
+	for _, rule := range acl.rules[decisive+1:] {
+		if rule.allow && rule.matchesBelow(segs) {
+			return false
+		}
+	}
+	return true
+}
+
+// Explain evaluates path like IsAllowed and reports the outcome of every rule
+func (acl *PathACL) Explain(path string) *ACLTrace {
+	segs := splitACLPath(path)
+	trace := &ACLTrace{
+		Path:    "/" + strings.Join(segs, "/"),
+		Allowed: true,
+		Rules:   make([]ACLRuleTrace, 0, len(acl.rules)),
+	}
+	decisive := -1
+	for i, rule := range acl.rules {
+		rt := ACLRuleTrace{
+			Index:   i,
+			Source:  rule.source,
+			Pattern: rule.pattern,
+			Effect:  "deny",
+		}
+		if rule.allow {
+			rt.Effect = "allow"
+		}
+		if n := rule.match(segs); n >= 0 {
+			rt.Matched = true
+			rt.MatchedAt = "/" + strings.Join(segs[:n], "/")
+			trace.Allowed, decisive = rule.allow, i
+		}
+		trace.Rules = append(trace.Rules, rt)
+	}
+	if decisive >= 0 {
+		trace.Rule = &trace.Rules[decisive]
+	}
+	return trace
+}
+
+// evaluate returns the decision for a path and the index of the deciding
+// rule, -1 if no rule matched
+func (acl *PathACL) evaluate(segs []string) (bool, int) {
+	allowed, decisive := true, -1
+	for i, rule := range acl.rules {
+		if rule.match(segs) >= 0 {
+			allowed, decisive = rule.allow, i
+		}
+	}
+	return allowed, decisive
+}
+
+// match returns the number of leading segments of the shortest prefix of
+// segs (the path or one of its ancestors) the rule matches, or -1
+func (r *aclRule) match(segs []string) int {
+	for n := 0; n <= len(segs); n++ {
+		if matchSegments(r.segments, segs[:n]) {
+			return n
+		}
+	}
+	return -1
+}
+
+// matchesBelow reports whether the rule could match something inside the
+// directory segs
+func (r *aclRule) matchesBelow(segs []string) bool {
+	for i, pat := range r.segments {
+		if pat == "**" {
+			return true
+		}
+		if i == len(segs) {
+			return true // The rest of the pattern applies below the directory
+		}
+		if ok, _ := path.Match(pat, segs[i]); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments. A trailing
+// "**" matches one or more segments, elsewhere it matches zero or more.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(segs) > 0
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// splitACLPath normalizes a path and splits it into segments. The root has
+// none.
+func splitACLPath(p string) []string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+// HandleACLTrace explains the ACL decision for a path
+// GET /api/admin/acl?path=/some/path
+func (s *Server) HandleACLTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		p = "/"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.pathACL.Explain(p))
+}
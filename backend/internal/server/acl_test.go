@@ -0,0 +1,302 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gohttpserver/internal/tokens"
+)
+
+func TestMatchSegments(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a", false},
+		{"a/b", "a/b/c", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a", false},
+		{"*.key", "x.key", true},
+		{"*.key", "dir/x.key", false}, // "*" does not cross "/"
+		{"a?c", "abc", true},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"**/x", "x", true}, // Leading "**" matches zero directories
+		{"**/x", "a/b/x", true},
+		{"**/x", "a/xy", false},
+		{"a/**/z", "a/z", true},
+		{"a/**/z", "a/b/c/z", true},
+		{"a/**/z", "b/z", false},
+		{"a/**", "a/b", true},
+		{"a/**", "a/b/c", true},
+		{"a/**", "a", false}, // Trailing "**" needs at least one segment
+		{"**", "", false},
+	} {
+		pattern := strings.Split(tc.pattern, "/")
+		var segs []string
+		if tc.path != "" {
+			segs = strings.Split(tc.path, "/")
+		}
+		if got := matchSegments(pattern, segs); got != tc.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestMatchesBelow(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, dir string
+		want         bool
+	}{
+		{"/docs/a.pdf", "docs", true},
+		{"/docs/a.pdf", "", true},
+		{"/docs/a.pdf", "other", false},
+		{"/docs/a.pdf", "docs/a.pdf", false}, // The rule matches the path itself, nothing below
+		{"/d*/x", "docs", true},
+		{"/docs/**/*.pdf", "docs/deep/er", true},
+		{"*.pdf", "anything", true}, // Unanchored, so at any depth
+		{"/a", "b", false},
+	} {
+		acl := &PathACL{}
+		if err := acl.addRule(tc.pattern, true, false, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if got := acl.rules[0].matchesBelow(splitACLPath(tc.dir)); got != tc.want {
+			t.Errorf("%q matchesBelow(%q) = %v, want %v", tc.pattern, tc.dir, got, tc.want)
+		}
+	}
+}
+
+// newTestACL builds an ACL, with rules written to a rules file if any
+func newTestACL(t *testing.T, allow, deny []string, rules ...string) *PathACL {
+	t.Helper()
+	ruleFile := ""
+	if len(rules) > 0 {
+		ruleFile = filepath.Join(t.TempDir(), "acl.rules")
+		if err := os.WriteFile(ruleFile, []byte(strings.Join(rules, "\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acl, err := NewPathACL(allow, deny, ruleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+func TestPathACL(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		allow   []string
+		deny    []string
+		rules   []string
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "no rules",
+			allowed: []string{"/", "/a", "/a/b.txt"},
+		},
+		{
+			name:    "deny covers everything below",
+			deny:    []string{"/private"},
+			allowed: []string{"/", "/public", "/private-plans", "/a/private"},
+			denied:  []string{"/private", "/private/x", "/private/a/b"},
+		},
+		{
+			name:    "--allow-paths denies everything else",
+			allow:   []string{"/public", "/shared"},
+			allowed: []string{"/public", "/public/a/b", "/shared/x"},
+			denied:  []string{"/", "/other", "/publicity"},
+		},
+		{
+			name:    "--deny-paths wins over --allow-paths",
+			allow:   []string{"/public"},
+			deny:    []string{"/public/secret"},
+			allowed: []string{"/public", "/public/x"},
+			denied:  []string{"/public/secret", "/public/secret/x"},
+		},
+		{
+			name:    "--allow-paths and --deny-paths are anchored",
+			deny:    []string{"uploads"},
+			allowed: []string{"/a/uploads"},
+			denied:  []string{"/uploads", "/uploads/x"},
+		},
+		{
+			name:    "** in --deny-paths matches at any depth",
+			deny:    []string{"**/uploads"},
+			denied:  []string{"/uploads", "/a/uploads", "/a/b/uploads/c"},
+			allowed: []string{"/a/uploads2"},
+		},
+		{
+			name:    "unanchored rules match at any depth",
+			rules:   []string{"*.key", "tmp"},
+			allowed: []string{"/a.keys", "/key"},
+			denied:  []string{"/a.key", "/x/y/a.key", "/tmp", "/a/tmp/b"},
+		},
+		{
+			name:    "rules with a slash are anchored",
+			rules:   []string{"/secret", "docs/internal"},
+			allowed: []string{"/a/secret", "/secret-plans-public", "/a/docs/internal"},
+			denied:  []string{"/secret", "/docs/internal/x"},
+		},
+		{
+			name:    "a trailing slash makes no difference",
+			rules:   []string{"build/", "/cache//"},
+			denied:  []string{"/build", "/a/build", "/a/build/x", "/cache"},
+			allowed: []string{"/builder"},
+		},
+		{
+			name:    "! re-includes below an excluded parent",
+			rules:   []string{"/docs", "!/docs/**/*.pdf"},
+			allowed: []string{"/docs/a.pdf", "/docs/x/y/b.pdf"},
+			denied:  []string{"/docs", "/docs/a.txt", "/docs/x"},
+		},
+		{
+			name:    "the last matching rule wins",
+			rules:   []string{"!/a", "/a"},
+			denied:  []string{"/a"},
+			allowed: []string{"/b"},
+		},
+		{
+			name:    "! in --allow-paths denies",
+			allow:   []string{"/", "!/tmp"},
+			denied:  []string{"/tmp/x"},
+			allowed: []string{"/other"},
+		},
+		{
+			name:    "escaped ! and # are literals",
+			rules:   []string{"# a comment", "", `\!important`, `\#hash`},
+			denied:  []string{"/!important", "/#hash"},
+			allowed: []string{"/important", "/a comment"},
+		},
+		{
+			name:    "paths are cleaned",
+			deny:    []string{"/private"},
+			denied:  []string{"private", "/a/../private/x", "//private/"},
+			allowed: []string{"/a/private/.."},
+		},
+	} {
+		acl := newTestACL(t, tc.allow, tc.deny, tc.rules...)
+		for _, p := range tc.allowed {
+			if !acl.IsAllowed(p) {
+				t.Errorf("%s: %s denied, want allowed", tc.name, p)
+			}
+		}
+		for _, p := range tc.denied {
+			if acl.IsAllowed(p) {
+				t.Errorf("%s: %s allowed, want denied", tc.name, p)
+			}
+		}
+	}
+}
+
+func TestPathACLInvalid(t *testing.T) {
+	for _, rule := range []string{"!", "/[a-", "a/[/b"} {
+		ruleFile := filepath.Join(t.TempDir(), "acl.rules")
+		os.WriteFile(ruleFile, []byte(rule), 0644)
+		if _, err := NewPathACL(nil, nil, ruleFile); err == nil {
+			t.Errorf("rule %q accepted", rule)
+		}
+	}
+	if _, err := NewPathACL(nil, nil, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing rules file accepted")
+	}
+}
+
+func TestPathACLIsDenied(t *testing.T) {
+	acl := newTestACL(t, []string{"/public"}, []string{"/public/secret"}, "/docs", "!/docs/**/*.pdf", "*.tmp")
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"/public", false},
+		{"/public/secret", true},
+		{"/public/secret/deeper", true},
+		{"/other", true},
+		{"/", false},     // /public is allowed below it
+		{"/docs", false}, // PDFs are allowed again below it
+		{"/docs/x", false},
+		{"/public/a.tmp", true}, // Nothing below a denied file is allowed again
+	} {
+		if got := acl.IsDenied(tc.path); got != tc.want {
+			t.Errorf("IsDenied(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+		if tc.want && acl.IsAllowed(tc.path) {
+			t.Errorf("%s is pruned but allowed", tc.path)
+		}
+	}
+}
+
+func TestPathACLExplain(t *testing.T) {
+	acl := newTestACL(t, nil, []string{"/docs"}, "!/docs/**/*.pdf")
+	trace := acl.Explain("docs/a/b.pdf")
+	if trace.Path != "/docs/a/b.pdf" || trace.Allowed {
+		t.Errorf("trace %+v: --deny-paths must win", trace)
+	}
+	if trace.Rule == nil || trace.Rule.Source != aclSourceDeny || trace.Rule.MatchedAt != "/docs" {
+		t.Errorf("deciding rule %+v", trace.Rule)
+	}
+	if len(trace.Rules) != 2 || !trace.Rules[0].Matched || trace.Rules[0].Effect != "allow" {
+		t.Errorf("rules %+v", trace.Rules)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		id   Identity
+		want bool
+	}{
+		{"--auth user", Identity{Account: accountBasic + "admin", Method: AuthMethodBasic}, true},
+		{"--auth user session", Identity{Account: accountBasic + "admin", Method: AuthMethodSession}, true},
+		{"all scopes", Identity{Account: accountLDAP + "cn=a", Scopes: tokens.AllScopes}, true},
+		{"read-only", Identity{Account: accountOIDC + "sub", Scopes: []string{tokens.ScopeRead}}, false},
+		{"read and upload", Identity{Account: accountCertificate + "CN=c", Scopes: []string{tokens.ScopeRead, tokens.ScopeUpload}}, false},
+		{"token of the --auth user", Identity{Account: accountBasic + "admin", Scopes: tokens.AllScopes, Token: &tokens.Token{}}, false},
+	} {
+		if got := tc.id.IsAdmin(); got != tc.want {
+			t.Errorf("%s: IsAdmin() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	store, err := tokens.NewStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := store.Create("admin", accountBasic+"admin", tokens.Options{Scopes: tokens.AllScopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(NewBasicAuth("admin", "pw"), NewSessionStore(0))
+	auth.tokens = store
+	h := AdminMiddleware(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name  string
+		setup func(r *http.Request)
+		want  int
+	}{
+		{"anonymous", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized},
+		{"--auth user", func(r *http.Request) { r.SetBasicAuth("admin", "pw") }, http.StatusOK},
+		{"token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret) }, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "/api/admin/acl?path=/", nil)
+		tc.setup(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
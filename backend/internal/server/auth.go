@@ -1,10 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
-	"path/filepath"
+	"net/url"
 	"strings"
+
+	"gohttpserver/internal/ldap"
+	"gohttpserver/internal/oidc"
+	"gohttpserver/internal/tokens"
 )
 
 // BasicAuth implements HTTP Basic Authentication
@@ -21,9 +29,14 @@ func NewBasicAuth(username, password string) *BasicAuth {
 	}
 }
 
+// Enabled reports whether credentials are required
+func (ba *BasicAuth) Enabled() bool {
+	return ba.username != "" || ba.password != ""
+}
+
 // Authenticate checks if the request has valid Basic Auth credentials
 func (ba *BasicAuth) Authenticate(r *http.Request) bool {
-	if ba.username == "" && ba.password == "" {
+	if !ba.Enabled() {
 		return true // No auth required
 	}
 
@@ -48,7 +61,14 @@ func (ba *BasicAuth) Authenticate(r *http.Request) bool {
 		return false
 	}
 
-	return parts[0] == ba.username && parts[1] == ba.password
+	return ba.Verify(parts[0], parts[1])
+}
+
+// Verify checks a username and password against the configured credentials
+func (ba *BasicAuth) Verify(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(ba.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(ba.password)) == 1
+	return userOK && passOK
 }
 
 // RequireAuth adds WWW-Authenticate header to response
@@ -57,93 +77,202 @@ func (ba *BasicAuth) RequireAuth(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 }
 
-// PathACL manages path-level access control with wildcard support
-type PathACL struct {
-	allowPaths []string
-	denyPaths  []string
-}
+// Authentication methods reported in Identity
+const (
+	AuthMethodNone        = "none"
+	AuthMethodBasic       = "basic"
+	AuthMethodSession     = "session"
+	AuthMethodToken       = "token"
+	AuthMethodCertificate = "certificate"
+)
 
-// NewPathACL creates a new PathACL instance
-func NewPathACL(allowPaths, denyPaths []string) *PathACL {
-	return &PathACL{
-		allowPaths: allowPaths,
-		denyPaths:  denyPaths,
-	}
+// Account key prefixes. Each login backend has its own namespace, so equal
+// user names from different backends are different accounts.
+const (
+	accountBasic       = "basic:" // --auth user name
+	accountLDAP        = "ldap:"  // Entry DN
+	accountOIDC        = "oidc:"  // Subject claim, which unlike names cannot be edited
+	accountCertificate = "cert:"  // Certificate subject DN
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	User    string
+	Account string // Backend-qualified account key that owns API tokens, e.g. "oidc:<sub>"
+	Method  string
+	Groups  []string      // Groups reported by the directory or identity provider
+	Scopes  []string      // Scopes granted, nil for all
+	Session *Session      // Set for session logins
+	Token   *tokens.Token // Set for API tokens
 }
 
-// IsAllowed checks if a path is allowed based on ACL rules
-// Priority: deny > allow > default (allow)
-func (acl *PathACL) IsAllowed(path string) bool {
-	// Normalize path
-	path = filepath.Clean(path)
-	if !filepath.IsAbs(path) {
-		path = "/" + path
+// HasScope reports whether the caller was granted scope
+func (id *Identity) HasScope(scope string) bool {
+	if id.Scopes == nil {
+		return true
 	}
-	path = filepath.ToSlash(path)
-
-	// Check deny list first (highest priority)
-	for _, denyPath := range acl.denyPaths {
-		if acl.matchPath(path, denyPath) {
-			return false
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
 		}
 	}
+	return false
+}
 
-	// If no allow rules, default to allow
-	if len(acl.allowPaths) == 0 {
+// IsAdmin reports whether the caller may use admin endpoints: the --auth
+// user, or a user granted every scope. API tokens never are.
+func (id *Identity) IsAdmin() bool {
+	if id.Token != nil {
+		return false
+	}
+	if strings.HasPrefix(id.Account, accountBasic) {
 		return true
 	}
-
-	// Check allow list
-	for _, allowPath := range acl.allowPaths {
-		if acl.matchPath(path, allowPath) {
-			return true
+	for _, scope := range tokens.AllScopes {
+		if !id.HasScope(scope) {
+			return false
 		}
 	}
+	return true
+}
 
-	// If allow list exists but no match, deny
-	return false
+// AllowsPath reports whether the caller may access path. Only API tokens
+// can be restricted to some directories.
+func (id *Identity) AllowsPath(path string) bool {
+	return id.Token == nil || id.Token.AllowsPath(path)
 }
 
-// matchPath matches a path against a pattern with wildcard support
-func (acl *PathACL) matchPath(path, pattern string) bool {
-	pattern = filepath.Clean(pattern)
-	if !filepath.IsAbs(pattern) {
-		pattern = "/" + pattern
-	}
-	pattern = filepath.ToSlash(pattern)
+type identityKey struct{}
 
-	// Simple wildcard matching
-	if strings.Contains(pattern, "*") || strings.Contains(pattern, "?") {
-		return acl.wildcardMatch(path, pattern)
-	}
+// IdentityFromRequest returns the identity AuthMiddleware attached to the
+// request, or nil
+func IdentityFromRequest(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityKey{}).(*Identity)
+	return id
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	setAccessUser(r, id.User)
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
 
-	// Exact match or prefix match
-	return strings.HasPrefix(path, pattern) || path == pattern
+// Authenticator authenticates requests with a session cookie, an API token
+// or Basic Auth, so browsers, scripts and command line clients all work.
+// Sessions are started with a password at /api/login or through OpenID
+// Connect. Passwords are checked against --auth, then LDAP. Over TLS,
+// client certificates identify callers without other credentials.
+type Authenticator struct {
+	basic    *BasicAuth
+	sessions *SessionStore
+	tokens   *tokens.Store  // nil if API tokens are disabled
+	oidc     *oidc.Provider // nil if OpenID Connect is disabled
+	ldap     *ldap.Directory
+	certs    *ClientCertAuth // nil without a client CA
+	groups   groupScopes     // Scopes of LDAP, OpenID Connect and certificate users
 }
 
-// wildcardMatch performs simple wildcard matching
-func (acl *PathACL) wildcardMatch(path, pattern string) bool {
-	patternParts := strings.Split(pattern, "*")
-	if len(patternParts) == 1 {
-		return path == pattern
+// NewAuthenticator creates an Authenticator
+func NewAuthenticator(basic *BasicAuth, sessions *SessionStore) *Authenticator {
+	return &Authenticator{
+		basic:    basic,
+		sessions: sessions,
 	}
+}
 
-	if !strings.HasPrefix(path, patternParts[0]) {
-		return false
+// Enabled reports whether credentials are required
+func (a *Authenticator) Enabled() bool {
+	return a.PasswordEnabled() || a.oidc != nil || a.certs != nil
+}
+
+// PasswordEnabled reports whether users can log in with a password
+func (a *Authenticator) PasswordEnabled() bool {
+	return a.basic.Enabled() || a.ldap != nil
+}
+
+// Login checks a username and password and returns the caller to start a
+// session for. The --auth user is granted every scope, LDAP users the
+// scopes of their groups, or only read without --group-scopes.
+func (a *Authenticator) Login(username, password string) (*Identity, bool) {
+	if a.basic.Enabled() && a.basic.Verify(username, password) {
+		return &Identity{User: username, Account: accountBasic + username, Method: AuthMethodBasic}, true
 	}
+	if a.ldap == nil {
+		return nil, false
+	}
+	user, err := a.ldap.Authenticate(username, password)
+	if err != nil {
+		if !errors.Is(err, ldap.ErrInvalidCredentials) {
+			fmt.Printf("LDAP login failed for %s: %v\n", username, err)
+		}
+		return nil, false
+	}
+	scopes, ok := a.groups.scopes(user.Groups)
+	if !ok {
+		fmt.Printf("LDAP login refused for %s: no permitted group in %v\n", username, user.Groups)
+		return nil, false
+	}
+	return &Identity{User: user.Name, Account: accountLDAP + user.DN, Method: AuthMethodBasic, Groups: user.Groups, Scopes: scopes}, true
+}
 
-	remaining := path[len(patternParts[0]):]
-	for i := 1; i < len(patternParts); i++ {
-		part := patternParts[i]
-		if part == "" {
-			continue
+// Identify returns the caller of a request, or false if it is not
+// authenticated. Without authentication every caller is anonymous.
+func (a *Authenticator) Identify(r *http.Request) (*Identity, bool) {
+	if !a.Enabled() {
+		return &Identity{Method: AuthMethodNone}, true
+	}
+	if secret, ok := bearerToken(r); ok {
+		if a.tokens == nil {
+			return nil, false
 		}
-		idx := strings.Index(remaining, part)
-		if idx == -1 {
-			return false
+		tok, err := a.tokens.Authenticate(secret)
+		if err != nil {
+			return nil, false
 		}
-		remaining = remaining[idx+len(part):]
+		return &Identity{User: tok.User, Account: tok.Owner, Method: AuthMethodToken, Scopes: tok.Scopes, Token: &tok}, true
+	}
+	if sess := a.sessions.Get(r); sess != nil {
+		return &Identity{User: sess.User, Account: sess.Account, Method: AuthMethodSession, Groups: sess.Groups, Scopes: sess.Scopes, Session: sess}, true
 	}
+	if user, pass, ok := r.BasicAuth(); ok && a.PasswordEnabled() {
+		return a.Login(user, pass)
+	}
+	return a.certIdentity(r.TLS)
+}
 
-	return true
+// RequireAuth responds with 401. The frontend marks its requests with
+// X-Requested-With so browsers do not show their Basic Auth prompt, it shows
+// its login page instead. Without password logins, browsers are sent to
+// the OpenID Connect login.
+func (a *Authenticator) RequireAuth(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearerToken(r); ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("X-Requested-With") != "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if !a.PasswordEnabled() && a.oidc != nil && r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/api/oidc/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	if !a.PasswordEnabled() && a.oidc == nil && a.certs != nil {
+		http.Error(w, "A valid client certificate is required", http.StatusUnauthorized)
+		return
+	}
+	if !a.PasswordEnabled() {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	a.basic.RequireAuth(w)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
 }
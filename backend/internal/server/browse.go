@@ -0,0 +1,259 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"gohttpserver/internal/archive"
+	"gohttpserver/internal/tokens"
+)
+
+//go:embed templates/listing.html
+var templateFS embed.FS
+
+var listingTemplate = template.Must(template.ParseFS(templateFS, "templates/listing.html"))
+
+// Formats of the built-in directory listing
+const (
+	browseHTML = "html"
+	browseText = "text"
+)
+
+// textUserAgents are command line clients that get a plain-text listing
+var textUserAgents = []string{"curl/", "wget/", "httpie/", "fetch libfetch", "powershell/"}
+
+// browseFormat picks the listing format for a request: format= wins, then
+// command line user agents and clients preferring text/plain get text
+func browseFormat(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case browseHTML:
+		return browseHTML
+	case browseText:
+		return browseText
+	}
+
+	ua := strings.ToLower(r.UserAgent())
+	for _, prefix := range textUserAgents {
+		if strings.HasPrefix(ua, prefix) {
+			return browseText
+		}
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html") {
+		return browseText
+	}
+	return browseHTML
+}
+
+// wantsBuiltinListing reports whether a request for the web frontend should
+// get the built-in listing instead, i.e. for command line clients or when
+// explicitly asked for with format=
+func wantsBuiltinListing(r *http.Request) bool {
+	return r.URL.Query().Get("format") != "" || browseFormat(r) == browseText
+}
+
+// HandleBrowse serves the file tree at its URL: directories as an HTML or
+// plain-text listing, files inline if their type is harmless (see
+// inlineTypes) and as downloads otherwise. Listings require authentication
+// like /api/list; files, like /api/download, only the path ACL.
+func (s *Server) HandleBrowse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cleanPath, err := archive.SanitizePath(s.rootDir, r.URL.Path)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if !s.pathAllowed(cleanPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	fullPath := filepath.Join(s.rootDir, cleanPath)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !info.IsDir() {
+		file, err := s.openAllowed(r, cleanPath)
+		if errors.Is(err, os.ErrPermission) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		if info, err = file.Stat(); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		serveFile(w, r, file, info, true)
+		return
+	}
+
+	// Relative links in the listing need the trailing slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	id, ok := s.auth.Identify(r)
+	if !ok {
+		s.auth.RequireAuth(w, r)
+		return
+	}
+	if !id.HasScope(tokens.ScopeRead) || !id.AllowsPath(cleanPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files, total, err := s.listDirectory(cleanPath, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if browseFormat(r) == browseText {
+		s.writeTextListing(w, cleanPath, files)
+		return
+	}
+
+	page := &listingPage{
+		Path:   filepath.ToSlash(cleanPath),
+		Total:  total,
+		Readme: s.findReadme(r, cleanPath),
+		opts:   opts,
+		query:  r.URL.Query(),
+	}
+	crumbURL := "/"
+	for _, name := range strings.Split(page.Path, "/") {
+		if name == "" {
+			continue
+		}
+		crumbURL += url.PathEscape(name) + "/"
+		page.Breadcrumbs = append(page.Breadcrumbs, listingLink{Name: name, URL: crumbURL})
+	}
+	for _, entry := range files {
+		link := url.PathEscape(entry.Name)
+		if entry.IsDir {
+			link += "/"
+		}
+		page.Files = append(page.Files, listingFile{Entry: entry, URL: link, Size: formatSize(entry.Size)})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listingTemplate.Execute(w, page); err != nil {
+		fmt.Printf("Error rendering listing for /%s: %v\n", cleanPath, err)
+	}
+}
+
+// writeTextListing writes a listing as aligned columns of size, date and name
+func (s *Server) writeTextListing(w http.ResponseWriter, cleanPath string, files []*FileEntry) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Index of /%s\n\n", filepath.ToSlash(cleanPath))
+	for _, entry := range files {
+		size, name := formatSize(entry.Size), entry.Name
+		if entry.IsDir {
+			size, name = "-", name+"/"
+		}
+		if entry.IsSymlink && entry.LinkTarget != "" {
+			name += " -> " + entry.LinkTarget
+		}
+		// AlignRight right-aligns every cell, so the name goes after the last tab
+		fmt.Fprintf(tw, "%s\t  %s\t  %s\n", size, entry.ModTime, name)
+	}
+	tw.Flush()
+}
+
+// listingPage is the data of the listing template
+type listingPage struct {
+	Path        string
+	Breadcrumbs []listingLink
+	Files       []listingFile
+	Total       int
+	Readme      template.HTML
+
+	opts  *listOptions
+	query url.Values
+}
+
+type listingLink struct {
+	Name string
+	URL  string
+}
+
+type listingFile struct {
+	Entry *FileEntry
+	URL   string
+	Size  string
+}
+
+// SortURL links to the listing sorted by key, toggling the order if the
+// listing is already sorted by key
+func (p *listingPage) SortURL(key string) string {
+	q := url.Values{}
+	for k, v := range p.query {
+		q[k] = v
+	}
+	q.Set("sort", key)
+	q.Del("offset")
+	if p.opts.sort == key && !p.opts.desc {
+		q.Set("order", "desc")
+	} else {
+		q.Del("order")
+	}
+	return "?" + q.Encode()
+}
+
+// SortMark shows the sort direction next to the active column
+func (p *listingPage) SortMark(key string) string {
+	if p.opts.sort != key {
+		return ""
+	}
+	if p.opts.desc {
+		return " ↓"
+	}
+	return " ↑"
+}
+
+// formatSize formats a byte count with binary units
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
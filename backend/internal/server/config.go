@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gohttpserver/internal/diskusage"
+	"gohttpserver/internal/search"
+)
+
+// Version is the server version reported by /api/config. Release builds set
+// it with -ldflags "-X gohttpserver/internal/server.Version=v1.2.3".
+var Version = "dev"
+
+// Authentication modes reported in ClientConfig
+const (
+	AuthModeNone        = "none"
+	AuthModeBasic       = "basic"       // Username and password
+	AuthModeOIDC        = "oidc"        // OpenID Connect only
+	AuthModeCertificate = "certificate" // Client certificates only
+)
+
+// ClientConfig describes the server's capabilities to the frontend, so it
+// only offers actions that will succeed
+type ClientConfig struct {
+	Version string `json:"version"`
+	// BaseURL is the base of share links; the key matches what the frontend
+	// has always read from the injected config
+	BaseURL  string         `json:"baseURL"`
+	Features ClientFeatures `json:"features"`
+	Limits   ClientLimits   `json:"limits"`
+	Auth     ClientAuth     `json:"auth"`
+}
+
+// ClientFeatures lists which optional features are enabled
+type ClientFeatures struct {
+	Upload      bool `json:"upload"`
+	Delete      bool `json:"delete"`
+	Extract     bool `json:"extract"`
+	WebDAV      bool `json:"webdav"` // WebDAV is served
+	Share       bool `json:"share"`  // Share links work for other people: downloads need no credentials
+	SearchIndex bool `json:"search_index"`
+	Tokens      bool `json:"tokens"` // Personal API tokens can be created
+}
+
+// ClientLimits are the server-side limits the frontend should respect
+type ClientLimits struct {
+	MaxSearchResults   int   `json:"max_search_results"`
+	MaxContentFileSize int64 `json:"max_content_file_size"`
+	MaxDiskUsageDepth  int   `json:"max_disk_usage_depth"`
+}
+
+// ClientAuth describes how to authenticate and who the caller is
+type ClientAuth struct {
+	Mode          string   `json:"mode"`
+	Authenticated bool     `json:"authenticated"`
+	User          string   `json:"user,omitempty"`
+	OIDC          bool     `json:"oidc,omitempty"`       // Login through OpenID Connect at /api/oidc/login
+	Method        string   `json:"method,omitempty"`     // How the caller authenticated: basic, session or token
+	Scopes        []string `json:"scopes,omitempty"`     // Scopes granted to the caller, omitted for all
+	CSRFToken     string   `json:"csrf_token,omitempty"` // For session logins, see CSRFHeader
+}
+
+// webdavMounted reports whether a WebDAV route is registered. The WebDAV
+// handler is not routed yet, so --webdav has no effect and WebDAV is not
+// offered to clients.
+const webdavMounted = false
+
+// newClientConfig returns the configuration as seen by an anonymous client
+func newClientConfig(config *Config, auth *Authenticator) ClientConfig {
+	// Share links are downloads, which need no login, but required client
+	// certificates stop anyone without one at the TLS handshake
+	share := auth.certs == nil || auth.certs.mode != tls.RequireAndVerifyClientCert
+	cc := ClientConfig{
+		Version: Version,
+		BaseURL: strings.TrimSuffix(config.BaseURL, "/"),
+		Features: ClientFeatures{
+			Upload:      config.EnableUpload,
+			Delete:      config.EnableDelete,
+			Extract:     config.EnableUpload && config.EnableExtract,
+			WebDAV:      webdavMounted,
+			Share:       share,
+			SearchIndex: config.SearchIndex,
+			Tokens:      config.TokenFile != "" && auth.Enabled(),
+		},
+		Limits: ClientLimits{
+			MaxSearchResults:   maxSearchMatches,
+			MaxContentFileSize: search.DefaultMaxContentFileSize,
+			MaxDiskUsageDepth:  diskusage.MaxDepth,
+		},
+		Auth: ClientAuth{Mode: AuthModeNone, Authenticated: true},
+	}
+	switch {
+	case auth.PasswordEnabled():
+		cc.Auth = ClientAuth{Mode: AuthModeBasic, OIDC: auth.oidc != nil}
+	case auth.oidc != nil:
+		cc.Auth = ClientAuth{Mode: AuthModeOIDC, OIDC: true}
+	case auth.Enabled():
+		cc.Auth = ClientAuth{Mode: AuthModeCertificate}
+	}
+	return cc
+}
+
+// HandleConfig returns the client configuration, including who the caller is.
+// It does not require authentication so the frontend can load it first.
+func (s *Server) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cc := s.clientConfig
+	if cc.Auth.Mode != AuthModeNone {
+		if id, ok := s.auth.Identify(r); ok {
+			cc.Auth.Authenticated = true
+			cc.Auth.User, cc.Auth.Method, cc.Auth.Scopes = id.User, id.Method, id.Scopes
+			if id.Session != nil {
+				cc.Auth.CSRFToken = id.Session.CSRFToken
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(cc)
+}
+
+// configScript returns the script that makes the anonymous client
+// configuration available to the frontend before its first request. The JSON
+// encoder escapes <, > and &, so the result is safe inside a script element.
+func configScript(cc ClientConfig) []byte {
+	data, _ := json.Marshal(cc)
+	return []byte("<script>window.__GOHTTPSERVER_CONFIG__=" + string(data) + ";</script>")
+}
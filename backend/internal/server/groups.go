@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"gohttpserver/internal/tokens"
+)
+
+// groupScopes maps directory groups (LDAP or OpenID Connect) and client
+// certificate organizational units to the scopes their members are granted.
+// The group "*" applies to every user.
+type groupScopes map[string][]string
+
+// parseGroupScopes parses entries such as "admins=read+upload+delete".
+// Without entries, LDAP, OpenID Connect and certificate users only read.
+func parseGroupScopes(entries []string) (groupScopes, error) {
+	gs := make(groupScopes)
+	for _, entry := range entries {
+		group, list, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid group mapping %q (expected group=scope+scope)", entry)
+		}
+		for _, scope := range strings.Split(list, "+") {
+			switch scope = strings.TrimSpace(scope); scope {
+			case tokens.ScopeRead, tokens.ScopeUpload, tokens.ScopeDelete:
+				gs[group] = append(gs[group], scope)
+			default:
+				return nil, fmt.Errorf("invalid group mapping %q: %w %q (expected read, upload or delete)", entry, tokens.ErrUnknownScope, scope)
+			}
+		}
+	}
+	return gs, nil
+}
+
+// scopes returns the scopes granted to a member of groups, or false if none
+// of the groups is mapped and there is no "*" mapping. Without mappings
+// every user is granted read only: anyone the directory, the identity
+// provider or the client CA knows can log in, so nobody is trusted with more.
+func (gs groupScopes) scopes(groups []string) ([]string, bool) {
+	if len(gs) == 0 {
+		return []string{tokens.ScopeRead}, true
+	}
+	seen := make(map[string]bool)
+	for _, g := range append([]string{"*"}, groups...) {
+		for _, s := range gs[g] {
+			seen[s] = true
+		}
+	}
+	var result []string
+	for _, s := range tokens.AllScopes {
+		if seen[s] {
+			result = append(result, s)
+		}
+	}
+	return result, len(result) > 0
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"slices"
+	"testing"
+
+	"gohttpserver/internal/tokens"
+)
+
+func TestParseGroupScopes(t *testing.T) {
+	gs, err := parseGroupScopes([]string{"admins=read+upload+delete", " devs = read + upload ", "*=read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(gs["devs"], []string{tokens.ScopeRead, tokens.ScopeUpload}) || len(gs) != 3 {
+		t.Errorf("mappings %v", gs)
+	}
+
+	for _, entry := range []string{"admins", "=read", "admins=", "admins=read+admin"} {
+		if _, err := parseGroupScopes([]string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+	if _, err := parseGroupScopes([]string{"a=write"}); !errors.Is(err, tokens.ErrUnknownScope) {
+		t.Errorf("err = %v, want %v", err, tokens.ErrUnknownScope)
+	}
+}
+
+func TestGroupScopes(t *testing.T) {
+	mapped, err := parseGroupScopes([]string{"admins=delete+read", "devs=upload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDefault, err := parseGroupScopes([]string{"devs=upload", "*=read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		gs     groupScopes
+		groups []string
+		want   []string // nil if the user may not log in
+	}{
+		{"no mappings", nil, []string{"admins"}, []string{tokens.ScopeRead}},
+		{"no mappings, no groups", nil, nil, []string{tokens.ScopeRead}},
+		{"one group", mapped, []string{"admins"}, []string{tokens.ScopeRead, tokens.ScopeDelete}},
+		{"union of groups", mapped, []string{"devs", "admins"}, tokens.AllScopes},
+		{"unmapped group", mapped, []string{"guests"}, nil},
+		{"no groups", mapped, nil, nil},
+		{"* applies to everybody", withDefault, nil, []string{tokens.ScopeRead}},
+		{"* and a group", withDefault, []string{"devs"}, []string{tokens.ScopeRead, tokens.ScopeUpload}},
+	} {
+		got, ok := tc.gs.scopes(tc.groups)
+		if ok != (tc.want != nil) || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: scopes(%q) = %q, %v, want %q", tc.name, tc.groups, got, ok, tc.want)
+		}
+	}
+}
+
+func TestCertIdentityScopes(t *testing.T) {
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+		Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"ops"}},
+	}}}}
+	auth := NewAuthenticator(NewBasicAuth("", ""), NewSessionStore(0))
+	auth.certs = &ClientCertAuth{userField: CertUserCN}
+
+	// Without mappings certificate users may only read
+	id, ok := auth.certIdentity(state)
+	if !ok || id.User != "alice" || !slices.Equal(id.Scopes, []string{tokens.ScopeRead}) || id.IsAdmin() {
+		t.Fatalf("identity %+v, %v", id, ok)
+	}
+
+	auth.groups, _ = parseGroupScopes([]string{"ops=read+upload"})
+	if id, ok := auth.certIdentity(state); !ok || !slices.Equal(id.Scopes, []string{tokens.ScopeRead, tokens.ScopeUpload}) {
+		t.Errorf("identity %+v, %v", id, ok)
+	}
+	auth.groups, _ = parseGroupScopes([]string{"admins=read"})
+	if _, ok := auth.certIdentity(state); ok {
+		t.Error("certificate of an unmapped group accepted")
+	}
+}
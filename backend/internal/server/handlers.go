@@ -2,49 +2,140 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"gohttpserver/internal/archive"
+	"gohttpserver/internal/diskusage"
+	"gohttpserver/internal/duplicates"
 	"gohttpserver/internal/search"
+	"gohttpserver/internal/tokens"
 )
 
 // Server holds server configuration and dependencies
 type Server struct {
-	rootDir   string
-	basicAuth *BasicAuth
-	pathACL   *PathACL
+	rootDir       string
+	auth          *Authenticator
+	pathACL       *PathACL
+	enableExtract bool
+	index         *search.Index // Optional filename index, nil to always walk
+	searchIgnore  []string      // Directory patterns pruned from searches
+	searchHidden  bool          // Whether searches enter hidden directories
+	tokens        *tokens.Store // nil if API tokens are disabled
+	dupes         *duplicates.Manager
+	du            *diskusage.Scanner
+	clientConfig  ClientConfig // As seen by anonymous clients
 }
 
 // NewServer creates a new Server instance
-func NewServer(rootDir string, basicAuth *BasicAuth, pathACL *PathACL) *Server {
+func NewServer(rootDir string, auth *Authenticator, pathACL *PathACL) *Server {
 	return &Server{
-		rootDir:   rootDir,
-		basicAuth: basicAuth,
-		pathACL:   pathACL,
+		rootDir: rootDir,
+		auth:    auth,
+		pathACL: pathACL,
+		dupes:   duplicates.NewManager(rootDir),
+		du: diskusage.NewScanner(rootDir, diskusage.Options{
+			SkipDir: pathACL.IsDenied,
+			Allow:   pathACL.IsAllowed,
+		}),
 	}
 }
 
-// HandleListFiles returns file list as JSON
+// isAllowed reports whether the caller of r may access cleanPath: the path
+// ACL must allow it, and so must the caller's identity if it is restricted.
+// Both the requested path and the path its symlinks resolve to are checked.
+func (s *Server) isAllowed(r *http.Request, cleanPath string) bool {
+	_, ok := s.resolveAllowed(r, cleanPath)
+	return ok
+}
+
+// pathAllowed is isAllowed for the path ACL alone, for checks without a
+// caller such as entries of a listing
+func (s *Server) pathAllowed(cleanPath string) bool {
+	_, ok := s.resolveAllowed(nil, cleanPath)
+	return ok
+}
+
+// resolveAllowed returns the root-relative path cleanPath resolves to, and
+// whether the caller of r (if r is not nil) may access both paths
+func (s *Server) resolveAllowed(r *http.Request, cleanPath string) (string, bool) {
+	var id *Identity
+	if r != nil {
+		id = IdentityFromRequest(r)
+	}
+	allow := func(p string) bool {
+		return s.pathACL.IsAllowed(p) && (id == nil || id.AllowsPath(p))
+	}
+	if !allow(cleanPath) {
+		return "", false
+	}
+	realPath, err := archive.ResolvePath(s.rootDir, cleanPath)
+	if err != nil {
+		return "", false
+	}
+	return realPath, realPath == cleanPath || allow(realPath)
+}
+
+// openAllowed opens the file at cleanPath for the caller of r. Access is
+// checked again once the file is open, and the file must be the one the
+// checked path resolves to, so a symlink swapped after an earlier check
+// cannot redirect the request.
+func (s *Server) openAllowed(r *http.Request, cleanPath string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(s.rootDir, cleanPath))
+	if err != nil {
+		return nil, err
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	realPath, ok := s.resolveAllowed(r, cleanPath)
+	if !ok {
+		file.Close()
+		return nil, os.ErrPermission
+	}
+	checked, err := os.Stat(filepath.Join(s.rootDir, realPath))
+	if err != nil || !os.SameFile(opened, checked) {
+		file.Close()
+		return nil, os.ErrPermission
+	}
+	return file, nil
+}
+
+// HandleListFiles returns a directory listing as JSON. Entries can be ordered
+// with sort=, order= and dirs_first=, narrowed with filter= (search query
+// syntax) and hidden=false, paged with offset= and limit=, and annotated with
+// extras=.
 func (s *Server) HandleListFiles(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
+	params := r.URL.Query()
+	path := params.Get("path")
 	if path == "" {
 		path = "/"
 	}
 
+	opts, err := parseListOptions(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	cleanPath, err := archive.SanitizePath(s.rootDir, path)
 	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -61,75 +152,435 @@ func (s *Server) HandleListFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entries, err := os.ReadDir(fullPath)
+	files, total, err := s.listDirectory(cleanPath, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var files []map[string]interface{}
-	for _, entry := range entries {
-		entryInfo, err := entry.Info()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":   cleanPath,
+		"files":  files,
+		"total":  total,
+		"offset": min(opts.offset, total),
+		"limit":  opts.limit,
+	})
+}
+
+// maxSearchMatches caps how many matches are collected, sorted and paginated
+// for a single query
+const maxSearchMatches = 10000
+
+// HandleSearch performs file search. Results can be scoped to a subtree with
+// path=, ordered with sort= and order=, and paged with limit= and cursor=.
+func (s *Server) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := params.Get("q")
+	if query == "" {
+		http.Error(w, "Missing query parameter 'q'", http.StatusBadRequest)
+		return
+	}
+
+	// "max" is the historical name of the page size
+	limit := 100
+	for _, name := range []string{"max", "limit"} {
+		if str := params.Get(name); str != "" {
+			if m, err := strconv.Atoi(str); err == nil && m > 0 {
+				limit = m
+			}
+		}
+	}
+
+	if params.Get("mode") == "content" {
+		s.handleContentSearch(w, r, query, limit)
+		return
+	}
+
+	q, err := search.ParseQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sortKey, err := search.ParseSort(params.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	desc := params.Get("order") == "desc"
+
+	cleanScope, ok := s.resolveSearchScope(w, r, params.Get("path"))
+	if !ok {
+		return
+	}
+
+	if format := streamFormat(r); format != "" {
+		s.streamSearch(w, r, format, q, cleanScope, limit)
+		return
+	}
+
+	var results []search.Result
+	if s.index != nil && s.index.Ready() {
+		results = s.index.Search(q, cleanScope, maxSearchMatches)
+	} else {
+		results, err = search.Search(r.Context(), s.rootDir, q, s.searchOptions(cleanScope, maxSearchMatches))
+		if r.Context().Err() != nil {
+			return
+		}
 		if err != nil {
-			continue
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+	}
 
-		entryPath := filepath.Join(cleanPath, entry.Name())
-		if !s.pathACL.IsAllowed(entryPath) {
-			continue
+	// Filter by ACL
+	filteredResults := []search.Result{}
+	for _, result := range results {
+		if s.pathACL.IsAllowed(result.Path) {
+			filteredResults = append(filteredResults, result)
 		}
+	}
 
-		fileInfo := map[string]interface{}{
-			"name":     entry.Name(),
-			"path":     entryPath,
-			"is_dir":   entry.IsDir(),
-			"size":     entryInfo.Size(),
-			"mod_time": entryInfo.ModTime().Format("2006-01-02 15:04:05"),
+	search.SortResults(filteredResults, q, sortKey, desc)
+	page, next, err := search.Paginate(filteredResults, sortKey, desc, params.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":       query,
+		"path":        cleanScope,
+		"sort":        sortKey,
+		"results":     page,
+		"count":       len(page),
+		"total":       len(filteredResults),
+		"truncated":   len(results) >= maxSearchMatches,
+		"next_cursor": next,
+	})
+}
+
+// streamSearch sends each matching result as a separate event as soon as it
+// is found, followed by a summary event. Results are in walk order.
+func (s *Server) streamSearch(w http.ResponseWriter, r *http.Request, format string, q *search.Query, scope string, limit int) {
+	stream := newEventStream(w, format)
+	start := time.Now()
+	count := 0
+
+	emit := func(result search.Result) bool {
+		if !s.pathACL.IsAllowed(result.Path) {
+			return true
+		}
+		if err := stream.send("result", result); err != nil {
+			return false
 		}
-		files = append(files, fileInfo)
+		count++
+		return count < limit
+	}
+
+	var err error
+	if s.index != nil && s.index.Ready() {
+		for _, result := range s.index.Search(q, scope, maxSearchMatches) {
+			if r.Context().Err() != nil || !emit(result) {
+				break
+			}
+		}
+	} else {
+		err = search.SearchFunc(r.Context(), s.rootDir, q, s.searchOptions(scope, 0), emit)
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+
+	summary := map[string]interface{}{
+		"query":      q.Raw,
+		"path":       scope,
+		"count":      count,
+		"truncated":  count >= limit,
+		"elapsed_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		summary["error"] = err.Error()
+	}
+	stream.send("summary", summary)
+}
+
+// handleContentSearch greps text files for the query. Supported parameters are
+// regex=true, case=true, path= (scope) and filter= (a name query such as ext:go).
+func (s *Server) handleContentSearch(w http.ResponseWriter, r *http.Request, pattern string, limit int) {
+	params := r.URL.Query()
+
+	cleanScope, ok := s.resolveSearchScope(w, r, params.Get("path"))
+	if !ok {
+		return
+	}
+
+	var filter *search.Query
+	if f := params.Get("filter"); f != "" {
+		var err error
+		if filter, err = search.ParseQuery(f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts := search.ContentOptions{
+		Options:       s.searchOptions(cleanScope, limit),
+		Pattern:       pattern,
+		Regex:         params.Get("regex") == "true",
+		CaseSensitive: params.Get("case") == "true",
+		Filter:        filter,
+	}
+
+	if format := streamFormat(r); format != "" {
+		s.streamContentSearch(w, r, format, opts)
+		return
+	}
+
+	results, err := search.SearchContent(r.Context(), s.rootDir, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Context().Err() != nil {
+		// Client went away, nobody is listening
+		return
+	}
+	if results == nil {
+		results = []search.ContentResult{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":  cleanPath,
-		"files": files,
+		"query":     pattern,
+		"mode":      "content",
+		"path":      cleanScope,
+		"results":   results,
+		"count":     len(results),
+		"truncated": len(results) >= limit,
 	})
 }
 
-// HandleSearch performs file search
-func (s *Server) HandleSearch(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Missing query parameter 'q'", http.StatusBadRequest)
+// streamContentSearch sends each file whose content matches as soon as it is
+// found, followed by a summary event
+func (s *Server) streamContentSearch(w http.ResponseWriter, r *http.Request, format string, opts search.ContentOptions) {
+	// Validate the pattern before committing to a streaming response
+	if opts.Regex {
+		if _, err := regexp.Compile(opts.Pattern); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	stream := newEventStream(w, format)
+	start := time.Now()
+	opts.OnResult = func(result search.ContentResult) {
+		stream.send("result", result)
+	}
+
+	results, err := search.SearchContent(r.Context(), s.rootDir, opts)
+	if r.Context().Err() != nil {
 		return
 	}
 
-	maxResults := 100
-	if maxStr := r.URL.Query().Get("max"); maxStr != "" {
-		if m, err := strconv.Atoi(maxStr); err == nil && m > 0 {
-			maxResults = m
+	summary := map[string]interface{}{
+		"query":      opts.Pattern,
+		"mode":       "content",
+		"path":       opts.Scope,
+		"count":      len(results),
+		"truncated":  len(results) >= opts.MaxResults,
+		"elapsed_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		summary["error"] = err.Error()
+	}
+	stream.send("summary", summary)
+}
+
+// searchOptions returns the walk options for a search below scope. Denied
+// and ignored directories are pruned so they are never read.
+func (s *Server) searchOptions(scope string, maxResults int) search.Options {
+	return search.Options{
+		Scope:      scope,
+		MaxResults: maxResults,
+		Ignore:     s.searchIgnore,
+		SkipHidden: !s.searchHidden,
+		SkipDir:    s.pathACL.IsDenied,
+		Allow:      s.pathACL.IsAllowed,
+		AllowSymlink: func(relPath string) bool {
+			return archive.AllowSymlink(s.rootDir, filepath.FromSlash(relPath))
+		},
+	}
+}
+
+// resolveSearchScope validates the search scope directory. It writes an error
+// response when ok is false.
+func (s *Server) resolveSearchScope(w http.ResponseWriter, r *http.Request, scope string) (string, bool) {
+	if scope == "" {
+		scope = "/"
+	}
+
+	cleanScope, err := archive.SanitizePath(s.rootDir, scope)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return "", false
+	}
+
+	if !s.isAllowed(r, cleanScope) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return "", false
+	}
+
+	if info, err := os.Stat(filepath.Join(s.rootDir, cleanScope)); err != nil || !info.IsDir() {
+		http.Error(w, "Search path is not a directory", http.StatusBadRequest)
+		return "", false
+	}
+
+	return cleanScope, true
+}
+
+// HandleDuplicates manages background searches for duplicate files.
+//
+//	POST   /api/duplicates?path=dir  start a search (or join a running one)
+//	GET    /api/duplicates/{id}      progress, and the groups once done
+//	DELETE /api/duplicates/{id}      cancel a running search
+//
+// Jobs belong to the account that started them, and the groups only list
+// the paths the caller may access.
+func (s *Server) HandleDuplicates(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/duplicates"), "/")
+	if id == "" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
+		s.startDuplicates(w, r)
+		return
+	}
+
+	job := s.dupes.Get(id)
+	if job == nil || job.Owner != requestAccount(r) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
 	}
 
-	results, err := search.Search(s.rootDir, query, maxResults)
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		job.Cancel()
+		fmt.Printf("Duplicate search cancelled: %s\n", job.ID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.duplicatesStatus(r, job))
+}
+
+// duplicatesStatus returns the status of job with the paths r may not access
+// left out
+func (s *Server) duplicatesStatus(r *http.Request, job *duplicates.Job) duplicates.JobStatus {
+	return job.Status().Filter(func(p string) bool {
+		return s.isAllowed(r, filepath.FromSlash(p))
+	})
+}
+
+// requestAccount returns the account of the caller, "" without authentication
+func requestAccount(r *http.Request) string {
+	if id := IdentityFromRequest(r); id != nil {
+		return id.Account
+	}
+	return ""
+}
+
+func (s *Server) startDuplicates(w http.ResponseWriter, r *http.Request) {
+	cleanScope, ok := s.resolveSearchScope(w, r, r.FormValue("path"))
+	if !ok {
+		return
+	}
+
+	var minSize int64
+	if v := r.FormValue("min_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid parameter 'min_size'", http.StatusBadRequest)
+			return
+		}
+		minSize = n
+	}
+
+	job, err := s.dupes.Start(requestAccount(r), duplicates.Options{
+		Options: s.searchOptions(cleanScope, 0),
+		MinSize: minSize,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Too many duplicate searches running, try again later", http.StatusTooManyRequests)
 		return
 	}
+	fmt.Printf("Duplicate search started: %s (path: /%s)\n", job.ID, cleanScope)
 
-	// Filter by ACL
-	var filteredResults []search.Result
-	for _, result := range results {
-		if s.pathACL.IsAllowed(result.Path) {
-			filteredResults = append(filteredResults, result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/duplicates/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.duplicatesStatus(r, job))
+}
+
+// HandleDiskUsage returns the recursive size and file count of a path and of
+// its children down to the requested depth, plus the space on the filesystem
+func (s *Server) HandleDiskUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	cleanPath, err := archive.SanitizePath(s.rootDir, path)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if !s.isAllowed(r, cleanPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	depth := 1
+	if d := r.URL.Query().Get("depth"); d != "" {
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 0 {
+			http.Error(w, "Invalid parameter 'depth'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	usage, err := s.du.Usage(r.Context(), cleanPath, depth)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
 		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	fsInfo, err := diskusage.StatFS(s.rootDir)
+	if err != nil {
+		fmt.Printf("Filesystem statistics unavailable: %v\n", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"query":   query,
-		"results": filteredResults,
-		"count":   len(filteredResults),
+		"path":       cleanPath,
+		"depth":      depth,
+		"usage":      usage,
+		"filesystem": fsInfo,
 	})
 }
 
@@ -146,13 +597,16 @@ func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
 
-	fullPath := filepath.Join(s.rootDir, cleanPath)
-	file, err := os.Open(fullPath)
+	file, err := s.openAllowed(r, cleanPath)
+	if errors.Is(err, os.ErrPermission) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -170,13 +624,41 @@ func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set content type
+	serveFile(w, r, file, info, false)
+}
+
+// inlineTypes are the content types that may be shown in the browser rather
+// than downloaded. Anything that can run script, such as HTML or SVG, is
+// left out so uploaded files cannot act on the server's origin.
+var inlineTypes = map[string]bool{
+	"text/plain": true,
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+// serveFile sends file with Range support. It is shown inline only if inline
+// is set and its type is in inlineTypes; otherwise it is an attachment.
+func serveFile(w http.ResponseWriter, r *http.Request, file *os.File, info os.FileInfo, inline bool) {
 	contentType := mime.TypeByExtension(filepath.Ext(info.Name()))
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	disposition := "attachment"
+	if inline && inlineTypes[mediaType] {
+		disposition = "inline"
+	}
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, info.Name()))
+	// Never let the browser guess a more dangerous type, or run script
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Connection", "keep-alive") // Keep connection alive for large downloads
 
@@ -198,7 +680,7 @@ func (s *Server) HandleZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -232,6 +714,116 @@ func (s *Server) HandleZip(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleArchiveList lists the entries of a zip/tar/tar.gz archive
+func (s *Server) HandleArchiveList(w http.ResponseWriter, r *http.Request) {
+	cleanPath, fullPath, ok := s.resolveArchive(w, r, "/api/archive/list")
+	if !ok {
+		return
+	}
+
+	entries, err := archive.ListArchive(fullPath, archive.DefaultLimits)
+	if err != nil {
+		http.Error(w, err.Error(), archiveErrorStatus(err))
+		return
+	}
+	if entries == nil {
+		entries = []archive.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    cleanPath,
+		"format":  archive.DetectFormat(fullPath),
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// HandleArchiveGet streams a single entry out of an archive
+func (s *Server) HandleArchiveGet(w http.ResponseWriter, r *http.Request) {
+	entryName := r.URL.Query().Get("entry")
+	if entryName == "" {
+		http.Error(w, "Missing query parameter 'entry'", http.StatusBadRequest)
+		return
+	}
+
+	_, fullPath, ok := s.resolveArchive(w, r, "/api/archive/get")
+	if !ok {
+		return
+	}
+
+	rc, entry, err := archive.OpenEntry(fullPath, entryName, archive.DefaultLimits)
+	if err != nil {
+		http.Error(w, err.Error(), archiveErrorStatus(err))
+		return
+	}
+	defer rc.Close()
+
+	name := filepath.Base(entry.Name)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+
+	// Headers are already sent, so a failure here can only abort the response
+	io.Copy(w, rc)
+}
+
+// resolveArchive validates the archive path in the request URL and returns
+// its clean and absolute forms. It writes an error response when ok is false.
+func (s *Server) resolveArchive(w http.ResponseWriter, r *http.Request, prefix string) (cleanPath, fullPath string, ok bool) {
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	if path == "" || path == "/" {
+		http.Error(w, "Missing archive path", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	cleanPath, err := archive.SanitizePath(s.rootDir, path)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	if !s.isAllowed(r, cleanPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return "", "", false
+	}
+
+	fullPath = filepath.Join(s.rootDir, cleanPath)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return "", "", false
+	}
+
+	if info.IsDir() {
+		http.Error(w, "Not an archive", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return cleanPath, fullPath, true
+}
+
+// archiveErrorStatus maps archive errors to HTTP status codes
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, archive.ErrEntryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, archive.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, archive.ErrUnsafeEntry):
+		return http.StatusBadRequest
+	case errors.Is(err, archive.ErrTooManyEntries),
+		errors.Is(err, archive.ErrEntryTooLarge),
+		errors.Is(err, archive.ErrArchiveTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
 // HandleUpload handles file upload
 func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
@@ -263,13 +855,25 @@ func (s *Server) handlePostUpload(w http.ResponseWriter, r *http.Request) {
 		uploadPath = "/"
 	}
 
+	policy, err := parseConflictPolicy(r.FormValue("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	extract := r.FormValue("extract") == "true"
+	if extract && !s.enableExtract {
+		http.Error(w, "Archive extraction is disabled. Use --extract flag to enable.", http.StatusForbidden)
+		return
+	}
+
 	cleanPath, err := archive.SanitizePath(s.rootDir, uploadPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Invalid path '%s': %v", uploadPath, err), http.StatusBadRequest)
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -281,7 +885,21 @@ func (s *Server) handlePostUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var uploadedFiles []string
+	var skippedFiles []string
 	var uploadErrors []string
+	extracted := make(map[string]*archive.ExtractSummary)
+	record := func(result *uploadResult) {
+		switch {
+		case result.extracted != nil:
+			extracted[result.name] = result.extracted
+			uploadedFiles = append(uploadedFiles, result.name)
+		case result.skipped:
+			skippedFiles = append(skippedFiles, result.name)
+		default:
+			uploadedFiles = append(uploadedFiles, result.name)
+		}
+	}
+
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
 		// Try single file
@@ -292,21 +910,13 @@ func (s *Server) handlePostUpload(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 		filename := filepath.Base(header.Filename)
-		targetPath := filepath.Join(targetDir, filename)
 
-		dst, err := os.Create(targetPath)
+		result, err := s.saveUpload(r, file, cleanPath, filename, policy, extract)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create file '%s': %v", filename, err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to store file '%s': %v", filename, err), conflictErrorStatus(err))
 			return
 		}
-
-		if _, err := io.Copy(dst, file); err != nil {
-			dst.Close()
-			http.Error(w, fmt.Sprintf("Failed to write file '%s': %v", filename, err), http.StatusInternalServerError)
-			return
-		}
-		dst.Close()
-		uploadedFiles = append(uploadedFiles, filename)
+		record(result)
 	} else {
 		for _, fileHeader := range files {
 			file, err := fileHeader.Open()
@@ -316,29 +926,17 @@ func (s *Server) handlePostUpload(w http.ResponseWriter, r *http.Request) {
 			}
 
 			filename := filepath.Base(fileHeader.Filename)
-			targetPath := filepath.Join(targetDir, filename)
-
-			dst, err := os.Create(targetPath)
+			result, err := s.saveUpload(r, file, cleanPath, filename, policy, extract)
+			file.Close()
 			if err != nil {
-				file.Close()
-				uploadErrors = append(uploadErrors, fmt.Sprintf("Failed to create file '%s': %v", filename, err))
+				uploadErrors = append(uploadErrors, fmt.Sprintf("Failed to store file '%s': %v", filename, err))
 				continue
 			}
-
-			if _, err := io.Copy(dst, file); err != nil {
-				file.Close()
-				dst.Close()
-				uploadErrors = append(uploadErrors, fmt.Sprintf("Failed to write file '%s': %v", filename, err))
-				continue
-			}
-
-			file.Close()
-			dst.Close()
-			uploadedFiles = append(uploadedFiles, filename)
+			record(result)
 		}
 
 		// If no files were uploaded successfully, return error
-		if len(uploadedFiles) == 0 {
+		if len(uploadedFiles) == 0 && len(skippedFiles) == 0 {
 			errorMsg := "No files were uploaded successfully"
 			if len(uploadErrors) > 0 {
 				errorMsg += ". Errors: " + strings.Join(uploadErrors, "; ")
@@ -354,6 +952,12 @@ func (s *Server) handlePostUpload(w http.ResponseWriter, r *http.Request) {
 		"files":   uploadedFiles,
 		"count":   len(uploadedFiles),
 	}
+	if len(skippedFiles) > 0 {
+		response["skipped"] = skippedFiles
+	}
+	if len(extracted) > 0 {
+		response["extracted"] = extracted
+	}
 	if len(uploadErrors) > 0 {
 		response["errors"] = uploadErrors
 		response["warning"] = fmt.Sprintf("%d file(s) uploaded successfully, but %d error(s) occurred", len(uploadedFiles), len(uploadErrors))
@@ -368,47 +972,336 @@ func (s *Server) handlePutUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, err := parseConflictPolicy(r.URL.Query().Get("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	cleanPath, err := archive.SanitizePath(s.rootDir, path)
 	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	result, err := s.saveUpload(r, r.Body, filepath.Dir(cleanPath), filepath.Base(cleanPath), policy, false)
+	if err != nil {
+		http.Error(w, err.Error(), conflictErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    filepath.Join(filepath.Dir(cleanPath), result.name),
+		"skipped": result.skipped,
+	})
+}
+
+// Conflict policies applied when an upload or extracted entry targets an existing file
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictRename    = "rename"
+	ConflictError     = "error"
+)
+
+var (
+	errConflictSkip = errors.New("file exists, skipped")
+	errFileExists   = errors.New("file already exists")
+	errAccessDenied = errors.New("access denied")
+)
+
+// parseConflictPolicy validates a conflict policy, defaulting to overwrite
+func parseConflictPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictError:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (expected overwrite, skip, rename or error)", policy)
+}
+
+// resolveConflict returns the path a file should be written to under the given
+// policy. It returns errConflictSkip or errFileExists if nothing should be written.
+func resolveConflict(targetPath, policy string) (string, error) {
+	if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+		return targetPath, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return "", errConflictSkip
+	case ConflictError:
+		return "", errFileExists
+	case ConflictRename:
+		dir, name := filepath.Split(targetPath)
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 1; i < 10000; i++ {
+			candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+			if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+				return candidate, nil
+			}
+		}
+		return "", errFileExists
+	}
+	return targetPath, nil
+}
+
+// conflictErrorStatus maps upload errors to HTTP status codes
+func conflictErrorStatus(err error) int {
+	if errors.Is(err, errFileExists) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, archive.ErrSymlinkDenied) || errors.Is(err, errAccessDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// uploadResult describes how a single uploaded file was stored
+type uploadResult struct {
+	name      string
+	skipped   bool
+	extracted *archive.ExtractSummary
+}
+
+// saveUpload writes src as filename into the directory cleanDir (relative to
+// the root), applying the conflict policy. If extract is set and filename is a
+// supported archive, its contents are extracted into cleanDir instead. Every
+// file written is checked against the path rules of r.
+func (s *Server) saveUpload(r *http.Request, src io.Reader, cleanDir, filename, policy string, extract bool) (*uploadResult, error) {
+	if extract && archive.DetectFormat(filename) != "" {
+		summary, err := s.extractUpload(r, src, cleanDir, filename, policy)
+		if err != nil {
+			return nil, err
+		}
+		return &uploadResult{name: filename, extracted: summary}, nil
+	}
+
+	if !s.isAllowed(r, filepath.Join(cleanDir, filename)) {
+		return nil, errAccessDenied
+	}
+	targetDir := filepath.Join(s.rootDir, cleanDir)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return nil, err
+	}
+
+	targetPath, err := resolveConflict(filepath.Join(targetDir, filename), policy)
+	if errors.Is(err, errConflictSkip) {
+		return &uploadResult{name: filename, skipped: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A renamed file must be allowed under its new name too
+	if !s.isAllowed(r, filepath.Join(cleanDir, filepath.Base(targetPath))) {
+		return nil, errAccessDenied
+	}
+
+	// Overwriting an existing symlink would write to its target
+	if err := archive.CheckSymlinks(s.rootDir, filepath.Join(cleanDir, filepath.Base(targetPath))); err != nil {
+		return nil, err
+	}
+
+	dst, err := os.Create(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return nil, err
+	}
+	return &uploadResult{name: filepath.Base(targetPath)}, nil
+}
+
+// extractUpload spools an uploaded archive to a temporary file and extracts it
+func (s *Server) extractUpload(r *http.Request, src io.Reader, cleanDir, filename, policy string) (*archive.ExtractSummary, error) {
+	// Keep the original name as suffix so the archive format can be detected
+	tmp, err := os.CreateTemp("", "gohttpserver-upload-*-"+filename)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.extractArchive(r, tmp.Name(), cleanDir, policy, nil)
+}
+
+// HandleExtract unpacks an archive stored on the server into a target directory.
+// With progress=true the per-entry results are streamed as NDJSON followed by a
+// summary line; otherwise a single JSON document is returned.
+func (s *Server) HandleExtract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archivePath := r.FormValue("path")
+	if archivePath == "" {
+		http.Error(w, "Missing parameter 'path'", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := parseConflictPolicy(r.FormValue("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cleanPath, err := archive.SanitizePath(s.rootDir, archivePath)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
 
 	fullPath := filepath.Join(s.rootDir, cleanPath)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-	// Create parent directory if needed
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if info.IsDir() || archive.DetectFormat(fullPath) == "" {
+		http.Error(w, "Not a supported archive", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	file, err := os.Create(fullPath)
+	dest := r.FormValue("dest")
+	if dest == "" {
+		dest = filepath.Dir(cleanPath)
+	}
+
+	destPath, err := archive.SanitizePath(s.rootDir, dest)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid destination", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(file, r.Body); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !s.isAllowed(r, destPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	if r.FormValue("progress") == "true" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		processed := 0
+		summary, err := s.extractArchive(r, fullPath, destPath, policy, func(result archive.ExtractResult) {
+			processed++
+			enc.Encode(map[string]interface{}{
+				"type":      "entry",
+				"processed": processed,
+				"entry":     result,
+			})
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+		enc.Encode(extractResponse("summary", cleanPath, destPath, summary, err, false))
 		return
 	}
 
+	summary, err := s.extractArchive(r, fullPath, destPath, policy, nil)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"path":    cleanPath,
+	if err != nil {
+		w.WriteHeader(archiveErrorStatus(err))
+	}
+	json.NewEncoder(w).Encode(extractResponse("", cleanPath, destPath, summary, err, true))
+}
+
+// extractResponse builds the JSON body reported for an extraction
+func extractResponse(kind, path, dest string, summary *archive.ExtractSummary, err error, withEntries bool) map[string]interface{} {
+	response := map[string]interface{}{
+		"success":   err == nil && summary.Failed == 0,
+		"path":      path,
+		"dest":      dest,
+		"extracted": summary.Extracted,
+		"skipped":   summary.Skipped,
+		"failed":    summary.Failed,
+		"bytes":     summary.Bytes,
+	}
+	if kind != "" {
+		response["type"] = kind
+	}
+	if withEntries {
+		response["entries"] = summary.Entries
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	return response
+}
+
+// extractArchive extracts the archive at fullPath into the directory cleanDir
+// (relative to the root), checking every entry against the path ACL
+func (s *Server) extractArchive(r *http.Request, fullPath, cleanDir, policy string, progress func(archive.ExtractResult)) (*archive.ExtractSummary, error) {
+	return archive.Extract(fullPath, archive.ExtractOptions{
+		Root:     s.rootDir,
+		Limits:   archive.DefaultLimits,
+		Progress: progress,
+		Resolve: func(entry archive.Entry) (string, error) {
+			entryPath, err := archive.SanitizePath(s.rootDir, filepath.Join(cleanDir, filepath.FromSlash(entry.Name)))
+			if err != nil {
+				return "", err
+			}
+
+			if !s.isAllowed(r, entryPath) {
+				return "", errAccessDenied
+			}
+
+			if entry.IsDir {
+				return entryPath, nil
+			}
+
+			targetPath, err := resolveConflict(filepath.Join(s.rootDir, entryPath), policy)
+			if errors.Is(err, errConflictSkip) {
+				return "", archive.ErrSkipEntry
+			}
+			if err != nil {
+				return "", err
+			}
+			targetPath, err = filepath.Rel(s.rootDir, targetPath)
+			if err != nil {
+				return "", err
+			}
+			if !s.isAllowed(r, targetPath) {
+				return "", errAccessDenied
+			}
+			return targetPath, nil
+		},
 	})
 }
 
-// HandleDelete handles file/directory deletion
+// HandleDelete handles file/directory deletion. Only DELETE is accepted, so
+// links and images on other sites cannot delete anything.
 func (s *Server) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	path := strings.TrimPrefix(r.URL.Path, "/api/delete")
 	if path == "" {
 		http.Error(w, "Missing path", http.StatusBadRequest)
@@ -421,7 +1314,7 @@ func (s *Server) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.pathACL.IsAllowed(cleanPath) {
+	if !s.isAllowed(r, cleanPath) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -2,37 +2,87 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"gohttpserver/internal/accesslog"
+	"gohttpserver/internal/archive"
+	"gohttpserver/internal/certs"
+	"gohttpserver/internal/duplicates"
+	"gohttpserver/internal/ldap"
+	"gohttpserver/internal/oidc"
+	"gohttpserver/internal/search"
+	"gohttpserver/internal/tokens"
+	"gohttpserver/internal/webui"
 )
 
 // Config holds server configuration
 type Config struct {
-	RootDir      string
-	Port         int
-	HTTPSPort    int
-	HTTPS        bool
-	CertFile     string
-	KeyFile      string
-	Auth         string // username:password
-	AllowPaths   []string
-	DenyPaths    []string
-	EnableWebDAV bool
-	EnableUpload bool
-	EnableDelete bool
-	WebDir       string // Directory for web frontend files
-	BaseURL      string // Base URL for sharing (e.g., http://10.0.203.100:8080)
+	RootDir       string
+	Port          int
+	HTTPSPort     int
+	HTTPS         bool
+	HTTPSRedirect bool          // Redirect the HTTP listener to HTTPS
+	HSTSMaxAge    time.Duration // Strict-Transport-Security max-age sent with redirects enabled, 0 to disable
+	CertFile      string
+	KeyFile       string
+	SelfSigned    bool          // Generate a local CA and server certificate instead of CertFile and KeyFile
+	SelfSignedDir string        // Where the generated certificates are kept, default certs.DefaultDir()
+	TLSHosts      []string      // Hostnames and IPs of the generated certificate, default certs.DefaultHosts()
+	Auth          string        // username:password
+	SessionTTL    time.Duration // Lifetime of logins made through /api/login
+	TokenFile     string        // File of personal API tokens, API tokens are disabled if empty
+	OIDC          oidc.Config   // OpenID Connect login, disabled if OIDC.Issuer is empty
+	LDAP          ldap.Config   // LDAP password login, disabled if LDAP.URL is empty
+	GroupScopes   []string      // group=scope+scope mappings for LDAP, OpenID Connect and certificate users
+	ClientCA      string        // PEM CA certificates for client certificates, disabled if empty
+	ClientAuth    string        // Client certificate mode: require (default) or request
+	ClientCRLs    []string      // Revocation lists for client certificates (PEM or DER)
+	ClientUser    string        // Client certificate field holding the user: cn (default), email, dns, uri or dn
+	AllowPaths    []string
+	DenyPaths     []string
+	ACLFile       string // Optional file of gitignore-style path rules
+	ACLTrace      bool   // Expose the ACL rule evaluation at /api/admin/acl
+	EnableWebDAV  bool
+	EnableUpload  bool
+	EnableDelete  bool
+	EnableExtract bool          // Server-side archive extraction (requires EnableUpload)
+	SearchIndex   bool          // Maintain an in-memory filename index for search
+	IndexFile     string        // Optional file to persist the search index
+	IndexRescan   time.Duration // Interval between full index rescans, 0 to disable
+	SearchIgnore  []string      // Directory patterns never searched (e.g. .git, node_modules)
+	SearchHidden  bool          // Search inside hidden (dot) directories
+	Symlinks      string        // Symlink policy: deny, root (default) or all
+	WebDir        string        // Directory for web frontend files, overrides the embedded build
+	BaseURL       string        // Base URL for sharing (e.g., http://10.0.203.100:8080)
+
+	LogFormat        string   // Request log on stdout: text (default), json or off
+	AccessLog        string   // Apache combined log file, disabled if empty
+	AccessLogMaxSize int64    // Rotate AccessLog past this many bytes, 0 to never rotate
+	AccessLogBackups int      // Rotated access logs kept
+	TrustedProxies   []string // Proxy IPs and CIDR ranges whose X-Forwarded-For and X-Request-ID are believed
 }
 
-// HTTPServer wraps the HTTP server
+// HTTPServer wraps the HTTP server, and the HTTPS server when HTTPS is
+// enabled. Both run together; the HTTP one can redirect to the other.
 type HTTPServer struct {
-	config *Config
-	server *http.Server
+	config    *Config
+	server    *http.Server // nil when HTTPS is enabled and Port is 0
+	tlsServer *http.Server // nil without HTTPS
+	redirect  bool         // The HTTP server redirects to HTTPS
+	tlsCert   *certs.Reloader
+	accessLog *accesslog.Logger // nil if request logging is off
+	index     *search.Index
+	dupes     *duplicates.Manager
 }
 
 // NewHTTPServer creates a new HTTP server instance
@@ -53,6 +103,15 @@ func NewHTTPServer(config *Config) (*HTTPServer, error) {
 
 	config.RootDir = rootDir
 
+	// Symlink policy, applied wherever paths are resolved
+	if config.Symlinks != "" {
+		policy, err := archive.ParseSymlinkPolicy(config.Symlinks)
+		if err != nil {
+			return nil, err
+		}
+		archive.SetSymlinkPolicy(policy)
+	}
+
 	// Parse auth
 	var basicAuth *BasicAuth
 	if config.Auth != "" {
@@ -64,28 +123,133 @@ func NewHTTPServer(config *Config) (*HTTPServer, error) {
 		basicAuth = NewBasicAuth("", "")
 	}
 
+	var tokenStore *tokens.Store
+	if config.TokenFile != "" {
+		tokenStore, err = tokens.NewStore(config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API tokens: %w", err)
+		}
+	}
+	auth := NewAuthenticator(basicAuth, NewSessionStore(config.SessionTTL))
+	auth.tokens = tokenStore
+	if config.OIDC.Issuer != "" {
+		auth.oidc, err = oidc.NewProvider(config.OIDC)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.LDAP.URL != "" {
+		auth.ldap, err = ldap.NewDirectory(config.LDAP)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.ClientCA != "" {
+		if !config.HTTPS {
+			return nil, fmt.Errorf("client certificates require HTTPS")
+		}
+		auth.certs, err = NewClientCertAuth(config.ClientCA, config.ClientAuth, config.ClientCRLs, config.ClientUser)
+		if err != nil {
+			return nil, err
+		}
+	}
+	auth.groups, err = parseGroupScopes(config.GroupScopes)
+	if err != nil {
+		return nil, err
+	}
+	if len(auth.groups) == 0 {
+		if auth.ldap != nil {
+			fmt.Printf("Warning: LDAP users are read-only without --group-scopes\n")
+		}
+		if auth.oidc != nil {
+			fmt.Printf("Warning: OpenID Connect users are read-only without --group-scopes\n")
+		}
+		if auth.certs != nil {
+			fmt.Printf("Warning: client certificate users are read-only without --group-scopes\n")
+		}
+	}
+
 	// Create path ACL
-	pathACL := NewPathACL(config.AllowPaths, config.DenyPaths)
+	pathACL, err := NewPathACL(config.AllowPaths, config.DenyPaths, config.ACLFile)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create server instance
-	srv := NewServer(config.RootDir, basicAuth, pathACL)
+	srv := NewServer(config.RootDir, auth, pathACL)
+	srv.tokens = tokenStore
+	srv.enableExtract = config.EnableUpload && config.EnableExtract
+	srv.clientConfig = newClientConfig(config, auth)
+	srv.searchIgnore = config.SearchIgnore
+	srv.searchHidden = config.SearchHidden
+	if config.SearchIndex {
+		walkOpts := srv.searchOptions("", 0)
+		srv.index = search.NewIndex(config.RootDir, search.IndexOptions{
+			File:           config.IndexFile,
+			RescanInterval: config.IndexRescan,
+			SkipDir:        walkOpts.Prune,
+			AllowSymlink:   walkOpts.AllowSymlink,
+		})
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
 
 	// Create auth middleware (for list, search, upload, delete)
-	authMW := AuthMiddleware(basicAuth, pathACL)
+	authMW := AuthMiddleware(auth, pathACL)
 	// Path-only middleware for download/zip: no auth required, only path ACL
 	pathOnlyMW := PathACLOnlyMiddleware(pathACL)
+	// Auth-only middleware for endpoints that are not about a path
+	authOnlyMW := AuthOnlyMiddleware(auth)
+	// Admin endpoints additionally require an administrator
+	adminMW := AdminMiddleware(auth)
 
 	// Regular HTTP handlers - API routes must be registered before static file handler
+	mux.HandleFunc("/api/config", srv.HandleConfig)
+	mux.HandleFunc("/api/login", srv.HandleLogin)
+	mux.HandleFunc("/api/logout", srv.HandleLogout)
+	mux.HandleFunc("/api/oidc/login", srv.HandleOIDCLogin)
+	mux.HandleFunc("/api/oidc/callback", srv.HandleOIDCCallback)
 	mux.HandleFunc("/api/list", authMW(http.HandlerFunc(srv.HandleListFiles)).ServeHTTP)
 	mux.HandleFunc("/api/files", authMW(http.HandlerFunc(srv.HandleListFiles)).ServeHTTP) // Alias
 	mux.HandleFunc("/api/search", authMW(http.HandlerFunc(srv.HandleSearch)).ServeHTTP)
+	mux.HandleFunc("/api/du", authMW(http.HandlerFunc(srv.HandleDiskUsage)).ServeHTTP)
+	mux.HandleFunc("/api/duplicates", authMW(http.HandlerFunc(srv.HandleDuplicates)).ServeHTTP)
+	mux.HandleFunc("/api/duplicates/", authMW(http.HandlerFunc(srv.HandleDuplicates)).ServeHTTP)
 	// Download and zip: no auth required (path ACL still applied)
 	mux.HandleFunc("/api/download/", pathOnlyMW(http.HandlerFunc(srv.HandleDownload)).ServeHTTP)
 	mux.HandleFunc("/api/zip/", pathOnlyMW(http.HandlerFunc(srv.HandleZip)).ServeHTTP)
-	
+	mux.HandleFunc("/api/archive/list/", pathOnlyMW(http.HandlerFunc(srv.HandleArchiveList)).ServeHTTP)
+	mux.HandleFunc("/api/archive/get/", pathOnlyMW(http.HandlerFunc(srv.HandleArchiveGet)).ServeHTTP)
+
+	// ACL trace - only register if enabled, it reveals the rules. Without
+	// authentication nobody can be told apart from an administrator.
+	if config.ACLTrace && auth.Enabled() {
+		mux.HandleFunc("/api/admin/acl", adminMW(http.HandlerFunc(srv.HandleACLTrace)).ServeHTTP)
+	} else if config.ACLTrace {
+		fmt.Printf("Warning: --acl-trace requires authentication, /api/admin/acl is disabled\n")
+		mux.HandleFunc("/api/admin/acl", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "ACL trace requires authentication to be configured.", http.StatusForbidden)
+		})
+	} else {
+		mux.HandleFunc("/api/admin/acl", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "ACL trace is disabled. Use --acl-trace flag to enable.", http.StatusForbidden)
+		})
+	}
+
+	// API token handlers - only register if a token file is configured
+	if tokenStore != nil {
+		mux.HandleFunc("/api/tokens", authOnlyMW(http.HandlerFunc(srv.HandleTokens)).ServeHTTP)
+		mux.HandleFunc("/api/tokens/", authOnlyMW(http.HandlerFunc(srv.HandleTokens)).ServeHTTP)
+	} else {
+		mux.HandleFunc("/api/tokens", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "API tokens are disabled. Use --token-file flag to enable.", http.StatusForbidden)
+		})
+		mux.HandleFunc("/api/tokens/", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "API tokens are disabled. Use --token-file flag to enable.", http.StatusForbidden)
+		})
+	}
+
 	// Upload handlers - only register if upload is enabled
 	if config.EnableUpload {
 		mux.HandleFunc("/api/upload", authMW(http.HandlerFunc(srv.HandleUpload)).ServeHTTP)
@@ -99,7 +263,16 @@ func NewHTTPServer(config *Config) (*HTTPServer, error) {
 			http.Error(w, "File upload is disabled. Use --upload flag to enable.", http.StatusForbidden)
 		})
 	}
-	
+
+	// Extract handler - requires both upload and extract to be enabled
+	if srv.enableExtract {
+		mux.HandleFunc("/api/extract", authMW(http.HandlerFunc(srv.HandleExtract)).ServeHTTP)
+	} else {
+		mux.HandleFunc("/api/extract", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Archive extraction is disabled. Use --upload and --extract flags to enable.", http.StatusForbidden)
+		})
+	}
+
 	// Delete handlers - only register if delete is enabled
 	if config.EnableDelete {
 		mux.HandleFunc("/api/delete/", authMW(http.HandlerFunc(srv.HandleDelete)).ServeHTTP)
@@ -110,161 +283,312 @@ func NewHTTPServer(config *Config) (*HTTPServer, error) {
 		})
 	}
 
-	// Serve static web files if WebDir is set
+	// Serve the web frontend: the embedded build, or WebDir if set (for
+	// development). Without either, the built-in listing is served.
 	// Note: This must be registered AFTER API routes to avoid conflicts
 	// Static files should NOT go through auth middleware as they are frontend resources
-	if config.WebDir != "" {
-		webDir, err := filepath.Abs(config.WebDir)
-		if err != nil {
-			fmt.Printf("Warning: Failed to resolve web directory path '%s': %v\n", config.WebDir, err)
-		} else {
-			fmt.Printf("Web directory: %s\n", webDir)
-			if info, err := os.Stat(webDir); err != nil {
-				fmt.Printf("Warning: Web directory does not exist or is not accessible: %s, error: %v\n", webDir, err)
-			} else if !info.IsDir() {
-				fmt.Printf("Warning: Web directory path is not a directory: %s\n", webDir)
-			} else {
-				// Use a custom handler to skip API paths and handle index.html
-				// This handler is NOT wrapped with authMW to allow public access to frontend
-				mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-						// Skip API routes
-						if strings.HasPrefix(r.URL.Path, "/api/") {
-							http.NotFound(w, r)
-							return
-						}
-						
-						// If path is root, serve index.html
-						path := r.URL.Path
-						if path == "/" {
-							indexPath := filepath.Join(webDir, "index.html")
-							fmt.Printf("Serving root path, checking index.html at: %s\n", indexPath)
-							if _, err := os.Stat(indexPath); err == nil {
-								fmt.Printf("Found index.html, serving...\n")
-								serveIndexHTML(w, r, indexPath, config.BaseURL)
-								return
-							} else {
-								fmt.Printf("index.html not found at %s, error: %v\n", indexPath, err)
-							}
-						}
-						
-						// For other paths, serve files directly
-						// Remove leading slash and join with webDir
-						requestPath := strings.TrimPrefix(path, "/")
-						if requestPath == "" {
-							requestPath = "index.html"
-						}
-						filePath := filepath.Join(webDir, requestPath)
-						
-						fmt.Printf("Checking file: %s\n", filePath)
-						
-						// Check if file exists
-						if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
-							// If it's index.html, inject config
-							if requestPath == "index.html" || strings.HasSuffix(requestPath, "/index.html") {
-								serveIndexHTML(w, r, filePath, config.BaseURL)
-								return
-							}
-							fmt.Printf("Found file, serving: %s\n", filePath)
-							http.ServeFile(w, r, filePath)
-							return
-						}
-						
-						// If not found, try index.html for directory requests
-						if strings.HasSuffix(path, "/") {
-							indexPath := filepath.Join(webDir, "index.html")
-							if _, err := os.Stat(indexPath); err == nil {
-								serveIndexHTML(w, r, indexPath, config.BaseURL)
-								return
-							}
-						}
-						
-					// Otherwise return 404
-					fmt.Printf("404: File not found for path: %s, webDir: %s, checked filePath: %s\n", r.URL.Path, webDir, filePath)
-					http.NotFound(w, r)
-				})
-				fmt.Printf("Static file handler registered for web directory: %s\n", webDir)
+	ui, err := newFrontend(config, configScript(srv.clientConfig))
+	if err != nil {
+		return nil, err
+	}
+	if ui != nil {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			// Skip API routes
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.NotFound(w, r)
+				return
 			}
-		}
+
+			// Command line clients get the built-in listing instead of the frontend
+			if wantsBuiltinListing(r) {
+				srv.HandleBrowse(w, r)
+				return
+			}
+
+			if ui.ServeFile(w, r, strings.TrimPrefix(r.URL.Path, "/")) {
+				return
+			}
+
+			// History API fallback: client-side routes are handled by the frontend
+			if webui.IsClientRoute(r) && ui.ServeIndex(w, r) {
+				return
+			}
+
+			// Otherwise serve the file tree (404 if the path does not exist there either)
+			srv.HandleBrowse(w, r)
+		})
 	} else {
-		fmt.Printf("Web directory not configured (WebDir is empty)\n")
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.NotFound(w, r)
+				return
+			}
+			srv.HandleBrowse(w, r)
+		})
 	}
 
 	// Apply middleware
-	handler := CORSMiddleware(LoggingMiddleware(mux))
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	accessLog, err := accesslog.New(accesslog.Options{
+		Format:  config.LogFormat,
+		Output:  os.Stdout,
+		File:    config.AccessLog,
+		MaxSize: config.AccessLogMaxSize,
+		Backups: config.AccessLogBackups,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logMW := LoggingMiddleware(accessLog, proxies)
+	handler := logMW(CORSMiddleware(mux))
+
+	hs := &HTTPServer{
+		config:    config,
+		accessLog: accessLog,
+		index:     srv.index,
+		dupes:     srv.dupes,
+	}
+	if config.HTTPS {
+		if config.SelfSigned {
+			if err := useSelfSigned(config); err != nil {
+				return nil, fmt.Errorf("failed to set up the self-signed certificate: %w", err)
+			}
+		}
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("cert and key files are required for HTTPS")
+		}
+		hs.tlsCert, err = certs.NewReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.SelfSigned {
+			dir, hosts := config.SelfSignedDir, config.TLSHosts
+			hs.tlsCert.RenewWith(func() error {
+				ss, err := certs.EnsureSelfSigned(dir, hosts)
+				if err == nil && ss.CAGenerated {
+					fmt.Printf("Generated a new self-signed CA: %s (SHA-256 %s), clients need to trust it again\n", ss.CAFile, ss.CAFingerprint)
+				}
+				return err
+			})
+		}
+		tlsHandler := handler
+		redirect := config.HTTPSRedirect
+		if auth.certs != nil && auth.certs.mode == tls.RequireAndVerifyClientCert {
+			// Plain HTTP would bypass the required client certificates
+			redirect = true
+		}
+		if redirect && config.HSTSMaxAge > 0 {
+			tlsHandler = HSTSMiddleware(config.HSTSMaxAge)(handler)
+		}
+		hs.tlsServer = newServer(config.HTTPSPort, tlsHandler)
+		hs.tlsServer.TLSConfig = &tls.Config{GetCertificate: hs.tlsCert.GetCertificate}
+		if auth.certs != nil {
+			auth.certs.Apply(hs.tlsServer.TLSConfig)
+		}
+		if redirect {
+			handler = logMW(redirectToHTTPS(config.HTTPSPort))
+			hs.redirect = true
+		}
+	}
+	if !config.HTTPS || config.Port > 0 {
+		hs.server = newServer(config.Port, handler)
+	}
+	return hs, nil
+}
+
+// useSelfSigned points the config at the generated certificate, creating it
+// if needed, and fills in the directory and hosts it is renewed with
+func useSelfSigned(config *Config) error {
+	if config.CertFile != "" || config.KeyFile != "" {
+		return fmt.Errorf("--self-signed cannot be combined with --cert and --key")
+	}
+	dir, hosts := config.SelfSignedDir, config.TLSHosts
+	if dir == "" {
+		dir = certs.DefaultDir()
+	}
+	if len(hosts) == 0 {
+		hosts = certs.DefaultHosts()
+	}
+	ss, err := certs.EnsureSelfSigned(dir, hosts)
+	if err != nil {
+		return err
+	}
+	if ss.CAGenerated {
+		fmt.Printf("Generated self-signed CA, limited to %s\n", strings.Join(hosts, ", "))
+	}
+	if ss.Generated {
+		fmt.Printf("Generated self-signed certificate for %s\n", strings.Join(hosts, ", "))
+	}
+	fmt.Printf("Self-signed CA: %s (SHA-256 %s), trust it in clients to avoid warnings\n", ss.CAFile, ss.CAFingerprint)
+	config.CertFile, config.KeyFile = ss.CertFile, ss.KeyFile
+	config.SelfSignedDir, config.TLSHosts = dir, hosts
+	return nil
+}
 
-	port := config.Port
-	if config.HTTPS && config.HTTPSPort > 0 {
-		port = config.HTTPSPort
+// ReloadCertificate reloads the HTTPS certificate and key files. New
+// connections use the new certificate, established ones are not affected.
+func (hs *HTTPServer) ReloadCertificate() error {
+	if hs.tlsCert == nil {
+		return nil
 	}
+	return hs.tlsCert.Reload()
+}
+
+// ReopenLogs reopens the access log file after it was rotated externally
+func (hs *HTTPServer) ReopenLogs() error {
+	return hs.accessLog.Reopen()
+}
 
-	httpServer := &http.Server{
+// newServer returns a server for handler listening on port
+func newServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
 		Handler:      handler,
 		ReadTimeout:  0,                 // 0 means no timeout - important for large file uploads
 		WriteTimeout: 0,                 // 0 means no timeout - important for large file downloads
 		IdleTimeout:  300 * time.Second, // Increased for long connections (5 minutes)
 	}
+}
 
-	return &HTTPServer{
-		config: config,
-		server: httpServer,
-	}, nil
+// redirectToHTTPS redirects every request to the same URL on the HTTPS port
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// No port, but an IPv6 literal is still bracketed
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+
+		// 308 keeps the method and body of uploads
+		status := http.StatusPermanentRedirect
+		if r.Method == "GET" || r.Method == "HEAD" {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP and HTTPS servers and returns when both stopped.
+// If one of them fails, the other is stopped too.
 func (hs *HTTPServer) Start() error {
-	addr := hs.server.Addr
-	if hs.config.HTTPS {
-		if hs.config.CertFile == "" || hs.config.KeyFile == "" {
-			return fmt.Errorf("cert and key files are required for HTTPS")
+	type listener struct {
+		server *http.Server
+		ln     net.Listener
+		tls    bool
+	}
+	var listeners []listener
+	for _, s := range []*http.Server{hs.server, hs.tlsServer} {
+		if s == nil {
+			continue
+		}
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.ln.Close()
+			}
+			return err
 		}
-		fmt.Printf("Starting HTTPS server on %s\n", addr)
-		return hs.server.ListenAndServeTLS(hs.config.CertFile, hs.config.KeyFile)
+		listeners = append(listeners, listener{server: s, ln: ln, tls: s == hs.tlsServer})
+	}
+
+	if hs.index != nil {
+		hs.index.Start()
 	}
 
-	fmt.Printf("Starting HTTP server on %s\n", addr)
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		switch {
+		case l.tls:
+			fmt.Printf("Starting HTTPS server on %s\n", l.server.Addr)
+		case hs.redirect:
+			fmt.Printf("Starting HTTP server on %s (redirecting to HTTPS)\n", l.server.Addr)
+		default:
+			fmt.Printf("Starting HTTP server on %s\n", l.server.Addr)
+		}
+		go func() {
+			if l.tls {
+				errc <- l.server.ServeTLS(l.ln, "", "") // Certificates come from tlsCert
+			} else {
+				errc <- l.server.Serve(l.ln)
+			}
+		}()
+	}
 	fmt.Printf("Root directory: %s\n", hs.config.RootDir)
-	return hs.server.ListenAndServe()
+
+	var err error
+	for range listeners {
+		if e := <-errc; e != nil && !errors.Is(e, http.ErrServerClosed) && err == nil {
+			err = e
+			for _, l := range listeners {
+				l.server.Close()
+			}
+		}
+	}
+	if err == nil {
+		err = http.ErrServerClosed
+	}
+	return err
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the servers, waiting for active requests
+// until ctx is done
 func (hs *HTTPServer) Shutdown(ctx context.Context) error {
-	return hs.server.Shutdown(ctx)
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, s := range []*http.Server{hs.server, hs.tlsServer} {
+		if s == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	hs.dupes.Close()
+	if hs.index != nil {
+		errs = append(errs, hs.index.Close())
+	}
+	errs = append(errs, hs.accessLog.Close())
+	return errors.Join(errs...)
 }
 
-// serveIndexHTML serves index.html with injected configuration script
-func serveIndexHTML(w http.ResponseWriter, r *http.Request, indexPath string, baseURL string) {
-	// Read index.html file
-	content, err := os.ReadFile(indexPath)
-	if err != nil {
-		http.Error(w, "Failed to read index.html", http.StatusInternalServerError)
-		return
-	}
-
-	htmlContent := string(content)
-
-	// If BaseURL is set, inject configuration script before </head>
-	if baseURL != "" {
-		// Remove trailing slash if present
-		baseURL = strings.TrimSuffix(baseURL, "/")
-		configScript := fmt.Sprintf(`<script>window.__GOHTTPSERVER_CONFIG__={baseURL:"%s"};</script>`, baseURL)
-		
-		// Try to inject before </head>
-		if strings.Contains(htmlContent, "</head>") {
-			htmlContent = strings.Replace(htmlContent, "</head>", configScript+"</head>", 1)
-		} else if strings.Contains(htmlContent, "<body>") {
-			// Fallback: inject before <body> if </head> not found
-			htmlContent = strings.Replace(htmlContent, "<body>", configScript+"<body>", 1)
+// newFrontend returns the handler for the web frontend, or nil if there is
+// none. A usable WebDir takes precedence over the embedded build.
+func newFrontend(config *Config, script []byte) (*webui.Handler, error) {
+	if config.WebDir != "" {
+		webDir, err := filepath.Abs(config.WebDir)
+		if err != nil {
+			fmt.Printf("Warning: Failed to resolve web directory path '%s': %v\n", config.WebDir, err)
+		} else if info, err := os.Stat(webDir); err != nil {
+			fmt.Printf("Warning: Web directory does not exist or is not accessible: %s, error: %v\n", webDir, err)
+		} else if !info.IsDir() {
+			fmt.Printf("Warning: Web directory path is not a directory: %s\n", webDir)
 		} else {
-			// Last resort: prepend to content
-			htmlContent = configScript + htmlContent
+			fmt.Printf("Web directory: %s\n", webDir)
+			return webui.New(os.DirFS(webDir), script, false)
 		}
 	}
 
-	// Set content type
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(htmlContent))
+	if embedded := webui.Embedded(); embedded != nil {
+		fmt.Printf("Serving embedded web frontend\n")
+		return webui.New(embedded, script, true)
+	}
+
+	fmt.Printf("No web frontend embedded or configured, serving built-in listing\n")
+	return nil, nil
 }
 
 // splitAuth splits auth string into username and password
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gohttpserver/internal/search"
+)
+
+// FileEntry is a single entry of a directory listing
+type FileEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	IsDir      bool      `json:"is_dir"` // For symlinks, whether the target is a directory
+	Size       int64     `json:"size"`   // 0 for directories, see /api/du for their usage
+	Mode       string    `json:"mode"`   // Permission bits, e.g. "-rw-r--r--"
+	MimeType   string    `json:"mime_type,omitempty"`
+	IsSymlink  bool      `json:"is_symlink,omitempty"`
+	LinkTarget string    `json:"link_target,omitempty"`
+	ModTime    string    `json:"mod_time"` // Local time, "2006-01-02 15:04:05"
+	Modified   string    `json:"modified"` // RFC 3339
+	Extra      EntryData `json:"extra,omitempty"`
+
+	modTime time.Time
+}
+
+// EntryData holds the optional extras requested with extras=
+type EntryData map[string]interface{}
+
+// entryExtra computes an optional extra for an entry. It returns nil if the
+// extra does not apply to the entry.
+type entryExtra func(fullPath string, entry *FileEntry) interface{}
+
+// listingExtras are the extras that can be requested with extras=a,b
+var listingExtras = map[string]entryExtra{
+	// children is the number of entries in a directory
+	"children": func(fullPath string, entry *FileEntry) interface{} {
+		if !entry.IsDir {
+			return nil
+		}
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			return nil
+		}
+		return len(names)
+	},
+}
+
+// Sort keys for directory listings
+const (
+	listSortName  = "name"
+	listSortSize  = "size"
+	listSortMtime = "mtime"
+	listSortType  = "type"
+)
+
+// listOptions are the query parameters of a directory listing
+type listOptions struct {
+	sort      string
+	desc      bool
+	dirsFirst bool
+	hidden    bool
+	filter    *search.Query
+	offset    int
+	limit     int // 0 for no limit
+	extras    []string
+}
+
+// parseListOptions validates the listing parameters
+func parseListOptions(params url.Values) (*listOptions, error) {
+	opts := &listOptions{
+		sort:      listSortName,
+		dirsFirst: params.Get("dirs_first") != "false",
+		hidden:    params.Get("hidden") != "false",
+	}
+
+	switch key := params.Get("sort"); key {
+	case "":
+	case listSortName, listSortSize, listSortMtime, listSortType:
+		opts.sort = key
+	default:
+		return nil, fmt.Errorf("invalid sort %q (expected name, size, mtime or type)", key)
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %q (expected asc or desc)", order)
+	}
+
+	if f := params.Get("filter"); f != "" {
+		q, err := search.ParseQuery(f)
+		if err != nil {
+			return nil, err
+		}
+		opts.filter = q
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &opts.offset}, {"limit", &opts.limit}} {
+		if v := params.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid parameter '%s'", p.name)
+			}
+			*p.dst = n
+		}
+	}
+
+	if e := params.Get("extras"); e != "" {
+		for _, name := range strings.Split(e, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := listingExtras[name]; !ok {
+				return nil, fmt.Errorf("unknown extra %q", name)
+			}
+			opts.extras = append(opts.extras, name)
+		}
+	}
+	return opts, nil
+}
+
+// listDirectory returns the page of entries of cleanPath selected by opts,
+// and the number of entries before paging. Entries denied by the path ACL
+// are left out.
+func (s *Server) listDirectory(cleanPath string, opts *listOptions) ([]*FileEntry, int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.rootDir, cleanPath))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	files := make([]*FileEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !opts.hidden && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		entryPath := filepath.Join(cleanPath, entry.Name())
+		if !s.pathACL.IsAllowed(entryPath) {
+			continue
+		}
+
+		// Symlinks that may not be followed, or that lead to a denied path,
+		// are not shown at all
+		if entry.Type()&os.ModeSymlink != 0 && !s.pathAllowed(entryPath) {
+			continue
+		}
+
+		entryInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		fileEntry := s.newFileEntry(entryPath, entryInfo)
+		if opts.filter != nil && !opts.filter.Match(entryPath, fileEntry.IsDir, fileEntry.Size, fileEntry.modTime) {
+			continue
+		}
+		files = append(files, fileEntry)
+	}
+
+	sortEntries(files, opts)
+
+	total := len(files)
+	start := min(opts.offset, total)
+	end := total
+	if opts.limit > 0 && start+opts.limit < total {
+		end = start + opts.limit
+	}
+	files = files[start:end]
+
+	// Extras are only computed for the entries being returned
+	for _, entry := range files {
+		for _, name := range opts.extras {
+			if v := listingExtras[name](filepath.Join(s.rootDir, entry.Path), entry); v != nil {
+				if entry.Extra == nil {
+					entry.Extra = EntryData{}
+				}
+				entry.Extra[name] = v
+			}
+		}
+	}
+	return files, total, nil
+}
+
+// newFileEntry describes a directory entry. entryPath is relative to the root.
+func (s *Server) newFileEntry(entryPath string, info os.FileInfo) *FileEntry {
+	entry := &FileEntry{
+		Name:     info.Name(),
+		Path:     entryPath,
+		IsDir:    info.IsDir(),
+		Size:     info.Size(),
+		Mode:     info.Mode().Perm().String(),
+		ModTime:  info.ModTime().Format("2006-01-02 15:04:05"),
+		Modified: info.ModTime().Format(time.RFC3339),
+		modTime:  info.ModTime(),
+	}
+
+	fullPath := filepath.Join(s.rootDir, entryPath)
+	if info.Mode()&os.ModeSymlink != 0 {
+		entry.IsSymlink = true
+		entry.LinkTarget = s.linkTarget(fullPath)
+		if target, err := os.Stat(fullPath); err == nil {
+			entry.IsDir = target.IsDir()
+			entry.Size = target.Size()
+		}
+	}
+
+	if entry.IsDir {
+		entry.Size = 0
+		entry.Mode = "d" + entry.Mode[1:]
+	} else {
+		entry.MimeType = mime.TypeByExtension(filepath.Ext(entry.Name))
+	}
+	return entry
+}
+
+// linkTarget returns the target of a symlink. Absolute targets are shown
+// relative to the root, and targets outside the root are hidden, so the
+// server's directory layout is not revealed.
+func (s *Server) linkTarget(fullPath string) string {
+	target, err := os.Readlink(fullPath)
+	if err != nil || !filepath.IsAbs(target) {
+		return target
+	}
+	rel, err := filepath.Rel(s.rootDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// sortEntries orders a listing, keeping directories first unless disabled.
+// Ties are broken by name so the order is stable across requests.
+func sortEntries(entries []*FileEntry, opts *listOptions) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if opts.dirsFirst && a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+
+		c := 0
+		switch opts.sort {
+		case listSortSize:
+			c = cmp.Compare(a.Size, b.Size)
+		case listSortMtime:
+			c = a.modTime.Compare(b.modTime)
+		case listSortType:
+			c = strings.Compare(strings.ToLower(filepath.Ext(a.Name)), strings.ToLower(filepath.Ext(b.Name)))
+		}
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if opts.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
@@ -1,13 +1,26 @@
 package server
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
-// LoggingMiddleware logs HTTP requests
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Simple logging - can be enhanced with structured logging
-		next.ServeHTTP(w, r)
-	})
+	"gohttpserver/internal/accesslog"
+	"gohttpserver/internal/tokens"
+)
+
+// LoggingMiddleware writes every request to the access log and tags it
+// with a request ID, taken from trusted proxies or generated
+func LoggingMiddleware(logger *accesslog.Logger, proxies trustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if logger == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logRequest(logger, proxies, next, w, r)
+		})
+	}
 }
 
 // CORSMiddleware adds CORS headers
@@ -15,7 +28,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Requested-With")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusNoContent)
@@ -26,35 +39,101 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// AuthMiddleware wraps handlers with authentication and path ACL
-func AuthMiddleware(basicAuth *BasicAuth, pathACL *PathACL) func(http.Handler) http.Handler {
+// HSTSMiddleware tells browsers to only use HTTPS for maxAge
+func HSTSMiddleware(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AuthMiddleware wraps handlers with authentication and path ACL. Requests
+// authenticated by a session cookie must carry the session's CSRF token
+// unless their method is safe. Browsers send client certificates with
+// cross-site requests too, so unsafe requests authenticated by one must
+// carry X-Requested-With, which cross-site requests cannot.
+func AuthMiddleware(auth *Authenticator, pathACL *PathACL) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Check path ACL first
-			path := r.URL.Path
-			if !pathACL.IsAllowed(path) {
+			if path, ok := requestPath(r); ok && !pathACL.IsAllowed(path) {
 				http.Error(w, "Access denied", http.StatusForbidden)
 				return
 			}
 
-			// Check Basic Auth
-			if !basicAuth.Authenticate(r) {
-				basicAuth.RequireAuth(w)
+			authenticate(auth, next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// AuthOnlyMiddleware wraps handlers with authentication only (no path ACL).
+// Used for endpoints that do not serve a path.
+func AuthOnlyMiddleware(auth *Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return authenticate(auth, next)
+	}
+}
+
+// AdminMiddleware wraps admin endpoints: the caller must be authenticated
+// and an administrator (see Identity.IsAdmin)
+func AdminMiddleware(auth *Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return authenticate(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := IdentityFromRequest(r); id == nil || !id.IsAdmin() {
+				http.Error(w, "Administrator access required", http.StatusForbidden)
 				return
 			}
-
 			next.ServeHTTP(w, r)
-		})
+		}))
 	}
 }
 
+// authenticate checks the caller and the CSRF token of session requests,
+// and passes the caller's Identity on to next
+func authenticate(auth *Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.Identify(r)
+		if !ok {
+			auth.RequireAuth(w, r)
+			return
+		}
+		scope := requiredScope(r)
+		// Routes needing more than read change state whatever the method,
+		// so they are never exempt from the cross-site checks
+		changesState := !isSafeMethod(r.Method) || scope != tokens.ScopeRead
+		if id.Session != nil && changesState && !validCSRF(r, id.Session) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if id.Method == AuthMethodCertificate && changesState && r.Header.Get("X-Requested-With") == "" {
+			http.Error(w, "Missing X-Requested-With header", http.StatusForbidden)
+			return
+		}
+		if !id.HasScope(scope) {
+			if id.Token != nil {
+				http.Error(w, fmt.Sprintf("Token lacks the %s scope", scope), http.StatusForbidden)
+			} else {
+				http.Error(w, fmt.Sprintf("Your groups do not grant the %s scope", scope), http.StatusForbidden)
+			}
+			return
+		}
+		if path, ok := requestPath(r); ok && !id.AllowsPath(path) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withIdentity(r, id))
+	})
+}
+
 // PathACLOnlyMiddleware wraps handlers with path ACL only (no auth required).
 // Used for download and zip so file download is not subject to auth.
 func PathACLOnlyMiddleware(pathACL *PathACL) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			path := r.URL.Path
-			if !pathACL.IsAllowed(path) {
+			if path, ok := requestPath(r); ok && !pathACL.IsAllowed(path) {
 				http.Error(w, "Access denied", http.StatusForbidden)
 				return
 			}
@@ -62,3 +141,46 @@ func PathACLOnlyMiddleware(pathACL *PathACL) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// pathRoutes are the API routes that take the path they serve from the URL,
+// after the route prefix
+var pathRoutes = []string{
+	"/api/download/",
+	"/api/zip/",
+	"/api/archive/list/",
+	"/api/archive/get/",
+	"/api/upload/",
+	"/api/delete/",
+}
+
+// scopeRoutes are the API routes that need more than the read scope
+var scopeRoutes = []struct {
+	prefix string
+	scope  string
+}{
+	{"/api/upload", tokens.ScopeUpload},
+	{"/api/extract", tokens.ScopeUpload},
+	{"/api/delete/", tokens.ScopeDelete},
+}
+
+// requiredScope returns the scope a request needs
+func requiredScope(r *http.Request) string {
+	for _, route := range scopeRoutes {
+		if strings.HasPrefix(r.URL.Path, route.prefix) {
+			return route.scope
+		}
+	}
+	return tokens.ScopeRead
+}
+
+// requestPath returns the path a request addresses, to check it against the
+// path ACL before the handler runs. Routes taking their paths as parameters
+// are checked by their handlers, for them ok is false.
+func requestPath(r *http.Request) (path string, ok bool) {
+	for _, prefix := range pathRoutes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return "/" + strings.TrimPrefix(r.URL.Path, prefix), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gohttpserver/internal/tokens"
+)
+
+func TestCSRF(t *testing.T) {
+	store, err := tokens.NewStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := store.Create("admin", accountBasic+"admin", tokens.Options{Scopes: tokens.AllScopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(NewBasicAuth("admin", "pw"), NewSessionStore(0))
+	auth.tokens = store
+	sess := auth.sessions.Create(&Identity{User: "admin", Account: accountBasic + "admin", Method: AuthMethodBasic})
+	cookie := auth.sessions.Cookie(httptest.NewRequest("GET", "/", nil), sess)
+
+	acl, err := NewPathACL(nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := AuthMiddleware(auth, acl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	withSession := func(csrf string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.AddCookie(cookie)
+			if csrf != "" {
+				r.Header.Set(CSRFHeader, csrf)
+			}
+		}
+	}
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret) }
+	basic := func(r *http.Request) { r.SetBasicAuth("admin", "pw") }
+
+	for _, tc := range []struct {
+		method, url string
+		setup       func(r *http.Request)
+		want        int
+	}{
+		// Reads need no CSRF token
+		{"GET", "/api/list?path=/", withSession(""), http.StatusOK},
+		{"HEAD", "/api/search?q=a", withSession(""), http.StatusOK},
+
+		// Sessions must send the token with anything that changes state
+		{"POST", "/api/upload/dir", withSession(""), http.StatusForbidden},
+		{"POST", "/api/upload/dir", withSession("wrong"), http.StatusForbidden},
+		{"POST", "/api/upload/dir", withSession(sess.CSRFToken), http.StatusOK},
+		{"DELETE", "/api/delete/a.txt", withSession(""), http.StatusForbidden},
+		{"DELETE", "/api/delete/a.txt", withSession(sess.CSRFToken), http.StatusOK},
+		{"POST", "/api/duplicates?path=/", withSession(""), http.StatusForbidden},
+		{"POST", "/api/duplicates?path=/", withSession(sess.CSRFToken), http.StatusOK},
+
+		// Routes needing the upload scope change state even with GET
+		{"GET", "/api/upload/dir?chunk=1", withSession(""), http.StatusForbidden},
+		{"GET", "/api/extract?path=/a.zip", withSession(""), http.StatusForbidden},
+		{"GET", "/api/extract?path=/a.zip", withSession(sess.CSRFToken), http.StatusOK},
+
+		// Bearer tokens and Basic Auth are not sent by browsers on their own
+		{"POST", "/api/upload/dir", bearer, http.StatusOK},
+		{"DELETE", "/api/delete/a.txt", bearer, http.StatusOK},
+		{"GET", "/api/extract?path=/a.zip", bearer, http.StatusOK},
+		{"POST", "/api/upload/dir", basic, http.StatusOK},
+	} {
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		tc.setup(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d (%s)", tc.method, tc.url, w.Code, tc.want, w.Body)
+		}
+	}
+
+	// Logging out also needs the token, or other sites could end sessions
+	s := &Server{auth: auth}
+	for _, tc := range []struct {
+		csrf string
+		want int
+	}{
+		{"", http.StatusForbidden},
+		{sess.CSRFToken, http.StatusOK},
+	} {
+		r := httptest.NewRequest("POST", "/api/logout", nil)
+		withSession(tc.csrf)(r)
+		w := httptest.NewRecorder()
+		s.HandleLogout(w, r)
+		if w.Code != tc.want {
+			t.Errorf("logout with CSRF token %q: status %d, want %d", tc.csrf, w.Code, tc.want)
+		}
+	}
+	if auth.sessions.Get(withCookie(cookie)) != nil {
+		t.Error("session still valid after logout")
+	}
+}
+
+func withCookie(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	return r
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate modes
+const (
+	ClientCertRequire = "require" // Every TLS connection needs a valid client certificate
+	ClientCertRequest = "request" // Client certificates are optional, other logins still work
+)
+
+// Certificate fields a user name can be taken from
+const (
+	CertUserCN    = "cn"    // Subject common name
+	CertUserEmail = "email" // First email SAN
+	CertUserDNS   = "dns"   // First DNS SAN
+	CertUserURI   = "uri"   // First URI SAN
+	CertUserDN    = "dn"    // Whole subject
+)
+
+// ClientCertAuth verifies client certificates against a CA and the
+// revocation lists, and maps them to users. The subject's organizational
+// units are the user's groups.
+type ClientCertAuth struct {
+	mode      tls.ClientAuthType
+	pool      *x509.CertPool
+	userField string
+
+	mu   sync.Mutex
+	crls []*crlFile
+}
+
+// crlFile is a revocation list, reloaded when the file changes
+type crlFile struct {
+	path    string
+	modTime time.Time
+	lists   []*x509.RevocationList
+}
+
+// NewClientCertAuth loads the client CA certificates and revocation lists
+func NewClientCertAuth(caFile, mode string, crlFiles []string, userField string) (*ClientCertAuth, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	ca := &ClientCertAuth{pool: pool, userField: userField}
+	switch mode {
+	case ClientCertRequire, "":
+		ca.mode = tls.RequireAndVerifyClientCert
+	case ClientCertRequest:
+		ca.mode = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid client certificate mode %q (expected require or request)", mode)
+	}
+	switch userField {
+	case "":
+		ca.userField = CertUserCN
+	case CertUserCN, CertUserEmail, CertUserDNS, CertUserURI, CertUserDN:
+	default:
+		return nil, fmt.Errorf("invalid client certificate user field %q (expected cn, email, dns, uri or dn)", userField)
+	}
+
+	for _, path := range crlFiles {
+		f := &crlFile{path: path}
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+		ca.crls = append(ca.crls, f)
+	}
+	return ca, nil
+}
+
+// Apply configures cfg to ask for and verify client certificates
+func (ca *ClientCertAuth) Apply(cfg *tls.Config) {
+	cfg.ClientAuth = ca.mode
+	cfg.ClientCAs = ca.pool
+	cfg.VerifyPeerCertificate = ca.checkRevocation
+}
+
+// checkRevocation rejects chains containing a revoked certificate. It runs
+// after the chains were verified against the CA.
+func (ca *ClientCertAuth) checkRevocation(_ [][]byte, chains [][]*x509.Certificate) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	for _, f := range ca.crls {
+		if err := f.reload(); err != nil {
+			fmt.Printf("Warning: Failed to reload CRL %s, keeping the previous one: %v\n", f.path, err)
+		}
+	}
+
+	for _, chain := range chains {
+		for i := 0; i+1 < len(chain); i++ {
+			cert, issuer := chain[i], chain[i+1]
+			for _, f := range ca.crls {
+				for _, crl := range f.lists {
+					if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+						continue
+					}
+					for _, revoked := range crl.RevokedCertificateEntries {
+						if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+							return fmt.Errorf("client certificate %s (serial %s) is revoked", cert.Subject, cert.SerialNumber)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// user returns the user name and groups of a verified client certificate
+func (ca *ClientCertAuth) user(cert *x509.Certificate) (string, []string) {
+	var name string
+	switch ca.userField {
+	case CertUserCN:
+		name = cert.Subject.CommonName
+	case CertUserEmail:
+		if len(cert.EmailAddresses) > 0 {
+			name = cert.EmailAddresses[0]
+		}
+	case CertUserDNS:
+		if len(cert.DNSNames) > 0 {
+			name = cert.DNSNames[0]
+		}
+	case CertUserURI:
+		if len(cert.URIs) > 0 {
+			name = cert.URIs[0].String()
+		}
+	case CertUserDN:
+		name = cert.Subject.String()
+	}
+	return name, cert.Subject.OrganizationalUnit
+}
+
+// load reads the file, PEM or DER encoded
+func (f *crlFile) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	var lists []*x509.RevocationList
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "X509 CRL" {
+				continue
+			}
+			crl, err := x509.ParseRevocationList(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("invalid CRL %s: %w", f.path, err)
+			}
+			lists = append(lists, crl)
+		}
+	} else {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return fmt.Errorf("invalid CRL %s: %w", f.path, err)
+		}
+		lists = append(lists, crl)
+	}
+	if len(lists) == 0 {
+		return fmt.Errorf("no CRL found in %s", f.path)
+	}
+	for _, crl := range lists {
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			fmt.Printf("Warning: CRL %s from %s is past its next update (%s)\n", f.path, crl.Issuer, crl.NextUpdate.Format(time.RFC3339))
+		}
+	}
+
+	f.lists, f.modTime = lists, info.ModTime()
+	return nil
+}
+
+// reload loads the file again if it changed
+func (f *crlFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	return f.load()
+}
+
+// certIdentity returns the caller identified by a verified client
+// certificate, or false if there is none
+func (a *Authenticator) certIdentity(state *tls.ConnectionState) (*Identity, bool) {
+	if a.certs == nil || state == nil || len(state.VerifiedChains) == 0 {
+		return nil, false
+	}
+	cert := state.VerifiedChains[0][0]
+	user, groups := a.certs.user(cert)
+	if user == "" {
+		return nil, false
+	}
+	scopes, ok := a.groups.scopes(groups)
+	if !ok {
+		return nil, false
+	}
+	return &Identity{User: user, Account: accountCertificate + cert.Subject.String(), Method: AuthMethodCertificate, Groups: groups, Scopes: scopes}, true
+}
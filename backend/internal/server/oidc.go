@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gohttpserver/internal/oidc"
+)
+
+// oidcStateCookie binds a login to the browser that started it
+const oidcStateCookie = "ghs_oidc_state"
+
+// HandleOIDCLogin sends the browser to the identity provider
+// GET /api/oidc/login?redirect=/path/to/return/to
+func (s *Server) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.auth.oidc == nil {
+		http.Error(w, "OpenID Connect is disabled. Use --oidc-issuer flag to enable.", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	redirectURL := s.auth.oidc.RedirectURL()
+	if redirectURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		redirectURL = scheme + "://" + r.Host + "/api/oidc/callback"
+	}
+
+	authURL, state, err := s.auth.oidc.AuthCodeURL(r.Context(), redirectURL, localRedirect(r.URL.Query().Get("redirect")))
+	if err != nil {
+		fmt.Printf("OIDC login failed: %v\n", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Lax so the cookie comes back with the provider's redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback completes a login started by HandleOIDCLogin and starts
+// a session
+// GET /api/oidc/callback?state=...&code=...
+func (s *Server) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.auth.oidc == nil {
+		http.Error(w, "OpenID Connect is disabled. Use --oidc-issuer flag to enable.", http.StatusForbidden)
+		return
+	}
+
+	params := r.URL.Query()
+	if e := params.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s %s", e, params.Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+
+	state := params.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/", MaxAge: -1})
+
+	user, returnTo, err := s.auth.oidc.Exchange(r.Context(), state, params.Get("code"))
+	if err != nil {
+		fmt.Printf("OIDC login failed: %v\n", err)
+		status := http.StatusUnauthorized
+		if errors.Is(err, oidc.ErrUnknownState) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Login failed", status)
+		return
+	}
+
+	scopes, ok := s.auth.groups.scopes(user.Groups)
+	if !ok {
+		fmt.Printf("OIDC login refused for %s: no permitted group in %v\n", user.Name, user.Groups)
+		http.Error(w, "Your account is not a member of a permitted group", http.StatusForbidden)
+		return
+	}
+
+	sess := s.auth.sessions.Create(&Identity{
+		User:    user.Name,
+		Account: accountOIDC + user.Subject,
+		Groups:  user.Groups,
+		Scopes:  scopes,
+	})
+	http.SetCookie(w, s.auth.sessions.Cookie(r, sess))
+	fmt.Printf("Login: %s (OIDC subject %s)\n", user.Name, user.Subject)
+
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// localRedirect returns target if it is a path on this server, so logins
+// cannot be used to redirect elsewhere
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// readmeNames are the files rendered below a directory listing, in order of
+// preference
+var readmeNames = []string{"README.md", "readme.md", "README.markdown", "README.txt", "README"}
+
+// maxReadmeSize is the largest README that is rendered
+const maxReadmeSize = 256 << 10
+
+// findReadme renders the README of a directory, or returns "" if there is
+// none. Markdown files are rendered, other files shown as preformatted text.
+func (s *Server) findReadme(r *http.Request, cleanPath string) template.HTML {
+	for _, name := range readmeNames {
+		file, err := s.openAllowed(r, filepath.Join(cleanPath, name))
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxReadmeSize {
+			file.Close()
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(file, maxReadmeSize))
+		file.Close()
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(name), ".md") || strings.EqualFold(filepath.Ext(name), ".markdown") {
+			return renderMarkdown(string(content))
+		}
+		return template.HTML("<pre>" + html.EscapeString(string(content)) + "</pre>")
+	}
+	return ""
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdList    = regexp.MustCompile(`^\s*(?:[-*+]|(\d+)[.)])\s+(.*)$`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold    = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalic  = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	// Only plain web links and relative links are rendered as links
+	mdSafeURL = regexp.MustCompile(`^(?i:https?://|mailto:|[^:]*$)`)
+)
+
+// renderMarkdown renders the common subset of Markdown used in READMEs:
+// headings, paragraphs, lists, fenced code blocks, inline code, emphasis and
+// links. All text is escaped, so the output is safe to embed.
+func renderMarkdown(src string) template.HTML {
+	var b strings.Builder
+	var para []string
+	list := "" // Open list element, "ul" or "ol"
+	inCode := false
+
+	flushPara := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				b.WriteString("</code></pre>\n")
+			} else {
+				flushPara()
+				closeList()
+				b.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			b.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			flushPara()
+			closeList()
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(trimmed); m != nil {
+			flushPara()
+			closeList()
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+			continue
+		}
+		if m := mdList.FindStringSubmatch(line); m != nil {
+			flushPara()
+			kind := "ul"
+			if m[1] != "" {
+				kind = "ol"
+			}
+			if list != kind {
+				closeList()
+				b.WriteString("<" + kind + ">\n")
+				list = kind
+			}
+			b.WriteString("<li>" + renderInline(m[2]) + "</li>\n")
+			continue
+		}
+		closeList()
+		para = append(para, trimmed)
+	}
+	if inCode {
+		b.WriteString("</code></pre>\n")
+	}
+	flushPara()
+	closeList()
+	return template.HTML(b.String())
+}
+
+// renderInline escapes text and renders inline code, emphasis and links
+func renderInline(text string) string {
+	var b strings.Builder
+	// Odd segments between backticks are code and rendered verbatim
+	for i, segment := range strings.Split(text, "`") {
+		if i%2 == 1 {
+			b.WriteString("<code>" + html.EscapeString(segment) + "</code>")
+			continue
+		}
+		s := html.EscapeString(segment)
+		s = mdLink.ReplaceAllStringFunc(s, func(m string) string {
+			parts := mdLink.FindStringSubmatch(m)
+			if !mdSafeURL.MatchString(html.UnescapeString(parts[2])) {
+				return parts[1]
+			}
+			return `<a href="` + parts[2] + `">` + parts[1] + `</a>`
+		})
+		s = mdBold.ReplaceAllString(s, "<strong>$1$2</strong>")
+		s = mdItalic.ReplaceAllString(s, "<em>$1</em>")
+		b.WriteString(s)
+	}
+	return b.String()
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session cookie and CSRF header names
+const (
+	SessionCookieName = "ghs_session"
+	CSRFHeader        = "X-CSRF-Token"
+)
+
+// DefaultSessionTTL is how long a login lasts unless configured otherwise
+const DefaultSessionTTL = 24 * time.Hour
+
+// Session is a login made through /api/login or OpenID Connect
+type Session struct {
+	ID        string
+	User      string
+	Account   string   // See Identity.Account
+	Groups    []string // Groups reported by the directory or identity provider
+	Scopes    []string // Scopes granted, nil for all
+	CSRFToken string   // Required in CSRFHeader for state-changing requests
+	Created   time.Time
+	Expires   time.Time
+}
+
+// SessionStore keeps the active sessions in memory. The cookie carries the
+// session ID signed with a key generated at startup, so cookies cannot be
+// forged and restarting the server logs everybody out.
+type SessionStore struct {
+	key []byte
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates a session store. Sessions expire after ttl.
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &SessionStore{
+		key:      key,
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create starts a session for the caller id, keeping its user, account,
+// groups and scopes
+func (ss *SessionStore) Create(id *Identity) *Session {
+	now := time.Now()
+	sess := &Session{
+		ID:        randomToken(32),
+		User:      id.User,
+		Account:   id.Account,
+		Groups:    id.Groups,
+		Scopes:    id.Scopes,
+		CSRFToken: randomToken(32),
+		Created:   now,
+		Expires:   now.Add(ss.ttl),
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.prune(now)
+	ss.sessions[sess.ID] = sess
+	return sess
+}
+
+// Get returns the session of the request's cookie, or nil if there is no
+// valid one
+func (ss *SessionStore) Get(r *http.Request) *Session {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil
+	}
+	id, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(ss.sign(id))) {
+		return nil
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sess := ss.sessions[id]
+	if sess == nil {
+		return nil
+	}
+	if time.Now().After(sess.Expires) {
+		delete(ss.sessions, id)
+		return nil
+	}
+	return sess
+}
+
+// Delete ends a session
+func (ss *SessionStore) Delete(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.sessions, id)
+}
+
+// Cookie returns the cookie carrying sess. Secure is set for requests
+// made over TLS.
+func (ss *SessionStore) Cookie(r *http.Request, sess *Session) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    sess.ID + "." + ss.sign(sess.ID),
+		Path:     "/",
+		Expires:  sess.Expires,
+		MaxAge:   int(time.Until(sess.Expires).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ClearCookie returns a cookie that removes the session cookie
+func (ss *SessionStore) ClearCookie(r *http.Request) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (ss *SessionStore) sign(id string) string {
+	mac := hmac.New(sha256.New, ss.key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// prune drops expired sessions. ss.mu must be held.
+func (ss *SessionStore) prune(now time.Time) {
+	for id, sess := range ss.sessions {
+		if now.After(sess.Expires) {
+			delete(ss.sessions, id)
+		}
+	}
+}
+
+// validCSRF reports whether the request carries the session's CSRF token
+func validCSRF(r *http.Request, sess *Session) bool {
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) == 1
+}
+
+// isSafeMethod reports whether a request method does not change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loginRequest is the body of /api/login, as JSON or a form
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandleLogin checks a username and password and starts a session
+// POST /api/login {"username": "...", "password": "..."}
+func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.auth.PasswordEnabled() {
+		http.Error(w, "Password login is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	var req loginRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Username, req.Password = r.PostFormValue("username"), r.PostFormValue("password")
+	}
+
+	id, ok := s.auth.Login(req.Username, req.Password)
+	if !ok {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	sess := s.auth.sessions.Create(id)
+	http.SetCookie(w, s.auth.sessions.Cookie(r, sess))
+	fmt.Printf("Login: %s\n", id.User)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"user":       sess.User,
+		"expires":    sess.Expires.Format(time.RFC3339),
+		"csrf_token": sess.CSRFToken,
+	})
+}
+
+// HandleLogout ends the caller's session
+// POST /api/logout
+func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if sess := s.auth.sessions.Get(r); sess != nil {
+		if !validCSRF(r, sess) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		s.auth.sessions.Delete(sess.ID)
+	}
+	http.SetCookie(w, s.auth.sessions.ClearCookie(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Streaming response formats for search results
+const (
+	streamNDJSON = "application/x-ndjson"
+	streamSSE    = "text/event-stream"
+)
+
+// streamFormat returns the streaming format requested in the Accept header,
+// or "" if the client wants a regular JSON response
+func streamFormat(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case streamNDJSON, streamSSE:
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// eventStream writes typed events as NDJSON lines ({"type": ..., "data": ...})
+// or as Server-Sent Events, flushing after every event
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	format  string
+}
+
+func newEventStream(w http.ResponseWriter, format string) *eventStream {
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell reverse proxies such as nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &eventStream{w: w, flusher: flusher, format: format}
+}
+
+// send writes a single event. Errors mean the client has gone away.
+func (es *eventStream) send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if es.format == streamSSE {
+		_, err = fmt.Fprintf(es.w, "event: %s\ndata: %s\n\n", event, payload)
+	} else {
+		_, err = fmt.Fprintf(es.w, "{\"type\":%q,\"data\":%s}\n", event, payload)
+	}
+	if err != nil {
+		return err
+	}
+
+	if es.flusher != nil {
+		es.flusher.Flush()
+	}
+	return nil
+}
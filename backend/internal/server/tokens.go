@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gohttpserver/internal/tokens"
+)
+
+// createTokenRequest is the body of POST /api/tokens
+type createTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`     // Default read
+	Paths     []string `json:"paths"`      // Default everywhere
+	ExpiresIn string   `json:"expires_in"` // Go duration, e.g. "720h"; empty for no expiry
+}
+
+// HandleTokens manages the caller's personal API tokens.
+// GET /api/tokens lists them, POST /api/tokens creates one and returns it
+// (the only time the token is shown), DELETE /api/tokens/{id} revokes one.
+// Tokens cannot be used to manage tokens.
+func (s *Server) HandleTokens(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.Enabled() {
+		http.Error(w, "Authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	id := IdentityFromRequest(r)
+	if id == nil || id.Token != nil {
+		http.Error(w, "API tokens cannot manage tokens", http.StatusForbidden)
+		return
+	}
+
+	tokenID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens"), "/")
+	switch {
+	case tokenID == "" && r.Method == "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tokens": s.tokens.List(id.Account),
+		})
+
+	case tokenID == "" && r.Method == "POST":
+		s.createToken(w, r, id)
+
+	case tokenID != "" && r.Method == "DELETE":
+		err := s.tokens.Revoke(id.Account, tokenID)
+		if errors.Is(err, tokens.ErrNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to revoke token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("Token revoked: %s (user %s)\n", tokenID, id.User)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request, id *Identity) {
+	var req createTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	opts := tokens.Options{Name: req.Name, Scopes: req.Scopes, Paths: req.Paths}
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "Invalid parameter 'expires_in'", http.StatusBadRequest)
+			return
+		}
+		opts.TTL = ttl
+	}
+	// Check the scopes the token will actually get, defaults included
+	scopes, err := tokens.NormalizeScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, scope := range scopes {
+		if !id.HasScope(scope) {
+			http.Error(w, fmt.Sprintf("Cannot grant the %s scope", scope), http.StatusForbidden)
+			return
+		}
+	}
+	opts.Scopes = scopes
+
+	secret, tok, err := s.tokens.Create(id.User, id.Account, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Token created: %s %q (user %s, scopes %s)\n", tok.ID, tok.Name, tok.User, strings.Join(tok.Scopes, ","))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token": secret,
+		"info":  tok,
+	})
+}
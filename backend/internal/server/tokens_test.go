@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gohttpserver/internal/tokens"
+)
+
+// tokenServer is a server with --auth admin:pw and API tokens enabled
+func tokenServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	store, err := tokens.NewStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(NewBasicAuth("admin", "pw"), NewSessionStore(0))
+	auth.tokens = store
+	acl, err := NewPathACL(nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(t.TempDir(), auth, acl)
+	s.tokens = store
+	return s, AuthOnlyMiddleware(auth)(http.HandlerFunc(s.HandleTokens))
+}
+
+func TestHandleTokens(t *testing.T) {
+	s, h := tokenServer(t)
+	reader := s.auth.sessions.Create(&Identity{User: "bob", Account: accountLDAP + "cn=bob", Scopes: []string{tokens.ScopeRead}})
+	readerCookie := s.auth.sessions.Cookie(httptest.NewRequest("GET", "/", nil), reader)
+
+	do := func(method, url, body string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		setup(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "pw") }
+	bob := func(r *http.Request) {
+		r.AddCookie(readerCookie)
+		r.Header.Set(CSRFHeader, reader.CSRFToken)
+	}
+
+	// Tokens cannot be granted scopes their creator lacks
+	if w := do("POST", "/api/tokens", `{"scopes":["read","upload"]}`, bob); w.Code != http.StatusForbidden {
+		t.Errorf("read-only user creating an upload token: status %d, want 403", w.Code)
+	}
+	if w := do("POST", "/api/tokens", `{"scopes":["admin"]}`, admin); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope: status %d, want 400", w.Code)
+	}
+	if w := do("POST", "/api/tokens", `{"name":"bob"}`, bob); w.Code != http.StatusCreated {
+		t.Fatalf("read-only user creating a read token: status %d (%s)", w.Code, w.Body)
+	}
+
+	w := do("POST", "/api/tokens", `{"name":"ci","scopes":["read","delete"],"paths":["/docs"],"expires_in":"24h"}`, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("admin creating a token: status %d (%s)", w.Code, w.Body)
+	}
+	var adminToken struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &adminToken)
+	if w := do("POST", "/api/tokens", `{"expires_in":"-1h"}`, admin); w.Code != http.StatusBadRequest {
+		t.Errorf("negative expiry: status %d, want 400", w.Code)
+	}
+
+	// Tokens cannot manage tokens, whatever their scopes
+	withToken := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+adminToken.Token) }
+	for _, method := range []string{"GET", "POST"} {
+		if w := do(method, "/api/tokens", `{}`, withToken); w.Code != http.StatusForbidden {
+			t.Errorf("%s with a token: status %d, want 403", method, w.Code)
+		}
+	}
+
+	// Each account only sees and revokes its own tokens
+	var list struct {
+		Tokens []tokens.Token `json:"tokens"`
+	}
+	json.Unmarshal(do("GET", "/api/tokens", "", admin).Body.Bytes(), &list)
+	if len(list.Tokens) != 1 || list.Tokens[0].Name != "ci" || list.Tokens[0].Hash != "" {
+		t.Fatalf("admin tokens %+v", list.Tokens)
+	}
+	adminID := list.Tokens[0].ID
+	if w := do("DELETE", "/api/tokens/"+adminID, "", bob); w.Code != http.StatusNotFound {
+		t.Errorf("revoking another account's token: status %d, want 404", w.Code)
+	}
+	if w := do("DELETE", "/api/tokens/"+adminID, "", admin); w.Code != http.StatusOK {
+		t.Errorf("revoking own token: status %d, want 200", w.Code)
+	}
+	if w := do("GET", "/api/tokens", "", withToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want 401", w.Code)
+	}
+}
+
+func TestTokenRestrictions(t *testing.T) {
+	s, _ := tokenServer(t)
+	secret, _, err := s.tokens.Create("admin", accountBasic+"admin", tokens.Options{Scopes: []string{tokens.ScopeRead}, Paths: []string{"/docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := AuthMiddleware(s.auth, s.pathACL)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Handlers check paths given as parameters themselves
+		if p := r.URL.Query().Get("path"); p != "" && !s.isAllowed(r, p) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+		}
+	}))
+
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/api/list?path=/docs", http.StatusOK},
+		{"GET", "/api/list?path=/docs/sub", http.StatusOK},
+		{"GET", "/api/list?path=/", http.StatusForbidden},
+		{"GET", "/api/list?path=/docs-private", http.StatusForbidden},
+		{"GET", "/api/download/docs/a.txt", http.StatusOK},
+		{"GET", "/api/download/secret.txt", http.StatusForbidden},
+		{"GET", "/api/download/docs/../secret.txt", http.StatusForbidden},
+		{"POST", "/api/upload/docs", http.StatusForbidden}, // Read scope only
+		{"DELETE", "/api/delete/docs/a.txt", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d (%s)", tc.method, tc.url, w.Code, tc.want, strings.TrimSpace(w.Body.String()))
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes a token can be granted
+const (
+	ScopeRead   = "read"   // List, search and download
+	ScopeUpload = "upload" // Upload and extract
+	ScopeDelete = "delete" // Delete files and directories
+)
+
+// AllScopes are the scopes in the order they are reported
+var AllScopes = []string{ScopeRead, ScopeUpload, ScopeDelete}
+
+// Prefix starts every token, so leaked tokens are easy to recognise
+const Prefix = "ghs_"
+
+// lastUsedResolution is how stale the persisted last use of a token may be.
+// Recording every use would rewrite the file on every request.
+const lastUsedResolution = time.Minute
+
+// Errors returned by Store
+var (
+	ErrNotFound     = errors.New("token not found")
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// Token describes a token. The token itself is only returned by Create.
+type Token struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	User     string     `json:"user"`           // Name of the owner, for display
+	Owner    string     `json:"owner"`          // Account that manages the token, e.g. "oidc:<sub>"
+	Hash     string     `json:"hash,omitempty"` // SHA-256 of the token, never reported
+	Scopes   []string   `json:"scopes"`
+	Paths    []string   `json:"paths,omitempty"` // Directories the token is restricted to, empty for all
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// HasScope reports whether the token was granted scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPath reports whether p (relative to the root) is within the paths
+// the token is restricted to
+func (t *Token) AllowsPath(p string) bool {
+	if len(t.Paths) == 0 {
+		return true
+	}
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	for _, allowed := range t.Paths {
+		if allowed == "/" || p == allowed || strings.HasPrefix(p, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Token) expired(now time.Time) bool {
+	return t.Expires != nil && now.After(*t.Expires)
+}
+
+// public returns a copy of the token without its hash
+func (t *Token) public() Token {
+	c := *t
+	c.Hash = ""
+	return c
+}
+
+// Options are the properties of a new token
+type Options struct {
+	Name   string
+	Scopes []string
+	Paths  []string
+	TTL    time.Duration // 0 for no expiry
+}
+
+// Store keeps the tokens in a JSON file. Only a hash of each token is
+// stored, so the file does not grant access if it leaks.
+type Store struct {
+	file string
+
+	mu     sync.Mutex
+	tokens []*Token
+}
+
+// NewStore loads the tokens from file, which is created when the first
+// token is
+func NewStore(file string) (*Store, error) {
+	st := &Store{file: file}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &st.tokens); err != nil {
+		return nil, fmt.Errorf("invalid tokens file %s: %w", file, err)
+	}
+	for _, t := range st.tokens {
+		if t.Owner == "" {
+			// Written before owners were recorded, when only the --auth
+			// user could create tokens
+			t.Owner = "basic:" + t.User
+		}
+	}
+	return st, nil
+}
+
+// Create issues a token for the account owner, named user, and returns it
+// along with its description. The token cannot be recovered later.
+func (st *Store) Create(user, owner string, opts Options) (string, Token, error) {
+	scopes, err := NormalizeScopes(opts.Scopes)
+	if err != nil {
+		return "", Token{}, err
+	}
+	paths := make([]string, 0, len(opts.Paths))
+	for _, p := range opts.Paths {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, path.Clean("/"+p))
+		}
+	}
+
+	secret := Prefix + randomHex(32)
+	now := time.Now().UTC().Truncate(time.Second)
+	t := &Token{
+		ID:      randomHex(8),
+		Name:    strings.TrimSpace(opts.Name),
+		User:    user,
+		Owner:   owner,
+		Hash:    hash(secret),
+		Scopes:  scopes,
+		Paths:   paths,
+		Created: now,
+	}
+	if opts.TTL > 0 {
+		expires := now.Add(opts.TTL)
+		t.Expires = &expires
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.tokens = append(st.tokens, t)
+	if err := st.save(); err != nil {
+		st.tokens = st.tokens[:len(st.tokens)-1]
+		return "", Token{}, err
+	}
+	return secret, t.public(), nil
+}
+
+// List returns the tokens of the account owner, oldest first
+func (st *Store) List(owner string) []Token {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	list := []Token{}
+	for _, t := range st.tokens {
+		if t.Owner == owner {
+			list = append(list, t.public())
+		}
+	}
+	return list
+}
+
+// Revoke deletes a token of the account owner
+func (st *Store) Revoke(owner, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i, t := range st.tokens {
+		if t.ID == id && t.Owner == owner {
+			st.tokens = append(st.tokens[:i:i], st.tokens[i+1:]...)
+			return st.save()
+		}
+	}
+	return ErrNotFound
+}
+
+// Authenticate returns the token matching secret and records its use
+func (st *Store) Authenticate(secret string) (Token, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return Token{}, ErrInvalidToken
+	}
+	h := hash(secret)
+	now := time.Now().UTC()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, t := range st.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(h)) != 1 {
+			continue
+		}
+		if t.expired(now) {
+			return Token{}, ErrInvalidToken
+		}
+		if t.LastUsed == nil || now.Sub(*t.LastUsed) >= lastUsedResolution {
+			used := now.Truncate(time.Second)
+			t.LastUsed = &used
+			if err := st.save(); err != nil {
+				fmt.Printf("Warning: Failed to record token use: %v\n", err)
+			}
+		}
+		return t.public(), nil
+	}
+	return Token{}, ErrInvalidToken
+}
+
+// save writes the tokens file. st.mu must be held.
+func (st *Store) save() error {
+	data, err := json.MarshalIndent(st.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := st.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.file)
+}
+
+// NormalizeScopes validates scopes and returns them in AllScopes order,
+// defaulting to read only
+func NormalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return []string{ScopeRead}, nil
+	}
+	seen := make(map[string]bool)
+	for _, s := range scopes {
+		switch s {
+		case ScopeRead, ScopeUpload, ScopeDelete:
+			seen[s] = true
+		default:
+			return nil, fmt.Errorf("%w %q (expected read, upload or delete)", ErrUnknownScope, s)
+		}
+	}
+	var result []string
+	for _, s := range AllScopes {
+		if seen[s] {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,162 @@
+package tokens
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	for _, tc := range []struct {
+		in   []string
+		want []string
+	}{
+		{nil, []string{ScopeRead}},
+		{[]string{ScopeDelete, ScopeRead}, []string{ScopeRead, ScopeDelete}},
+		{[]string{ScopeUpload, ScopeUpload}, []string{ScopeUpload}},
+		{[]string{ScopeDelete, ScopeUpload, ScopeRead}, AllScopes},
+	} {
+		if got, err := NormalizeScopes(tc.in); err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("NormalizeScopes(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := NormalizeScopes([]string{ScopeRead, "admin"}); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownScope)
+	}
+}
+
+func TestAllowsPath(t *testing.T) {
+	for _, tc := range []struct {
+		paths   []string
+		path    string
+		allowed bool
+	}{
+		{nil, "/anything", true},
+		{[]string{"/"}, "/anything", true},
+		{[]string{"/docs"}, "/docs", true},
+		{[]string{"/docs"}, "docs/a/b.txt", true},
+		{[]string{"/docs"}, `docs\a.txt`, true},
+		{[]string{"/docs"}, "/docs-private", false},
+		{[]string{"/docs"}, "/", false},
+		{[]string{"/docs"}, "/docs/../secret", false},
+		{[]string{"/docs", "/shared"}, "/shared/x", true},
+	} {
+		tok := &Token{Paths: tc.paths}
+		if got := tok.AllowsPath(tc.path); got != tc.allowed {
+			t.Errorf("paths %q: AllowsPath(%q) = %v, want %v", tc.paths, tc.path, got, tc.allowed)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	st, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, tok, err := st.Create("alice", "ldap:cn=alice", Options{
+		Name:   " ci ",
+		Scopes: []string{ScopeUpload, ScopeRead},
+		Paths:  []string{"docs/", " ", "/a/../b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, Prefix) || tok.Hash != "" || tok.Name != "ci" {
+		t.Errorf("token %+v", tok)
+	}
+	if !slices.Equal(tok.Scopes, []string{ScopeRead, ScopeUpload}) || !slices.Equal(tok.Paths, []string{"/docs", "/b"}) {
+		t.Errorf("scopes %q, paths %q", tok.Scopes, tok.Paths)
+	}
+
+	// Only a hash is stored
+	data, _ := os.ReadFile(file)
+	if strings.Contains(string(data), secret) {
+		t.Error("the token is stored in the clear")
+	}
+
+	got, err := st.Authenticate(secret)
+	if err != nil || got.ID != tok.ID || got.Owner != "ldap:cn=alice" || got.LastUsed == nil {
+		t.Errorf("Authenticate = %+v, %v", got, err)
+	}
+	for _, bad := range []string{"", "ghs_", secret + "x", strings.TrimPrefix(secret, Prefix)} {
+		if _, err := st.Authenticate(bad); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Authenticate(%q) err = %v, want %v", bad, err, ErrInvalidToken)
+		}
+	}
+
+	// Tokens belong to accounts, not to user names
+	other, _, _ := st.Create("alice", "oidc:alice", Options{})
+	if list := st.List("ldap:cn=alice"); len(list) != 1 || list[0].ID != tok.ID {
+		t.Errorf("List = %+v", list)
+	}
+	if err := st.Revoke("oidc:alice", tok.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking another account's token: err = %v, want %v", err, ErrNotFound)
+	}
+
+	// Tokens survive a restart
+	st, err = NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Authenticate(secret); err != nil {
+		t.Errorf("after reload: %v", err)
+	}
+	if _, err := st.Authenticate(other); err != nil {
+		t.Errorf("after reload: %v", err)
+	}
+
+	if err := st.Revoke("ldap:cn=alice", tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Authenticate(secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("revoked token: err = %v, want %v", err, ErrInvalidToken)
+	}
+	if len(st.List("ldap:cn=alice")) != 0 {
+		t.Error("revoked token still listed")
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	st, err := NewStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, tok, err := st.Create("bob", "basic:bob", Options{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Expires == nil || time.Until(*tok.Expires) > time.Hour {
+		t.Fatalf("expires %v", tok.Expires)
+	}
+	if _, err := st.Authenticate(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	st.tokens[0].Expires = new(time.Time)
+	if _, err := st.Authenticate(secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expired token: err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestStoreLegacyOwner(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	os.WriteFile(file, []byte(`[{"id":"1","user":"admin","hash":"x","scopes":["read"]}]`), 0600)
+	st, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := st.List("basic:admin"); len(list) != 1 {
+		t.Errorf("tokens written without an owner belong to the --auth user, got %+v", list)
+	}
+
+	os.WriteFile(file, []byte(`{`), 0600)
+	if _, err := NewStore(file); err == nil {
+		t.Error("invalid tokens file accepted")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"gohttpserver/internal/archive"
 )
 
 // Handler implements WebDAV protocol
@@ -24,10 +26,23 @@ func NewHandler(rootDir string) *Handler {
 	}
 }
 
+// resolve maps a request path to a file below the root, applying the same
+// lexical and symlink checks as the rest of the server
+func (h *Handler) resolve(requestPath string) (string, error) {
+	cleanPath, err := archive.SanitizePath(h.rootDir, requestPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(h.rootDir, cleanPath), nil
+}
+
 // ServeHTTP handles WebDAV requests
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	fullPath := filepath.Join(h.rootDir, path)
+	fullPath, err := h.resolve(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
 
 	switch r.Method {
 	case "GET", "HEAD":
@@ -138,7 +153,11 @@ func (h *Handler) handleMove(w http.ResponseWriter, r *http.Request, fullPath st
 		return
 	}
 
-	dstPath := filepath.Join(h.rootDir, dstURL.Path)
+	dstPath, err := h.resolve(dstURL.Path)
+	if err != nil {
+		http.Error(w, "Invalid Destination header", http.StatusBadRequest)
+		return
+	}
 	if err := os.Rename(fullPath, dstPath); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -160,8 +179,12 @@ func (h *Handler) handleCopy(w http.ResponseWriter, r *http.Request, fullPath st
 		return
 	}
 
-	dstPath := filepath.Join(h.rootDir, dstURL.Path)
-	
+	dstPath, err := h.resolve(dstURL.Path)
+	if err != nil {
+		http.Error(w, "Invalid Destination header", http.StatusBadRequest)
+		return
+	}
+
 	srcInfo, err := os.Stat(fullPath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -169,7 +192,7 @@ func (h *Handler) handleCopy(w http.ResponseWriter, r *http.Request, fullPath st
 	}
 
 	if srcInfo.IsDir() {
-		if err := copyDir(fullPath, dstPath); err != nil {
+		if err := h.copyDir(fullPath, dstPath); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -302,7 +325,7 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-func copyDir(src, dst string) error {
+func (h *Handler) copyDir(src, dst string) error {
 	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -313,6 +336,14 @@ func copyDir(src, dst string) error {
 			return err
 		}
 
+		// Do not copy the targets of symlinks that may not be followed
+		if info.Mode()&os.ModeSymlink != 0 {
+			rootRel, err := filepath.Rel(h.rootDir, path)
+			if err != nil || !archive.AllowSymlink(h.rootDir, rootRel) {
+				return nil
+			}
+		}
+
 		dstPath := filepath.Join(dst, relPath)
 
 		if info.IsDir() {
@@ -0,0 +1,26 @@
+package webui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist holds the frontend build output. It is filled by copying
+// frontend/dist here before building (see the Dockerfile); when it only
+// holds the placeholder, the binary is built without a frontend.
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Embedded returns the embedded frontend, or nil if the binary was built
+// without one
+func Embedded() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil
+	}
+	return sub
+}
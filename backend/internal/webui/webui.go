@@ -0,0 +1,269 @@
+package webui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// minCompressSize is the smallest file worth compressing
+const minCompressSize = 1024
+
+// Handler serves the frontend. Embedded files are loaded into memory once,
+// with ETags and compressed variants computed up front; a development
+// directory is read on every request so rebuilds show up immediately. In both
+// cases .br and .gz files next to a file are served to clients accepting them.
+type Handler struct {
+	fsys    fs.FS
+	script  []byte
+	preload bool
+	assets  map[string]*asset
+	modTime time.Time
+}
+
+// asset is a file ready to be served and its compressed variants
+type asset struct {
+	content  []byte
+	etag     string
+	modTime  time.Time
+	variants []variant // In order of preference
+}
+
+type variant struct {
+	encoding string // Content-Encoding, e.g. "br" or "gzip"
+	content  []byte
+}
+
+// New creates a handler serving fsys. With preload, all files are read into
+// memory and precompressed; use it for immutable file systems such as the
+// embedded frontend. script, if set, is injected into the head of index.html.
+func New(fsys fs.FS, script []byte, preload bool) (*Handler, error) {
+	h := &Handler{
+		fsys:    fsys,
+		script:  script,
+		preload: preload,
+		// Embedded files carry no modification time, use the start time instead
+		modTime: time.Now(),
+	}
+	if !preload {
+		return h, nil
+	}
+
+	h.assets = make(map[string]*asset)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		h.assets[name] = newAsset(name, h.prepare(name, content), h.modTime, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer precompressed files shipped alongside the originals
+	for name, a := range h.assets {
+		for _, enc := range []struct{ ext, encoding string }{{".br", "br"}, {".gz", "gzip"}} {
+			if pre, ok := h.assets[name+enc.ext]; ok {
+				a.setVariant(enc.encoding, pre.content)
+			}
+		}
+	}
+	return h, nil
+}
+
+// newAsset prepares content for serving, gzipping it if compress is set and
+// that pays off
+func newAsset(name string, content []byte, modTime time.Time, compress bool) *asset {
+	sum := sha256.Sum256(content)
+	a := &asset{content: content, etag: `"` + hex.EncodeToString(sum[:8]) + `"`, modTime: modTime}
+	if compress && len(content) >= minCompressSize && compressible(name) {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(content)
+		zw.Close()
+		if buf.Len() < len(content) {
+			a.setVariant("gzip", buf.Bytes())
+		}
+	}
+	return a
+}
+
+// setVariant adds or replaces a compressed variant, keeping brotli first
+func (a *asset) setVariant(encoding string, content []byte) {
+	for i := range a.variants {
+		if a.variants[i].encoding == encoding {
+			a.variants[i].content = content
+			return
+		}
+	}
+	a.variants = append(a.variants, variant{encoding: encoding, content: content})
+	if encoding == "br" && len(a.variants) > 1 {
+		a.variants[0], a.variants[len(a.variants)-1] = a.variants[len(a.variants)-1], a.variants[0]
+	}
+}
+
+// compressible reports whether a file's type benefits from compression
+func compressible(name string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "text/javascript", "application/json",
+		"image/svg+xml", "application/xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// prepare applies the server configuration to a file before it is served
+func (h *Handler) prepare(name string, content []byte) []byte {
+	if path.Base(name) == "index.html" {
+		return injectScript(content, h.script)
+	}
+	return content
+}
+
+// ServeFile serves the frontend file at name, a slash-separated path without
+// leading slash ("" for index.html). It returns false, having written
+// nothing, if there is no such file.
+func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if !fs.ValidPath(name) {
+		return false
+	}
+
+	a := h.lookup(name)
+	if a == nil {
+		return false
+	}
+
+	// Bundled assets have content hashes in their names and never change
+	if isHashedAsset(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	content, etag := a.content, a.etag
+	if len(a.variants) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		accepted := r.Header.Get("Accept-Encoding")
+		for _, v := range a.variants {
+			if acceptsEncoding(accepted, v.encoding) {
+				w.Header().Set("Content-Encoding", v.encoding)
+				content = v.content
+				etag = strings.TrimSuffix(a.etag, `"`) + "-" + v.encoding + `"`
+				break
+			}
+		}
+	}
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		// Set explicitly, ServeContent would sniff the compressed bytes
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, a.modTime, bytes.NewReader(content))
+	return true
+}
+
+// ServeIndex serves index.html, e.g. for client-side routes
+func (h *Handler) ServeIndex(w http.ResponseWriter, r *http.Request) bool {
+	return h.ServeFile(w, r, "")
+}
+
+// lookup returns the file at name, or nil if there is none
+func (h *Handler) lookup(name string) *asset {
+	if h.preload {
+		return h.assets[name]
+	}
+
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	content, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		return nil
+	}
+	a := newAsset(name, h.prepare(name, content), info.ModTime(), false)
+	for _, enc := range []struct{ ext, encoding string }{{".br", "br"}, {".gz", "gzip"}} {
+		if pre, err := fs.ReadFile(h.fsys, name+enc.ext); err == nil {
+			a.setVariant(enc.encoding, pre)
+		}
+	}
+	return a
+}
+
+// hashedAssetName matches the names Vite gives bundled assets: the name, a
+// dash and an 8 character base64url content hash, which may contain dashes
+// itself, like index-Bx3-kQ_9.js
+var hashedAssetName = regexp.MustCompile(`^.+-[A-Za-z0-9_-]{8}$`)
+
+// isHashedAsset reports whether name is a bundled asset whose name contains a
+// content hash
+func isHashedAsset(name string) bool {
+	if !strings.HasPrefix(name, "assets/") {
+		return false
+	}
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	return hashedAssetName.MatchString(base)
+}
+
+// IsClientRoute reports whether a request is a browser navigation to a path
+// that should be handled by the frontend's router, e.g. /browse/docs. Paths
+// whose last segment has an extension are treated as files.
+func IsClientRoute(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	return path.Ext(path.Base(r.URL.Path)) == ""
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// injectScript adds a script to index.html, before </head> if possible
+func injectScript(content, script []byte) []byte {
+	if len(script) == 0 {
+		return content
+	}
+	for _, marker := range [][]byte{[]byte("</head>"), []byte("<body>")} {
+		if i := bytes.Index(content, marker); i >= 0 {
+			out := make([]byte, 0, len(content)+len(script))
+			out = append(out, content[:i]...)
+			out = append(out, script...)
+			return append(out, content[i:]...)
+		}
+	}
+	// Last resort: prepend to content
+	return append(append([]byte{}, script...), content...)
+}
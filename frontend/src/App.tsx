@@ -1,10 +1,19 @@
 import React, { useState, useEffect, useCallback, useRef } from 'react';
-import { listFiles, uploadFilesWithProgress } from './services/api';
+import {
+  getConfig,
+  listFiles,
+  uploadFilesWithProgress,
+  logout,
+  setCsrfToken,
+  AuthRequiredError,
+} from './services/api';
 import { Header } from './components/Header';
+import { LoginPage } from './components/LoginPage';
 import { FileList } from './components/FileList';
 import { TransferCenter } from './components/TransferCenter';
 import { formatSize } from './utils/format';
-import type { FileInfo, SearchResult } from './types';
+import { getInjectedConfig } from './utils/config';
+import type { FileInfo, LoginResponse, SearchResult, ServerConfig } from './types';
 
 const STORAGE_KEY_PATH = 'ghs-current-path';
 
@@ -62,6 +71,8 @@ const App: React.FC = () => {
   const [isLoading, setIsLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [transfers, setTransfers] = useState<Transfer[]>([]);
+  const [serverConfig, setServerConfig] = useState<ServerConfig | null>(getInjectedConfig);
+  const [needsLogin, setNeedsLogin] = useState(false);
 
   const loadFiles = useCallback(async (path: string = currentPath) => {
     const normalizedPath = normalizePath(path);
@@ -77,6 +88,9 @@ const App: React.FC = () => {
       savePathToStorage(resolvedPath);
       updatePathInUrl(resolvedPath);
     } catch (err) {
+      if (err instanceof AuthRequiredError) {
+        setNeedsLogin(true);
+      }
       const errorMessage = err instanceof Error ? err.message : '加载失败';
       setError(errorMessage);
       setFiles([]);
@@ -85,12 +99,42 @@ const App: React.FC = () => {
     }
   }, [currentPath]);
 
+  const applyConfig = useCallback((config: ServerConfig) => {
+    setServerConfig(config);
+    setCsrfToken(config.auth?.csrf_token);
+  }, []);
+
   useEffect(() => {
     const initialPath = getInitialPath();
     updatePathInUrl(initialPath);
     loadFiles(initialPath);
+    // Older servers have no /api/config; keep all actions visible then
+    getConfig().then(applyConfig).catch(() => {});
   }, []);
 
+  const handleLogin = useCallback((_result: LoginResponse) => {
+    setNeedsLogin(false);
+    getConfig().then(applyConfig).catch(() => {});
+    loadFiles();
+  }, [applyConfig, loadFiles]);
+
+  const handleLogout = useCallback(async () => {
+    try {
+      await logout();
+    } catch {
+      // The session is gone either way
+    }
+    setFiles([]);
+    setNeedsLogin(true);
+    getConfig().then(applyConfig).catch(() => {});
+  }, [applyConfig]);
+
+  // Only offer actions the server has enabled and the user was granted
+  const scopes = serverConfig?.auth.scopes;
+  const canUpload = (serverConfig ? serverConfig.features.upload : true) && (!scopes || scopes.includes('upload'));
+  const canDelete = (serverConfig ? serverConfig.features.delete : true) && (!scopes || scopes.includes('delete'));
+  const canShare = serverConfig ? serverConfig.features.share : true;
+
   const handleNavigate = useCallback((path: string) => {
     loadFiles(path);
   }, [loadFiles]);
@@ -369,6 +413,10 @@ const App: React.FC = () => {
     input.click();
   };
 
+  if (needsLogin) {
+    return <LoginPage config={serverConfig} onLogin={handleLogin} />;
+  }
+
   return (
     <div className="flex h-screen overflow-hidden bg-background-light dark:bg-background-dark font-display text-[#111318] dark:text-white">
       <main className="flex-1 flex flex-col h-full bg-background-light dark:bg-background-dark relative overflow-hidden">
@@ -393,6 +441,16 @@ const App: React.FC = () => {
                 <span className="material-symbols-outlined text-sm">list</span>
                 List
               </button>
+              {serverConfig?.auth.method === 'session' && (
+                <button
+                  className="flex items-center gap-2 px-3 py-1.5 bg-white dark:bg-[#1a2130] border border-[#f0f2f4] dark:border-[#2d3748] rounded-lg text-sm font-medium text-[#616f89] dark:text-text-muted hover:text-primary dark:hover:text-white transition-colors"
+                  onClick={handleLogout}
+                  title={serverConfig.auth.user}
+                >
+                  <span className="material-symbols-outlined text-sm">logout</span>
+                  退出登录
+                </button>
+              )}
             </div>
           </div>
 
@@ -415,40 +473,44 @@ const App: React.FC = () => {
               onNavigate={handleNavigate}
               onRefresh={() => loadFiles()}
               onError={handleError}
+              canDelete={canDelete}
+              canShare={canShare}
             />
           )}
         </div>
 
-        <div ref={menuContainerRef} className="absolute bottom-8 right-8 z-10 flex flex-col items-end gap-2">
-          {showUploadMenu && (
-            <div className="flex flex-col rounded-xl border border-[#f0f2f4] dark:border-[#2d3748] bg-white dark:bg-[#1a2130] shadow-2xl overflow-hidden min-w-[160px]">
-              <button
-                type="button"
-                className="flex items-center gap-3 px-4 py-3 text-left text-sm font-medium text-[#111318] dark:text-white hover:bg-gray-50 dark:hover:bg-white/10 transition-colors"
-                onClick={openFilePicker}
-              >
-                <span className="material-symbols-outlined text-xl text-primary">upload_file</span>
-                上传文件
-              </button>
-              <button
-                type="button"
-                className="flex items-center gap-3 px-4 py-3 text-left text-sm font-medium text-[#111318] dark:text-white hover:bg-gray-50 dark:hover:bg-white/10 transition-colors border-t border-[#f0f2f4] dark:border-[#2d3748]"
-                onClick={openFolderPicker}
-              >
-                <span className="material-symbols-outlined text-xl text-primary">folder</span>
-                上传文件夹
-              </button>
-            </div>
-          )}
-          <button
-            type="button"
-            className="size-14 bg-primary text-white rounded-full shadow-2xl flex items-center justify-center hover:scale-110 active:scale-95 transition-all shadow-primary/30"
-            onClick={() => setShowUploadMenu((v) => !v)}
-            title={showUploadMenu ? '关闭' : '上传'}
-          >
-            <span className="material-symbols-outlined text-3xl font-bold">add</span>
-          </button>
-        </div>
+        {canUpload && (
+          <div ref={menuContainerRef} className="absolute bottom-8 right-8 z-10 flex flex-col items-end gap-2">
+            {showUploadMenu && (
+              <div className="flex flex-col rounded-xl border border-[#f0f2f4] dark:border-[#2d3748] bg-white dark:bg-[#1a2130] shadow-2xl overflow-hidden min-w-[160px]">
+                <button
+                  type="button"
+                  className="flex items-center gap-3 px-4 py-3 text-left text-sm font-medium text-[#111318] dark:text-white hover:bg-gray-50 dark:hover:bg-white/10 transition-colors"
+                  onClick={openFilePicker}
+                >
+                  <span className="material-symbols-outlined text-xl text-primary">upload_file</span>
+                  上传文件
+                </button>
+                <button
+                  type="button"
+                  className="flex items-center gap-3 px-4 py-3 text-left text-sm font-medium text-[#111318] dark:text-white hover:bg-gray-50 dark:hover:bg-white/10 transition-colors border-t border-[#f0f2f4] dark:border-[#2d3748]"
+                  onClick={openFolderPicker}
+                >
+                  <span className="material-symbols-outlined text-xl text-primary">folder</span>
+                  上传文件夹
+                </button>
+              </div>
+            )}
+            <button
+              type="button"
+              className="size-14 bg-primary text-white rounded-full shadow-2xl flex items-center justify-center hover:scale-110 active:scale-95 transition-all shadow-primary/30"
+              onClick={() => setShowUploadMenu((v) => !v)}
+              title={showUploadMenu ? '关闭' : '上传'}
+            >
+              <span className="material-symbols-outlined text-3xl font-bold">add</span>
+            </button>
+          </div>
+        )}
         <input
           ref={fileInputRef}
           type="file"
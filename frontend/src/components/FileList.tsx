@@ -9,6 +9,8 @@ interface FileListProps {
   onNavigate: (path: string) => void;
   onRefresh: () => void;
   onError: (error: string) => void;
+  canDelete?: boolean;
+  canShare?: boolean;
 }
 
 const getFileIcon = (fileName: string, isDir: boolean): string => {
@@ -70,6 +72,8 @@ export const FileList: React.FC<FileListProps> = ({
   onNavigate,
   onRefresh,
   onError,
+  canDelete = true,
+  canShare = true,
 }) => {
   const [copiedPath, setCopiedPath] = useState<string | null>(null);
   
@@ -321,27 +325,31 @@ export const FileList: React.FC<FileListProps> = ({
                   <span className="material-symbols-outlined text-xl">download</span>
                 </button>
               )}
-              <button
-                className={`text-[#616f89] dark:text-text-muted hover:text-primary transition-colors ${
-                  copiedPath === file.path ? 'text-primary' : ''
-                }`}
-                title={copiedPath === file.path ? '已复制' : '分享'}
-                onClick={(e) => {
-                  e.stopPropagation();
-                  handleShare(file);
-                }}
-              >
-                <span className="material-symbols-outlined text-xl">
-                  {copiedPath === file.path ? 'check_circle' : 'share'}
-                </span>
-              </button>
-              <button
-                className="text-[#616f89] dark:text-text-muted hover:text-red-500 transition-colors"
-                title="Delete"
-                onClick={() => handleDelete(file.path, file.name)}
-              >
-                <span className="material-symbols-outlined text-xl">delete</span>
-              </button>
+              {canShare && (
+                <button
+                  className={`text-[#616f89] dark:text-text-muted hover:text-primary transition-colors ${
+                    copiedPath === file.path ? 'text-primary' : ''
+                  }`}
+                  title={copiedPath === file.path ? '已复制' : '分享'}
+                  onClick={(e) => {
+                    e.stopPropagation();
+                    handleShare(file);
+                  }}
+                >
+                  <span className="material-symbols-outlined text-xl">
+                    {copiedPath === file.path ? 'check_circle' : 'share'}
+                  </span>
+                </button>
+              )}
+              {canDelete && (
+                <button
+                  className="text-[#616f89] dark:text-text-muted hover:text-red-500 transition-colors"
+                  title="Delete"
+                  onClick={() => handleDelete(file.path, file.name)}
+                >
+                  <span className="material-symbols-outlined text-xl">delete</span>
+                </button>
+              )}
             </div>
           </div>
         ))}
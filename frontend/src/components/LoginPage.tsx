@@ -0,0 +1,110 @@
+import React, { useState } from 'react';
+import { login } from '../services/api';
+import type { LoginResponse, ServerConfig } from '../types';
+
+interface LoginPageProps {
+  config: ServerConfig | null;
+  onLogin: (result: LoginResponse) => void;
+}
+
+export const LoginPage: React.FC<LoginPageProps> = ({ config, onLogin }) => {
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const passwordLogin = !config || config.auth.mode === 'basic';
+  const ssoLogin = config?.auth.oidc ?? false;
+  const ssoHref = `/api/oidc/login?redirect=${encodeURIComponent(
+    window.location.pathname + window.location.search + window.location.hash
+  )}`;
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setIsSubmitting(true);
+    setError(null);
+    try {
+      onLogin(await login(username, password));
+    } catch (err) {
+      setError(err instanceof Error ? err.message : '登录失败');
+      setPassword('');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  return (
+    <div className="flex h-screen items-center justify-center bg-background-light dark:bg-background-dark font-display text-[#111318] dark:text-white">
+      <form
+        onSubmit={handleSubmit}
+        className="w-full max-w-sm bg-white dark:bg-[#1a2130] rounded-xl border border-[#f0f2f4] dark:border-[#2d3748] shadow-sm p-8 flex flex-col gap-4"
+      >
+        <div className="flex items-center gap-3 mb-2">
+          <span className="material-symbols-outlined text-3xl text-primary">lock</span>
+          <h1 className="text-xl font-bold">登录</h1>
+        </div>
+
+        {error && (
+          <div className="bg-red-50 dark:bg-red-500/10 border border-red-200 dark:border-red-500/20 text-red-700 dark:text-red-400 px-4 py-3 rounded-lg text-sm">
+            {error}
+          </div>
+        )}
+
+        {passwordLogin && (
+          <>
+            <label className="flex flex-col gap-1 text-sm font-medium text-[#616f89] dark:text-text-muted">
+              用户名
+              <input
+                type="text"
+                autoComplete="username"
+                autoFocus
+                required
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                className="px-3 py-2 rounded-lg border border-[#f0f2f4] dark:border-[#2d3748] bg-transparent text-[#111318] dark:text-white focus:outline-none focus:border-primary"
+              />
+            </label>
+
+            <label className="flex flex-col gap-1 text-sm font-medium text-[#616f89] dark:text-text-muted">
+              密码
+              <input
+                type="password"
+                autoComplete="current-password"
+                required
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                className="px-3 py-2 rounded-lg border border-[#f0f2f4] dark:border-[#2d3748] bg-transparent text-[#111318] dark:text-white focus:outline-none focus:border-primary"
+              />
+            </label>
+
+            <button
+              type="submit"
+              disabled={isSubmitting}
+              className="mt-2 px-4 py-2 bg-primary text-white rounded-lg text-sm font-medium hover:opacity-90 disabled:opacity-50 transition-opacity"
+            >
+              {isSubmitting ? '登录中...' : '登录'}
+            </button>
+          </>
+        )}
+
+        {config?.auth.mode === 'certificate' && (
+          <p className="text-sm text-[#616f89] dark:text-text-muted">
+            此服务器需要有效的客户端证书，请在浏览器中安装证书后重新访问。
+          </p>
+        )}
+
+        {ssoLogin && (
+          <a
+            href={ssoHref}
+            className={`px-4 py-2 rounded-lg text-sm font-medium text-center transition-opacity hover:opacity-90 ${
+              passwordLogin
+                ? 'border border-[#f0f2f4] dark:border-[#2d3748] text-[#111318] dark:text-white'
+                : 'mt-2 bg-primary text-white'
+            }`}
+          >
+            使用单点登录 (SSO)
+          </a>
+        )}
+      </form>
+    </div>
+  );
+};
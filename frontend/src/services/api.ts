@@ -5,15 +5,42 @@ import type {
   SearchResponse,
   UploadResponse,
   DeleteResponse,
+  ServerConfig,
+  LoginResponse,
 } from '../types';
 
 const API_BASE = '/api';
 
+// Thrown when the server requires a login
+export class AuthRequiredError extends Error {
+  constructor() {
+    super('需要登录');
+    this.name = 'AuthRequiredError';
+  }
+}
+
+// CSRF token of the current session, sent with state-changing requests
+let csrfToken: string | null = null;
+
+export function setCsrfToken(token: string | null | undefined) {
+  csrfToken = token || null;
+}
+
+// Headers for API requests. X-Requested-With keeps the browser from showing
+// its Basic Auth prompt on 401, the login page is shown instead.
+function apiHeaders(method: string = 'GET'): Record<string, string> {
+  const headers: Record<string, string> = { 'X-Requested-With': 'XMLHttpRequest' };
+  if (csrfToken && !['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+    headers['X-CSRF-Token'] = csrfToken;
+  }
+  return headers;
+}
+
 // Helper function to handle fetch errors
 async function handleResponse<T>(response: Response): Promise<T> {
   if (!response.ok) {
     if (response.status === 401) {
-      throw new Error('需要认证，请使用支持 Basic Auth 的客户端');
+      throw new AuthRequiredError();
     }
     if (response.status === 403) {
       throw new Error('访问被拒绝');
@@ -24,10 +51,41 @@ async function handleResponse<T>(response: Response): Promise<T> {
   return response.json();
 }
 
+// Get server capabilities and the current user
+export async function getConfig(): Promise<ServerConfig> {
+  const response = await fetch(`${API_BASE}/config`, { headers: apiHeaders() });
+  return handleResponse<ServerConfig>(response);
+}
+
+// Log in and start a session
+export async function login(username: string, password: string): Promise<LoginResponse> {
+  const response = await fetch(`${API_BASE}/login`, {
+    method: 'POST',
+    headers: { ...apiHeaders('POST'), 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username, password }),
+  });
+  if (response.status === 401) {
+    throw new Error('用户名或密码错误');
+  }
+  const result = await handleResponse<LoginResponse>(response);
+  setCsrfToken(result.csrf_token);
+  return result;
+}
+
+// End the current session
+export async function logout(): Promise<void> {
+  const response = await fetch(`${API_BASE}/logout`, {
+    method: 'POST',
+    headers: apiHeaders('POST'),
+  });
+  await handleResponse<{ success: boolean }>(response);
+  setCsrfToken(null);
+}
+
 // List files in a directory
 export async function listFiles(path: string = '/'): Promise<ListResponse> {
   const url = `${API_BASE}/list?path=${encodeURIComponent(path)}`;
-  const response = await fetch(url);
+  const response = await fetch(url, { headers: apiHeaders() });
   return handleResponse<ListResponse>(response);
 }
 
@@ -37,7 +95,7 @@ export async function searchFiles(
   maxResults: number = 100
 ): Promise<SearchResponse> {
   const url = `${API_BASE}/search?q=${encodeURIComponent(query)}&max=${maxResults}`;
-  const response = await fetch(url);
+  const response = await fetch(url, { headers: apiHeaders() });
   return handleResponse<SearchResponse>(response);
 }
 
@@ -74,7 +132,7 @@ export function uploadFilesWithProgress(
             }
           } else {
             if (xhr.status === 401) {
-              reject(new Error('需要认证，请使用支持 Basic Auth 的客户端'));
+              reject(new AuthRequiredError());
             } else if (xhr.status === 403) {
               reject(new Error('访问被拒绝'));
             } else if (xhr.status === 408 || xhr.status === 504) {
@@ -103,6 +161,9 @@ export function uploadFilesWithProgress(
         });
 
         xhr.open('POST', `${API_BASE}/upload`);
+        for (const [name, value] of Object.entries(apiHeaders('POST'))) {
+          xhr.setRequestHeader(name, value);
+        }
         // Set timeout to 30 minutes (1800000ms) for large file uploads
         xhr.timeout = 30 * 60 * 1000;
         xhr.send(formData);
@@ -124,6 +185,7 @@ export async function uploadFiles(
 
   const response = await fetch(`${API_BASE}/upload`, {
     method: 'POST',
+    headers: apiHeaders('POST'),
     body: formData,
   });
 
@@ -137,6 +199,7 @@ export async function deleteFile(path: string): Promise<DeleteResponse> {
   const url = `${API_BASE}/delete${normalizedPath}`;
   const response = await fetch(url, {
     method: 'DELETE',
+    headers: apiHeaders('DELETE'),
   });
   return handleResponse<DeleteResponse>(response);
 }
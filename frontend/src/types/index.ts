@@ -6,11 +6,20 @@ export interface FileInfo {
   is_dir: boolean;
   size: number;
   mod_time: string;
+  modified?: string;
+  mode?: string;
+  mime_type?: string;
+  is_symlink?: boolean;
+  link_target?: string;
+  extra?: Record<string, unknown>;
 }
 
 export interface ListResponse {
   path: string;
   files: FileInfo[];
+  total?: number;
+  offset?: number;
+  limit?: number;
 }
 
 export interface SearchResult {
@@ -36,3 +45,38 @@ export interface DeleteResponse {
   success: boolean;
   path: string;
 }
+
+export interface ServerConfig {
+  version: string;
+  baseURL: string;
+  features: {
+    upload: boolean;
+    delete: boolean;
+    extract: boolean;
+    webdav: boolean;
+    share: boolean;
+    search_index: boolean;
+    tokens?: boolean;
+  };
+  limits: {
+    max_search_results: number;
+    max_content_file_size: number;
+    max_disk_usage_depth: number;
+  };
+  auth: {
+    mode: string;
+    authenticated: boolean;
+    user?: string;
+    oidc?: boolean;
+    method?: string;
+    scopes?: string[];
+    csrf_token?: string;
+  };
+}
+
+export interface LoginResponse {
+  success: boolean;
+  user: string;
+  expires: string;
+  csrf_token: string;
+}
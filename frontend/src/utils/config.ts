@@ -1,6 +1,20 @@
 // Configuration utility for frontend
 // Supports backend-injected config for custom base URL
 
+import type { ServerConfig } from '../types';
+
+/**
+ * Get the server configuration injected into index.html, if any.
+ * The full configuration (including the current user) is served by /api/config.
+ */
+export function getInjectedConfig(): ServerConfig | null {
+  const backendConfig = (window as any).__GOHTTPSERVER_CONFIG__;
+  if (backendConfig && typeof backendConfig === 'object' && backendConfig.features) {
+    return backendConfig as ServerConfig;
+  }
+  return null;
+}
+
 /**
  * Get the base URL for API and sharing
  * Priority: